@@ -0,0 +1,79 @@
+package karta
+
+import "sync/atomic"
+
+// globalWorkers is the worker budget shared by the executors of every pipeline in the process
+// globalWorkers 是进程中所有管道的执行器共享的工作协程预算
+var globalWorkers workerBudget
+
+// workerBudget bounds the total number of executor goroutines across pipelines, sharing the slots fairly among them
+// workerBudget 限制所有管道的执行器协程总数，并在管道之间公平地分配名额
+type workerBudget struct {
+	limit     atomic.Int64 // maximum number of executors, 0 means no limit / 执行器的最大数量，0 表示不限制
+	running   atomic.Int64 // executors of all pipelines / 所有管道的执行器数量
+	pipelines atomic.Int64 // pipelines sharing the budget / 共享预算的管道数量
+}
+
+// SetGlobalWorkerLimit bounds the total number of worker goroutines of all pipelines in the process, n less than or equal
+// to 0 removes the limit (default). Each pipeline keeps its own ceiling from WithWorkerNumber or SetMaxWorkers, and on top
+// of it may only grow to its fair share of the limit, the limit divided by the number of running pipelines. Once the limit
+// is reached, idle workers above their pipeline's fair share exit on their next scan so other pipelines can take their slots.
+// The first worker of a pipeline is always started, so every pipeline makes progress even when there are more pipelines than slots.
+// SetGlobalWorkerLimit 限制进程中所有管道的工作协程总数，n 小于等于 0 时取消限制（默认）。每个管道仍受 WithWorkerNumber 或
+// SetMaxWorkers 设置的上限约束，并且最多只能增长到其公平份额，即限制除以运行中的管道数量。达到限制后，超过所在管道公平份额的空闲工作协程会在
+// 下一次扫描时退出，使其他管道可以使用这些名额。管道的第一个工作协程总会启动，因此即使管道数量多于名额，每个管道也都能继续处理。
+func SetGlobalWorkerLimit(n int) {
+	if n < 0 {
+		n = 0
+	}
+	globalWorkers.limit.Store(int64(n))
+}
+
+// fairShare returns how many workers a single pipeline may run under limit, never less than one
+// fairShare 返回在 limit 下单个管道可以运行的工作协程数量，至少为 1
+func (b *workerBudget) fairShare(limit int64) int64 {
+	pipelines := b.pipelines.Load()
+	if pipelines < 1 {
+		pipelines = 1
+	}
+	if share := (limit + pipelines - 1) / pipelines; share > 1 {
+		return share
+	}
+	return 1
+}
+
+// acquire reserves a slot for an extra worker of a pipeline currently running the given number of workers
+// acquire 为当前运行给定数量工作协程的管道预留一个额外工作协程的名额
+func (b *workerBudget) acquire(running int64) bool {
+	limit := b.limit.Load()
+	if limit <= 0 {
+		b.running.Add(1)
+		return true
+	}
+	if running >= b.fairShare(limit) {
+		return false
+	}
+	for {
+		current := b.running.Load()
+		if current >= limit {
+			return false
+		}
+		if b.running.CompareAndSwap(current, current+1) {
+			return true
+		}
+	}
+}
+
+// release gives back the slot of an exiting worker
+// release 归还退出的工作协程的名额
+func (b *workerBudget) release() {
+	b.running.Add(-1)
+}
+
+// surplus reports whether a pipeline running the given number of workers should give a worker back, which is only the
+// case while the limit is reached and the pipeline runs more than its fair share
+// surplus 判断运行给定数量工作协程的管道是否应该归还一个工作协程，只有在达到限制且该管道运行的数量超过其公平份额时才需要归还
+func (b *workerBudget) surplus(running int64) bool {
+	limit := b.limit.Load()
+	return limit > 0 && b.running.Load() >= limit && running > b.fairShare(limit)
+}
@@ -0,0 +1,156 @@
+package karta
+
+import (
+	"sync"
+	"time"
+)
+
+// Ticker 是 Clock 创建的周期性定时器
+// Ticker is a periodic timer created by a Clock
+type Ticker = interface {
+	// C 返回定时器触发时接收时间的通道
+	// C returns the channel receiving the time on every tick
+	C() <-chan time.Time
+
+	// Stop 停止定时器，之后不会再触发
+	// Stop stops the ticker, no more ticks are sent after it returns
+	Stop()
+}
+
+// Clock 是 Pipeline 使用的时间来源，测试中可以注入 FakeClock 来确定性地推进时间
+// Clock is the time source used by Pipeline, tests can inject a FakeClock to advance time deterministically
+type Clock = interface {
+	// Now 返回当前时间
+	// Now returns the current time
+	Now() time.Time
+
+	// NewTicker 创建一个每隔 d 触发一次的定时器
+	// NewTicker creates a ticker firing every d
+	NewTicker(d time.Duration) Ticker
+
+	// After 返回一个在 d 之后接收时间的通道
+	// After returns a channel receiving the time after d
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock 是基于 time 包的 Clock
+// realClock is a Clock backed by the time package
+type realClock struct{}
+
+// NewRealClock 创建一个基于 time 包的 Clock，这是默认的时间来源
+// NewRealClock creates a Clock backed by the time package, which is the default time source
+func NewRealClock() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{ticker: time.NewTicker(d)} }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// realTicker 是基于 time.Ticker 的 Ticker
+// realTicker is a Ticker backed by time.Ticker
+type realTicker struct{ ticker *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.ticker.C }
+
+func (t realTicker) Stop() { t.ticker.Stop() }
+
+// fakeTimer 是 FakeClock 创建的定时器，period 为 0 时只触发一次
+// fakeTimer is a timer created by FakeClock, it fires only once if period is 0
+type fakeTimer struct {
+	clock  *FakeClock
+	ch     chan time.Time
+	next   time.Time
+	period time.Duration
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() { t.clock.remove(t) }
+
+// FakeClock 是一个只在调用 Advance 时前进的 Clock，用于在测试中确定性地触发工作协程的空闲超时和任务截止时间等逻辑，
+// 它驱动的范围见 WithClock，任务超时（WithTaskTimeout）仍使用真实时间。与 time.Ticker 一样，接收方来不及读取时会丢弃多余的触发
+// FakeClock is a Clock that only moves forward when Advance is called, it is used to trigger logic such as the worker idle
+// timeout and task deadlines deterministically in tests, see WithClock for what it drives. The task timeout (WithTaskTimeout)
+// still uses real time. Like time.Ticker, ticks are dropped if the receiver falls behind
+type FakeClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers map[*fakeTimer]struct{}
+}
+
+// NewFakeClock 创建一个从 now 开始的 FakeClock
+// NewFakeClock creates a FakeClock starting at now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, timers: make(map[*fakeTimer]struct{})}
+}
+
+// Now 返回 FakeClock 的当前时间
+// Now returns the current time of the FakeClock
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// NewTicker 创建一个在时间每前进 d 时触发一次的定时器
+// NewTicker creates a ticker firing every time the clock moves forward by d
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	return c.add(d, d)
+}
+
+// After 返回一个在时间前进 d 之后接收时间的通道
+// After returns a channel receiving the time once the clock has moved forward by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.add(d, 0).ch
+}
+
+// Advance 将时间向前推进 d，并触发所有到期的定时器
+// Advance moves the time forward by d and fires every timer that is due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+	for t := range c.timers {
+		if t.next.After(c.now) {
+			continue
+		}
+
+		select {
+		case t.ch <- c.now:
+		default:
+		}
+
+		// One-shot timers are done, tickers are rescheduled after the current time
+		// 一次性定时器触发后移除，周期定时器重新安排到当前时间之后
+		if t.period <= 0 {
+			delete(c.timers, t)
+			continue
+		}
+		for !t.next.After(c.now) {
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+// add registers a timer firing after d, repeating every period if it is positive
+// add 注册一个在 d 之后触发的定时器，period 大于 0 时周期性触发
+func (c *FakeClock) add(d, period time.Duration) *fakeTimer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t := &fakeTimer{clock: c, ch: make(chan time.Time, 1), next: c.now.Add(d), period: period}
+	c.timers[t] = struct{}{}
+	return t
+}
+
+// remove unregisters the timer
+// remove 注销定时器
+func (c *FakeClock) remove(t *fakeTimer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.timers, t)
+}
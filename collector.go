@@ -0,0 +1,69 @@
+package karta
+
+import (
+	"sort"
+	"sync"
+)
+
+// TaskResult 描述一个任务的处理结果
+// TaskResult describes the outcome of a task
+type TaskResult struct {
+	Input  any   // message submitted to the pipeline / 提交到管道的消息
+	Output any   // result returned by the handler / 处理函数返回的结果
+	Err    error // error returned by the handler / 处理函数返回的错误
+}
+
+// ResultCollector 按提交顺序收集 Pipeline 的任务结果。它会保留所有已收集的结果直到 Reset 被调用，
+// 因此内存占用随结果数量增长
+// ResultCollector collects the task results of a Pipeline in submission order. It keeps every collected
+// result until Reset is called, so its memory grows with the number of results
+type ResultCollector struct {
+	lock    sync.Mutex
+	results map[int64]TaskResult // results keyed by submission sequence / 以提交序号为键的结果
+}
+
+// NewResultCollector creates a new result collector
+// NewResultCollector 创建一个新的结果收集器
+func NewResultCollector() *ResultCollector {
+	return &ResultCollector{
+		results: make(map[int64]TaskResult),
+	}
+}
+
+// add stores the result of the task with the given submission sequence
+// add 保存给定提交序号的任务结果
+func (c *ResultCollector) add(seq int64, result TaskResult) {
+	c.lock.Lock()
+	c.results[seq] = result
+	c.lock.Unlock()
+}
+
+// OrderedResults returns the results collected so far in submission order, even though processing was concurrent
+// OrderedResults 按提交顺序返回目前已收集的结果，即使任务是并发处理的
+func (c *ResultCollector) OrderedResults() []TaskResult {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Sort the submission sequences
+	// 对提交序号进行排序
+	seqs := make([]int64, 0, len(c.results))
+	for seq := range c.results {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	results := make([]TaskResult, len(seqs))
+	for i, seq := range seqs {
+		results[i] = c.results[seq]
+	}
+
+	return results
+}
+
+// Reset drops all collected results
+// Reset 丢弃所有已收集的结果
+func (c *ResultCollector) Reset() {
+	c.lock.Lock()
+	c.results = make(map[int64]TaskResult)
+	c.lock.Unlock()
+}
@@ -1,6 +1,12 @@
 package karta
 
-import "math"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
 
 // 定义默认的最小和最大工作者数量
 // Define the default minimum and maximum number of workers
@@ -18,12 +24,84 @@ var (
 	// 默认的消息处理函数，返回接收到的消息和nil错误
 	// Default message handle function, returns the received message and a nil error
 	DefaultMsgHandleFunc = func(msg any) (any, error) { return msg, nil }
+
+	// 工作者数量超出有效范围的错误
+	// Error of a worker number out of the valid range
+	ErrInvalidWorkerNumber = fmt.Errorf("worker number must be between %d and %d", defaultMinWorkerNum, defaultMaxWorkerNum)
+
+	// 配置中存在无效设置的错误
+	// Error of a configuration holding an invalid setting
+	ErrInvalidConfig = errors.New("invalid config")
 )
 
 // 定义消息处理函数类型
 // Define the message handle function type
 type MessageHandleFunc = func(msg any) (any, error)
 
+// 定义可感知上下文的消息处理函数类型
+// Define the context-aware message handle function type
+type ContextMessageHandleFunc = func(ctx context.Context, msg any) (any, error)
+
+// 定义扇出处理函数类型，它为每条消息返回任意数量的输出
+// Define the fan-out handle function type, it returns any number of outputs for each message
+type FanOutHandleFunc = func(msg any) ([]any, error)
+
+// 定义包装消息处理函数的中间件类型
+// Define the middleware type wrapping a message handle function
+type Middleware = func(next MessageHandleFunc) MessageHandleFunc
+
+// 定义追踪函数类型，它为消息开始一个追踪区间，返回传递给处理函数的上下文和以处理结果结束该区间的函数
+// Define the tracer function type, it starts a span for the message and returns the context passed to the handler and
+// the function ending the span with the outcome
+type Tracer = func(ctx context.Context, msg any) (context.Context, func(err error))
+
+// AckMode 定义 Pipeline 调用队列 Done 方法确认元素的时机
+// AckMode defines when Pipeline calls the Done method of the queue to acknowledge an element
+type AckMode int
+
+const (
+	// AckBefore 在处理元素之前确认，即“最多一次”语义，处理过程中崩溃会丢失消息
+	// AckBefore acknowledges the element before handling it, giving at-most-once semantics, a crash during handling loses the message
+	AckBefore AckMode = iota
+
+	// AckAfter 在处理函数返回之后确认，即“至少一次”语义。对于能在崩溃后恢复未确认元素的队列，
+	// 消息可能会被重复处理，因此处理函数需要是幂等的
+	// AckAfter acknowledges the element after the handler returns, giving at-least-once semantics. With queues that
+	// redeliver unacknowledged elements after a crash a message may be processed more than once, so handlers should be idempotent
+	AckAfter
+)
+
+// DedupMode 定义 Pipeline 处理重复消息的方式
+// DedupMode defines how Pipeline handles duplicate messages
+type DedupMode int
+
+const (
+	// DedupDrop 丢弃重复的消息，提交方法返回 ErrDuplicate
+	// DedupDrop drops duplicate messages, the submit methods return ErrDuplicate
+	DedupDrop DedupMode = iota
+
+	// DedupCoalesce 将重复的消息合并到已有的任务中，提交方法返回 nil，等待结果的提交者收到已有任务的结果
+	// DedupCoalesce merges duplicate messages into the existing task, the submit methods return nil and submitters
+	// waiting for a result receive the outcome of the existing task
+	DedupCoalesce
+)
+
+// GetMode 定义 Pipeline 的工作协程在队列为空时如何等待新的元素
+// GetMode defines how the workers of Pipeline wait for new elements when the queue is empty
+type GetMode int
+
+const (
+	// GetBlocking 在队列为空时等待到下一次工作协程扫描再调用 Get，适用于 Get 会阻塞或入队频繁的队列
+	// GetBlocking waits until the next worker scan before calling Get again when the queue is empty, suited to queues whose Get
+	// blocks or that are fed steadily
+	GetBlocking GetMode = iota
+
+	// GetPolling 在队列为空时按轮询间隔重新调用 Get，适用于 Get 不阻塞、在为空时立即返回错误的队列
+	// GetPolling calls Get again every poll interval when the queue is empty, suited to queues whose Get does not block and
+	// returns an error right away when empty
+	GetPolling
+)
+
 // Config 是一个结构体，用于配置消息处理的参数
 // Config is a struct used to configure parameters for message processing
 type Config struct {
@@ -43,6 +121,174 @@ type Config struct {
 	// handleFunc 是一个 MessageHandleFunc 类型的变量，表示消息处理函数
 	// handleFunc is a variable of type MessageHandleFunc, which represents the message handling function
 	handleFunc MessageHandleFunc
+
+	// ctxHandleFunc 是一个 ContextMessageHandleFunc 类型的变量，表示可感知上下文的消息处理函数，设置后优先于 handleFunc
+	// ctxHandleFunc is a variable of type ContextMessageHandleFunc, which represents the context-aware message handling function, it takes precedence over handleFunc when set
+	ctxHandleFunc ContextMessageHandleFunc
+
+	// fanOut 是扇出处理函数，设置后 Pipeline 会将其返回的每个输出作为新任务重新提交
+	// fanOut is the fan-out handler, once set Pipeline re-submits each of its outputs as a new task
+	fanOut FanOutHandleFunc
+
+	// middleware 是按顺序包装实际处理函数的中间件，第一个中间件位于最外层
+	// middleware is the middleware wrapping the effective handler in order, the first one is the outermost
+	middleware []Middleware
+
+	// tracer 是在每次调用处理函数时开始追踪区间的函数，为 nil 时不追踪
+	// tracer is the function starting a span around every handler call, nothing is traced if nil
+	tracer Tracer
+
+	// spawnRate 是每秒允许创建的工作协程数量，仅适用于 Pipeline
+	// spawnRate is the number of workers allowed to be spawned per second, only applies to Pipeline
+	spawnRate float64
+
+	// spawnBurst 是突发情况下允许一次性创建的工作协程数量，仅适用于 Pipeline
+	// spawnBurst is the number of workers allowed to be spawned at once in a burst, only applies to Pipeline
+	spawnBurst int
+
+	// processRate 是每秒允许处理的任务数量，小于等于 0 表示不限制，仅适用于 Pipeline
+	// processRate is the number of tasks allowed to be processed per second, less than or equal to 0 means no limit, only applies to Pipeline
+	processRate float64
+
+	// processBurst 是突发情况下允许一次性处理的任务数量，仅适用于 Pipeline
+	// processBurst is the number of tasks allowed to be processed at once in a burst, only applies to Pipeline
+	processBurst int
+
+	// taskWeight 返回任务在创建工作协程时消耗的令牌数量，为 nil 时每个任务消耗 1 个令牌，仅适用于 Pipeline
+	// taskWeight returns the number of tokens a task consumes when spawning a worker, each task consumes 1 token if nil, only applies to Pipeline
+	taskWeight func(msg any) int
+
+	// taskTimeout 是单个任务处理的超时时间，小于等于 0 表示不限制
+	// taskTimeout is the timeout of processing a single task, less than or equal to 0 means no limit
+	taskTimeout time.Duration
+
+	// retryAttempts 是任务失败时的最大尝试次数（包含首次执行），仅适用于 Pipeline
+	// retryAttempts is the maximum number of attempts (including the first one) for a failed task, only applies to Pipeline
+	retryAttempts int
+
+	// retryBackoff 是任务失败后重新提交前的等待时间，仅适用于 Pipeline
+	// retryBackoff is the time to wait before a failed task is re-submitted, only applies to Pipeline
+	retryBackoff time.Duration
+
+	// deadLetter 是任务用完所有尝试次数后仍然失败时调用的函数，为 nil 时不调用
+	// deadLetter is the function called when a task still fails after using all its attempts, it is not called if nil
+	deadLetter func(msg any, lastErr error)
+
+	// collector 是按提交顺序收集任务结果的收集器，仅适用于 Pipeline
+	// collector is the collector gathering task results in submission order, only applies to Pipeline
+	collector *ResultCollector
+
+	// ackMode 是确认元素的时机，仅适用于 Pipeline
+	// ackMode is when elements are acknowledged, only applies to Pipeline
+	ackMode AckMode
+
+	// getMode 是工作协程在队列为空时等待新元素的方式，仅适用于 Pipeline
+	// getMode is how workers wait for new elements when the queue is empty, only applies to Pipeline
+	getMode GetMode
+
+	// getPollInterval 是 GetPolling 模式下重新调用 Get 的间隔，仅适用于 Pipeline
+	// getPollInterval is the interval at which Get is called again in the GetPolling mode, only applies to Pipeline
+	getPollInterval time.Duration
+
+	// preemption 表示是否允许高优先级任务抢占运行中的低优先级任务，仅适用于 Pipeline
+	// preemption indicates whether high-priority tasks may preempt running low-priority tasks, only applies to Pipeline
+	preemption bool
+
+	// preemptRequeue 表示被抢占的任务是否重新入队，仅适用于 Pipeline
+	// preemptRequeue indicates whether preempted tasks are re-queued, only applies to Pipeline
+	preemptRequeue bool
+
+	// persistentWorkers 表示是否使用在多次调用之间复用的常驻工作协程，仅适用于 Group
+	// persistentWorkers indicates whether long-lived workers reused across calls are used, only applies to Group
+	persistentWorkers bool
+
+	// noIdleReaping 表示是否关闭空闲工作协程的回收，关闭后不启动计时器协程，工作协程一直运行到 Stop，仅适用于 Pipeline
+	// noIdleReaping indicates whether reaping idle workers is disabled, the timer goroutine is then not started and workers keep running until Stop, only applies to Pipeline
+	noIdleReaping bool
+
+	// orderedCallbacks 表示是否按提交顺序调用 OnBefore 和 OnAfter，仅适用于 Pipeline
+	// orderedCallbacks indicates whether OnBefore and OnAfter are called in submission order, only applies to Pipeline
+	orderedCallbacks bool
+
+	// concurrencyLimit 是同时执行处理函数的最大数量，与工作者数量无关，小于等于 0 表示不限制，仅适用于 Group
+	// concurrencyLimit is the maximum number of concurrent handler calls, independent of the worker number, less than or equal to 0 means no limit, only applies to Group
+	concurrencyLimit int
+
+	// chunkSize 是 Group 每次准备和处理的输入元素数量，小于等于 0 表示一次处理全部输入，仅适用于 Group
+	// chunkSize is the number of input elements Group prepares and processes at a time, less than or equal to 0 means the whole input at once, only applies to Group
+	chunkSize int
+
+	// failFast 表示 Group 是否在任意处理函数返回错误时立即取消本次调用剩余的任务，仅适用于 Group
+	// failFast indicates whether Group cancels the remaining tasks of a call as soon as any handler returns an error, only applies to Group
+	failFast bool
+
+	// nonNilResult 表示 Group 的 Map 系列方法在输入为空时是否返回空切片而不是 nil，仅适用于 Group
+	// nonNilResult indicates whether the Map methods of Group return an empty slice instead of nil for an empty input, only applies to Group
+	nonNilResult bool
+
+	// dedupKey 是计算消息去重键的函数，为 nil 时不去重，仅适用于 Pipeline
+	// dedupKey is the function computing the dedup key of a message, no deduplication if nil, only applies to Pipeline
+	dedupKey func(msg any) string
+
+	// dedupMode 是处理重复消息的方式，仅适用于 Pipeline
+	// dedupMode is how duplicate messages are handled, only applies to Pipeline
+	dedupMode DedupMode
+
+	// resultChan 是接收任务结果的通道，仅适用于 Pipeline
+	// resultChan is the channel receiving task results, only applies to Pipeline
+	resultChan chan<- TaskResult
+
+	// resultChanBlock 表示结果通道已满时是否阻塞等待，否则丢弃结果，仅适用于 Pipeline
+	// resultChanBlock indicates whether to block when the result channel is full instead of dropping the result, only applies to Pipeline
+	resultChanBlock bool
+
+	// sink 是分批接收任务结果的函数，为 nil 时不分批输出结果，仅适用于 Pipeline
+	// sink is the function receiving task results in batches, results are not batched if nil, only applies to Pipeline
+	sink func(batch []TaskResult) error
+
+	// sinkBatchSize 是触发 sink 调用的批次大小，仅适用于 Pipeline
+	// sinkBatchSize is the batch size triggering a sink call, only applies to Pipeline
+	sinkBatchSize int
+
+	// sinkInterval 是未满的批次被输出到 sink 的间隔，小于等于 0 表示只在批次已满或停止时输出，仅适用于 Pipeline
+	// sinkInterval is the interval at which a partial batch is flushed to sink, less than or equal to 0 means flushing only when the batch is full or on stop, only applies to Pipeline
+	sinkInterval time.Duration
+
+	// elementPool 是 Pipeline 获取和归还元素的对象池，为 nil 时使用默认的对象池，仅适用于 Pipeline
+	// elementPool is the pool Pipeline takes elements from and returns them to, the default pool is used if nil, only applies to Pipeline
+	elementPool ElementPooler
+
+	// maxPending 是允许的最大待完成任务数量，小于等于 0 表示不限制，仅适用于 Pipeline
+	// maxPending is the maximum number of pending tasks allowed, less than or equal to 0 means no limit, only applies to Pipeline
+	maxPending int64
+
+	// logger 是输出内部调试和警告日志的日志记录器
+	// logger is the logger outputting internal debug and warning logs
+	logger Logger
+
+	// name 是用于在日志和指标中区分实例的名称
+	// name is the name used to tell instances apart in logs and metrics
+	name string
+
+	// strictHandler 表示未设置处理函数时是否返回 ErrNoHandler，而不是使用原样返回输入的默认处理函数
+	// strictHandler indicates whether ErrNoHandler is returned when no handler is set, instead of using the default handler echoing the input
+	strictHandler bool
+
+	// singleWorker 表示是否只使用一个工作者，它会覆盖 num
+	// singleWorker indicates whether only one worker is used, it overrides num
+	singleWorker bool
+
+	// timing 表示是否记录处理函数的耗时分布
+	// timing indicates whether the distribution of handler durations is recorded
+	timing bool
+
+	// clock 是 Pipeline 使用的时间来源，默认为基于 time 包的时间来源
+	// clock is the time source used by Pipeline, default is the one backed by the time package
+	clock Clock
+
+	// jitterSource 返回 [0, n) 范围内的随机数，用于 SubmitAfterJitter 选择延迟，为 nil 时使用 math/rand 的全局随机源
+	// jitterSource returns a random number in [0, n), it is used by SubmitAfterJitter to pick delays, the math/rand global source is used if nil
+	jitterSource func(n int64) int64
 }
 
 // NewConfig 是一个函数，用于创建并返回一个新的 Config 结构体的指针
@@ -57,9 +303,25 @@ func NewConfig() *Config {
 		// callback is a variable of type Callback, used for callback functions before and after handling messages, default is empty
 		callback: NewEmptyCallback(),
 
-		// handleFunc 是一个 MessageHandleFunc 类型的变量，用于处理消息的函数，默认为 DefaultMsgHandleFunc
-		// handleFunc is a variable of type MessageHandleFunc, used for the function to handle messages, default is DefaultMsgHandleFunc
-		handleFunc: DefaultMsgHandleFunc,
+		// logger 是一个 Logger 类型的变量，用于输出内部日志，默认不输出任何内容
+		// logger is a variable of type Logger, used for outputting internal logs, default outputs nothing
+		logger: NewEmptyLogger(),
+
+		// clock 是一个 Clock 类型的变量，用于获取时间和创建定时器，默认使用 time 包
+		// clock is a variable of type Clock, used for reading the time and creating timers, default uses the time package
+		clock: NewRealClock(),
+
+		// spawnRate 是每秒创建工作协程的速率，默认为 defaultWorkerSpawnRate
+		// spawnRate is the rate of spawning workers per second, default is defaultWorkerSpawnRate
+		spawnRate: float64(defaultWorkerSpawnRate),
+
+		// spawnBurst 是创建工作协程的突发上限，默认为 defaultWorkerBurstLimit
+		// spawnBurst is the burst limit of spawning workers, default is defaultWorkerBurstLimit
+		spawnBurst: defaultWorkerBurstLimit,
+
+		// retryAttempts 是任务的最大尝试次数，默认为 1，即不重试
+		// retryAttempts is the maximum number of attempts of a task, default is 1, which means no retry
+		retryAttempts: 1,
 	}
 }
 
@@ -81,6 +343,61 @@ func (c *Config) WithCallback(callback Callback) *Config {
 // WithHandleFunc is a method used to set the handleFunc variable in the Config struct
 func (c *Config) WithHandleFunc(fn MessageHandleFunc) *Config {
 	c.handleFunc = fn
+	c.fanOut = nil
+	return c
+}
+
+// WithContextHandleFunc 是一个方法，用于设置可感知上下文的消息处理函数，设置后优先于 WithHandleFunc 设置的处理函数。
+// 处理函数收到的上下文会在任务被取消时（例如提交时的上下文被取消或 Group 停止）结束
+// WithContextHandleFunc is a method used to set the context-aware message handling function, it takes precedence over the one set by WithHandleFunc.
+// The context received by the handler is done when the task is cancelled (e.g. the submission context is cancelled or the Group is stopped)
+func (c *Config) WithContextHandleFunc(fn ContextMessageHandleFunc) *Config {
+	c.ctxHandleFunc = fn
+	return c
+}
+
+// WithFanOutHandler 是一个方法，用于将 fn 设置为默认处理函数，使每条消息可以产生多个输出，类似 flatMap。
+// Pipeline 会将 fn 返回的每个输出作为新任务重新提交，新任务同样由 fn 处理，因此 fn 必须最终对每条消息返回空切片，否则处理永远不会结束。
+// 新任务在管道排空时也会被处理，但在管道停止后或超过 WithMaxPending 时会被丢弃并输出警告日志。
+// Group 不会重新提交，每个输入的结果就是 fn 返回的切片。它替换 WithHandleFunc 设置的处理函数，WithContextHandleFunc 设置的处理函数仍然优先，
+// 通过 SubmitWithFunc 等方式使用自定义处理函数的任务不会扇出
+// WithFanOutHandler is a method used to set fn as the default handler, letting each message produce several outputs like flatMap.
+// Pipeline re-submits each output returned by fn as a new task, which is handled by fn as well, so fn must eventually return
+// an empty slice for every message or the processing never terminates. The new tasks are also handled while the pipeline is
+// draining, but they are dropped with a warning log once the pipeline is stopped or WithMaxPending is exceeded. Group does not
+// re-submit, the result of each input is the slice returned by fn. It replaces the handler set by WithHandleFunc, the one set by
+// WithContextHandleFunc still takes precedence, and tasks running a custom handler, such as with SubmitWithFunc, do not fan out
+func (c *Config) WithFanOutHandler(fn FanOutHandleFunc) *Config {
+	c.fanOut = fn
+	c.handleFunc = nil
+	if fn != nil {
+		c.handleFunc = func(msg any) (any, error) {
+			items, err := fn(msg)
+			return items, err
+		}
+	}
+	return c
+}
+
+// WithMiddleware 是一个方法，用于添加包装实际处理函数的中间件，例如日志、追踪和指标，同时适用于 Group 和 Pipeline。
+// 中间件按添加顺序包装处理函数，第一个中间件位于最外层。它们在超时控制和 panic 恢复的范围内运行，多次调用会追加中间件
+// WithMiddleware is a method used to add middleware wrapping the effective handler, such as logging, tracing and metrics, it applies
+// to both Group and Pipeline. Middleware wraps the handler in the order it is added, the first one being the outermost. It runs within
+// the task timeout and the panic recovery, and repeated calls append to the chain
+func (c *Config) WithMiddleware(mw ...Middleware) *Config {
+	c.middleware = append(c.middleware, mw...)
+	return c
+}
+
+// WithTracer 是一个方法，用于设置追踪函数，使任务可以接入 OpenTelemetry 等追踪系统而无需依赖它们。每次调用处理函数之前调用 start，
+// 它可以从消息中读取追踪上下文并开始一个区间，返回的上下文会传递给可感知上下文的处理函数，返回的函数会在处理函数返回后以其错误结束该区间，
+// 没有需要结束的区间时可以返回 nil。区间覆盖任务超时和中间件，重试的每次尝试各有一个区间，同时适用于 Group 和 Pipeline
+// WithTracer is a method used to set the tracer, so tasks can be wired into tracing systems such as OpenTelemetry without
+// depending on them. start is called before every handler call, it can read a trace context carried on the message and start a span.
+// The returned context is passed to context-aware handlers, and the returned function ends the span with the handler error once it
+// returns, it may be nil when there is no span to end. The span covers the task timeout and the middleware, every retry attempt gets its own span, and it applies to both Group and Pipeline
+func (c *Config) WithTracer(start Tracer) *Config {
+	c.tracer = start
 	return c
 }
 
@@ -91,6 +408,324 @@ func (c *Config) WithResult() *Config {
 	return c
 }
 
+// WithWorkerSpawnRate 是一个方法，用于设置 Pipeline 创建工作协程的速率和突发上限
+// WithWorkerSpawnRate is a method used to set the rate and burst limit at which Pipeline spawns workers
+func (c *Config) WithWorkerSpawnRate(perSecond float64, burst int) *Config {
+	c.spawnRate = perSecond
+	c.spawnBurst = burst
+	return c
+}
+
+// WithProcessingRate 是一个方法，用于限制 Pipeline 每秒处理的任务数量，与工作协程数量无关，例如用于保护下游接口。
+// 每次调用处理函数之前都会等待令牌，等待期间任务的上下文结束则任务以该错误失败，burst 小于 1 时按 1 处理。重试的每次尝试都会消耗令牌，仅适用于 Pipeline
+// WithProcessingRate is a method used to cap the number of tasks Pipeline processes per second regardless of the worker number, for
+// example to protect a downstream API. Every handler call waits for a token first, a task whose context is done while waiting fails
+// with that error, and a burst less than 1 is treated as 1. Every retry attempt consumes a token, only applies to Pipeline
+func (c *Config) WithProcessingRate(perSecond float64, burst int) *Config {
+	c.processRate = perSecond
+	c.processBurst = burst
+	return c
+}
+
+// WithTaskWeight 是一个方法，用于设置任务的权重。提交任务后尝试创建工作协程时，会从创建速率限制器中预留 fn(msg) 个令牌，
+// 因此开销大的任务会更快地耗尽创建预算。权重小于 1 时按 1 处理，权重大于突发上限的任务不会触发创建新的工作协程。仅适用于 Pipeline
+// WithTaskWeight is a method used to set the weight of tasks. When a worker spawn is attempted after a submission, fn(msg) tokens
+// are reserved from the spawn rate limiter, so expensive tasks use up the spawn budget faster. Weights below 1 count as 1, and
+// a task weighing more than the burst limit never triggers a worker spawn. It only applies to Pipeline
+func (c *Config) WithTaskWeight(fn func(msg any) int) *Config {
+	c.taskWeight = fn
+	return c
+}
+
+// WithTaskTimeout 是一个方法，用于设置单个任务处理的超时时间。超时后 OnAfter 将收到 ErrTaskTimeout，
+// 处理函数的迟到结果会被丢弃。注意：不响应超时的处理函数仍会继续运行，可能导致协程泄漏
+// WithTaskTimeout is a method used to set the timeout of processing a single task. On expiry OnAfter receives ErrTaskTimeout
+// and the late result of the handler is discarded. Note: handlers that ignore the timeout keep running and may leak goroutines
+func (c *Config) WithTaskTimeout(timeout time.Duration) *Config {
+	c.taskTimeout = timeout
+	return c
+}
+
+// WithRetry 是一个方法，用于设置失败任务的最大尝试次数（包含首次执行）和重试的退避时间。
+// 失败的任务会在退避时间后重新提交，只有最后一次尝试的结果会传递给 OnAfter
+// WithRetry is a method used to set the maximum number of attempts (including the first one) and the backoff of failed tasks.
+// A failed task is re-submitted after the backoff, only the outcome of the last attempt is delivered to OnAfter
+func (c *Config) WithRetry(maxAttempts int, backoff time.Duration) *Config {
+	c.retryAttempts = maxAttempts
+	c.retryBackoff = backoff
+	return c
+}
+
+// WithDeadLetter 是一个方法，用于设置死信函数。任务在最后一次允许的尝试（见 WithRetry）中仍然失败时，会在 OnAfter 之后调用 fn，
+// 以便保存有害的消息供之后检查。因管道停止、任务被取消或超过截止时间而提前停止重试的任务不会调用它。它也适用于 Group 的 MapWithRetry
+// WithDeadLetter is a method used to set the dead-letter function. When a task still fails on its last allowed attempt (see
+// WithRetry), fn is called after OnAfter, so poison messages can be persisted for later inspection. Tasks whose retries are cut
+// short because the pipeline stopped, the task was cancelled or its deadline passed are not dead-lettered. It also applies to Group MapWithRetry
+func (c *Config) WithDeadLetter(fn func(msg any, lastErr error)) *Config {
+	c.deadLetter = fn
+	return c
+}
+
+// WithResultCollector 是一个方法，用于设置按提交顺序收集 Pipeline 任务结果的收集器
+// WithResultCollector is a method used to set the collector gathering Pipeline task results in submission order
+func (c *Config) WithResultCollector(collector *ResultCollector) *Config {
+	c.collector = collector
+	return c
+}
+
+// WithAckMode 是一个方法，用于设置 Pipeline 确认元素的时机，默认为 AckBefore
+// WithAckMode is a method used to set when Pipeline acknowledges elements, default is AckBefore
+func (c *Config) WithAckMode(mode AckMode) *Config {
+	c.ackMode = mode
+	return c
+}
+
+// WithGetMode 是一个方法，用于设置工作协程在队列为空时等待新元素的方式，默认为 GetBlocking。
+// GetPolling 的轮询间隔由 WithGetPollInterval 设置，仅适用于 Pipeline
+// WithGetMode is a method used to set how workers wait for new elements when the queue is empty, default is GetBlocking.
+// The poll interval of GetPolling is set by WithGetPollInterval, only applies to Pipeline
+func (c *Config) WithGetMode(mode GetMode) *Config {
+	c.getMode = mode
+	return c
+}
+
+// WithGetPollInterval 是一个方法，用于设置 GetPolling 模式下队列为空时重新调用 Get 的间隔，默认为 defaultGetPollInterval，仅适用于 Pipeline
+// WithGetPollInterval is a method used to set the interval at which Get is called again on an empty queue in the GetPolling mode,
+// default is defaultGetPollInterval, only applies to Pipeline
+func (c *Config) WithGetPollInterval(d time.Duration) *Config {
+	c.getPollInterval = d
+	return c
+}
+
+// WithPreemption 是一个方法，用于允许高优先级任务抢占运行中的低优先级任务。当工作协程数量达到上限且全部忙碌时，
+// SubmitWithPriority 会取消优先级最低的运行中任务的上下文，requeue 为 true 时被抢占的任务会重新入队，否则 OnAfter 收到 ErrTaskPreempted。
+// 只有可感知上下文的处理函数才能被抢占
+// WithPreemption is a method used to allow high-priority tasks to preempt running low-priority tasks. When the workers are at the
+// limit and all busy, SubmitWithPriority cancels the context of the lowest-priority running task, which is re-queued if requeue is true,
+// otherwise OnAfter receives ErrTaskPreempted. Only context-aware handlers can be preempted
+func (c *Config) WithPreemption(requeue bool) *Config {
+	c.preemption = true
+	c.preemptRequeue = requeue
+	return c
+}
+
+// WithPersistentWorkers 是一个方法，用于让 NewGroup 启动常驻的工作协程，在多次 Map 调用之间复用，避免每次调用都创建协程。
+// 常驻的工作协程在 Stop 时退出，仅适用于 Group
+// WithPersistentWorkers is a method used to make NewGroup start long-lived workers reused across Map calls, so that calls do
+// not pay the goroutine creation cost. The workers exit on Stop, only applies to Group
+func (c *Config) WithPersistentWorkers() *Config {
+	c.persistentWorkers = true
+	return c
+}
+
+// WithNoIdleReaping 是一个方法，用于关闭空闲工作协程的回收。管道不再启动每秒更新一次的计时器协程，已创建的工作协程一直运行到 Stop，
+// 适合大量短生命周期的管道。SetMaxWorkers 降低上限后仍会回收多余的工作协程，仅适用于 Pipeline
+// WithNoIdleReaping is a method used to disable reaping idle workers. The pipeline no longer starts the timer goroutine ticking every
+// second, and spawned workers keep running until Stop, which suits many short-lived pipelines. Surplus workers are still reaped
+// after SetMaxWorkers lowers the ceiling, only applies to Pipeline
+func (c *Config) WithNoIdleReaping() *Config {
+	c.noIdleReaping = true
+	return c
+}
+
+// WithOrderedCallbacks 是一个方法，用于让 Pipeline 按提交顺序调用回调函数。任务完成后，OnBefore 和 OnAfter 会依次调用，
+// 但会等到所有更早提交的任务都完成之后，并且回调函数不会并发执行。先完成的任务结果会保留在内存中，直到其之前的任务完成，
+// 因此一个缓慢的任务或延迟任务会使之后所有任务的回调被推迟，并占用与其之后完成的任务数量成正比的内存，仅适用于 Pipeline
+// WithOrderedCallbacks is a method used to make Pipeline call back in submission order. Once a task completes, OnBefore and
+// OnAfter are called back to back, but only after every task submitted earlier has completed, and callbacks never run
+// concurrently. Outcomes completed early are held in memory until the tasks before them complete, so a slow or delayed task
+// holds back the callbacks of every later task and costs memory proportional to the tasks completed after it, only applies to Pipeline
+func (c *Config) WithOrderedCallbacks() *Config {
+	c.orderedCallbacks = true
+	return c
+}
+
+// WithConcurrencyLimit 是一个方法，用于限制同时执行处理函数的数量，与工作者数量无关。例如可以运行 50 个工作者，但只允许 5 个同时访问数据库。
+// 小于等于 0 表示不限制（默认），仅适用于 Group
+// WithConcurrencyLimit is a method used to limit the number of concurrent handler calls independently of the worker number, for
+// example to run 50 workers but only 5 concurrent database calls. A value less than or equal to 0 means no limit (default),
+// only applies to Group
+func (c *Config) WithConcurrencyLimit(n int) *Config {
+	c.concurrencyLimit = n
+	return c
+}
+
+// WithChunkSize 是一个方法，用于让 Group 按每 n 个元素一个窗口依次处理输入，窗口内并发处理，并在窗口之间复用同一个小的元素缓冲区，
+// 从而限制超大输入的峰值内存。结果仍与输入对齐，一个窗口全部完成后才开始下一个窗口。小于等于 0 表示一次处理全部输入（默认），仅适用于 Group
+// WithChunkSize is a method used to make Group process the input in windows of n elements one after another, each window
+// concurrently, reusing one small element buffer across windows, which bounds the peak memory for very large inputs. Results
+// stay aligned with the input, and a window starts once the previous one has completed. A value less than or equal to 0 means
+// the whole input at once (default), only applies to Group
+func (c *Config) WithChunkSize(n int) *Config {
+	c.chunkSize = n
+	return c
+}
+
+// WithFailFast 是一个方法，用于在任意处理函数返回错误时立即取消本次 Map、MapContext、MapInto、MapTimeout 或 MapErr 调用剩余的任务，
+// 适用于全部成功才有意义的批量校验。返回部分结果，MapErr 只返回触发取消的错误。工作组本身不会停止，默认处理全部任务，仅适用于 Group
+// WithFailFast is a method used to cancel the remaining tasks of a Map, MapContext, MapInto, MapTimeout or MapErr call as soon as
+// any handler returns an error, which suits all-or-nothing batch validations. Partial results are returned, and MapErr returns only
+// the error that triggered the cancellation. The group itself is not stopped, all tasks are processed by default, only applies to Group
+func (c *Config) WithFailFast() *Config {
+	c.failFast = true
+	return c
+}
+
+// WithNonNilResult 是一个方法，使 Map、MapContext、MapTimeout 和 MapErr 在输入为空时返回 []any{} 而不是 nil，
+// 从而区分“已执行但没有元素”和“未执行”。工作组停止后仍然返回 nil，默认返回 nil，仅适用于 Group
+// WithNonNilResult is a method used to make Map, MapContext, MapTimeout and MapErr return []any{} instead of nil for an empty
+// input, telling "ran with no items" apart from "not run". A stopped group still returns nil, nil is returned by default,
+// only applies to Group
+func (c *Config) WithNonNilResult() *Config {
+	c.nonNilResult = true
+	return c
+}
+
+// WithResultChannel 是一个方法，用于设置接收任务结果的通道，每个任务完成后（OnAfter 之后）都会向其发送一个 TaskResult。
+// 默认情况下通道已满时结果会被丢弃，以免阻塞工作协程；需要不丢失结果时使用 WithResultChannelBlocking。
+// 管道不会关闭该通道，Stop 返回后不会再发送结果，仅适用于 Pipeline
+// WithResultChannel is a method used to set the channel receiving task results, a TaskResult is sent to it after every task
+// completes (after OnAfter). By default results are dropped when the channel is full so that workers are never blocked, use
+// WithResultChannelBlocking to avoid losing results. The pipeline never closes the channel and sends nothing after Stop returns,
+// only applies to Pipeline
+func (c *Config) WithResultChannel(ch chan<- TaskResult) *Config {
+	c.resultChan = ch
+	return c
+}
+
+// WithResultChannelBlocking 是一个方法，用于在结果通道已满时阻塞工作协程直到结果被接收或管道停止，而不是丢弃结果。
+// 消费过慢会反压整个管道，仅适用于 Pipeline
+// WithResultChannelBlocking is a method used to block the worker when the result channel is full until the result is received
+// or the pipeline stops, instead of dropping the result. A slow consumer applies backpressure to the whole pipeline, only applies to Pipeline
+func (c *Config) WithResultChannelBlocking() *Config {
+	c.resultChanBlock = true
+	return c
+}
+
+// WithSink 是一个方法，用于将完成的任务结果（OnAfter 之后）按批次交给 sink，而不是逐个回调。批次达到 batchSize 个结果或距上次输出
+// 经过 flushInterval 时调用 sink，停止管道时会输出最后未满的批次。sink 不会被并发调用，其返回的错误会作为警告日志输出，
+// 调用期间完成的任务会等待其返回。batchSize 小于 1 时视为 1，flushInterval 小于等于 0 时只在批次已满或停止时输出，仅适用于 Pipeline
+// WithSink is a method used to hand completed task results (after OnAfter) to sink in batches instead of one by one. sink is called
+// once batchSize results are accumulated or flushInterval has elapsed since the last flush, and the final partial batch is flushed
+// when the pipeline stops. sink is never called concurrently and the error it returns is logged as a warning, tasks completing
+// during a call wait for it to return. A batchSize less than 1 is treated as 1, and a flushInterval less than or equal to 0 means
+// flushing only when the batch is full or on stop, only applies to Pipeline
+func (c *Config) WithSink(sink func(batch []TaskResult) error, batchSize int, flushInterval time.Duration) *Config {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	c.sink = sink
+	c.sinkBatchSize = batchSize
+	c.sinkInterval = flushInterval
+	return c
+}
+
+// WithDedup 是一个方法，用于启用消息去重：键与排队中或处理中的任务相同的消息会按 WithDedupMode 设置的方式被丢弃或合并，
+// 任务最终完成后其键被释放。keyFn 对同一消息必须返回相同的键，仅适用于 Pipeline
+// WithDedup is a method used to enable message deduplication: a message whose key matches a queued or handled task is dropped
+// or coalesced as set by WithDedupMode, and the key is freed once the task finally completes. keyFn must return the same key
+// for the same message, only applies to Pipeline
+func (c *Config) WithDedup(keyFn func(msg any) string) *Config {
+	c.dedupKey = keyFn
+	return c
+}
+
+// WithDedupMode 是一个方法，用于设置处理重复消息的方式，默认为 DedupDrop，仅适用于 Pipeline
+// WithDedupMode is a method used to set how duplicate messages are handled, default is DedupDrop, only applies to Pipeline
+func (c *Config) WithDedupMode(mode DedupMode) *Config {
+	c.dedupMode = mode
+	return c
+}
+
+// WithElementPool 是一个方法，用于设置 Pipeline 获取和归还元素的对象池，例如为大消息提供按大小定制的对象池。
+// 元素在归还前会被重置，因此不会持有已处理的消息，仅适用于 Pipeline
+// WithElementPool is a method used to set the pool Pipeline takes elements from and returns them to, for example a pool
+// sized for large messages. Elements are reset before they are returned, so they never hold processed messages, only applies to Pipeline
+func (c *Config) WithElementPool(pool ElementPooler) *Config {
+	c.elementPool = pool
+	return c
+}
+
+// WithMaxPending 是一个方法，用于设置允许的最大待完成任务数量（已提交但尚未处理完成）。达到上限后 Submit 返回 ErrQueueFull，
+// SubmitBlocking 则等待直到有空余容量。小于等于 0 表示不限制，仅适用于 Pipeline
+// WithMaxPending is a method used to set the maximum number of pending (submitted but not yet completed) tasks. Once reached, Submit
+// returns ErrQueueFull while SubmitBlocking waits until capacity is available. Less than or equal to 0 means no limit, only applies to Pipeline
+func (c *Config) WithMaxPending(n int) *Config {
+	c.maxPending = int64(n)
+	return c
+}
+
+// WithLogger 是一个方法，用于设置输出内部调试和警告日志的日志记录器
+// WithLogger is a method used to set the logger outputting internal debug and warning logs
+func (c *Config) WithLogger(logger Logger) *Config {
+	c.logger = logger
+	return c
+}
+
+// WithSingleWorker 是一个方法，用于开启单工作者模式，覆盖 WithWorkerNumber 设置的工作者数量，只使用一个工作者。
+// Group 在调用协程上按输入顺序依次处理元素，回调顺序是确定的；Pipeline 最多运行一个工作协程，任务按出队顺序依次处理
+// WithSingleWorker is a method used to enable the single worker mode, which overrides the worker number set by WithWorkerNumber
+// and uses only one worker. Group processes the elements sequentially in input order on the calling goroutine, which makes
+// callback ordering deterministic, and Pipeline runs at most one worker, handling the tasks one by one in dequeue order
+func (c *Config) WithSingleWorker() *Config {
+	c.singleWorker = true
+	return c
+}
+
+// WithStrictHandler 是一个方法，用于开启严格处理函数模式。开启后，未设置处理函数（WithHandleFunc 或 WithContextHandleFunc）时
+// 不再使用原样返回输入的 DefaultMsgHandleFunc：Pipeline 的 Submit 返回 ErrNoHandler，Group 的每个元素以 ErrNoHandler 结束
+// WithStrictHandler is a method used to enable the strict handler mode. Once enabled, DefaultMsgHandleFunc echoing the input is no
+// longer used when no handler is set (WithHandleFunc or WithContextHandleFunc): Pipeline Submit returns ErrNoHandler and each
+// Group element completes with ErrNoHandler
+func (c *Config) WithStrictHandler() *Config {
+	c.strictHandler = true
+	return c
+}
+
+// WithTiming 是一个方法，用于开启处理函数耗时的记录，记录的耗时分布可以通过 Durations 获取。未开启时没有额外开销
+// WithTiming is a method used to enable recording handler durations, the recorded distribution is returned by Durations.
+// There is no overhead when it is not enabled
+func (c *Config) WithTiming() *Config {
+	c.timing = true
+	return c
+}
+
+// WithClock 是一个方法，用于设置 Pipeline 的时间来源，它驱动工作协程的空闲超时、任务截止时间、工作协程创建速率、处理函数耗时的测量和 StopWithTimeout。
+// 测试中可以注入 FakeClock 来确定性地推进这些逻辑。延迟提交由队列自己调度，不受其影响，仅适用于 Pipeline
+// WithClock is a method used to set the time source of Pipeline, which drives the worker idle timeout, task deadlines, the
+// worker spawn rate, the measured handler durations and StopWithTimeout. Tests can inject a FakeClock to advance this logic deterministically. Delayed
+// submissions are scheduled by the queue itself and are not affected, only applies to Pipeline
+func (c *Config) WithClock(clock Clock) *Config {
+	c.clock = clock
+	return c
+}
+
+// WithJitterSource 是一个方法，用于设置 SubmitAfterJitter 使用的随机源，fn 返回 [0, n) 范围内的随机数，并且必须可以被并发调用。
+// 默认使用 math/rand 的全局随机源，测试中可以注入固定的随机源以获得确定的延迟，仅适用于 Pipeline
+// WithJitterSource is a method used to set the random source used by SubmitAfterJitter, fn returns a random number in [0, n) and
+// must be safe for concurrent use. The math/rand global source is used by default, tests can inject a fixed source to get
+// deterministic delays, only applies to Pipeline
+func (c *Config) WithJitterSource(fn func(n int64) int64) *Config {
+	c.jitterSource = fn
+	return c
+}
+
+// WithName 是一个方法，用于设置在日志和指标中区分 Group 或 Pipeline 实例的名称，该名称会作为日志行的前缀
+// WithName is a method used to set the name telling Group or Pipeline instances apart in logs and metrics, the name prefixes log lines
+func (c *Config) WithName(name string) *Config {
+	c.name = name
+	return c
+}
+
+// logPrefix 返回日志行的前缀，设置了名称时包含名称
+// logPrefix returns the prefix of log lines, including the name if set
+func (c *Config) logPrefix() string {
+	if c.name == "" {
+		return "karta"
+	}
+	return "karta[" + c.name + "]"
+}
+
 // DefaultConfig 创建一个默认的配置
 // DefaultConfig creates a default configuration
 func DefaultConfig() *Config {
@@ -99,17 +734,155 @@ func DefaultConfig() *Config {
 	return NewConfig()
 }
 
+// Validate 检查配置中会被构造函数静默修正的设置，返回描述所有问题的错误，配置有效时返回 nil，不会修改配置。
+// 返回的错误可以通过 errors.Is 与 ErrInvalidWorkerNumber、ErrNoHandler 或 ErrInvalidConfig 匹配。nil 配置被视为默认配置，总是有效的
+// Validate checks the settings that constructors silently correct, returning an error describing every problem, or nil if the
+// configuration is valid, without modifying it. The returned error matches ErrInvalidWorkerNumber, ErrNoHandler or ErrInvalidConfig
+// with errors.Is. A nil configuration stands for the default one and is always valid
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	var errs []error
+	if !c.singleWorker && !isWorkerNumberValid(c.num) {
+		errs = append(errs, fmt.Errorf("%w, got %d", ErrInvalidWorkerNumber, c.num))
+	}
+	if c.strictHandler && c.handleFunc == nil && c.ctxHandleFunc == nil {
+		errs = append(errs, fmt.Errorf("%w: strict handler mode requires WithHandleFunc or WithContextHandleFunc", ErrNoHandler))
+	}
+	if c.spawnRate <= 0 || c.spawnBurst <= 0 {
+		errs = append(errs, fmt.Errorf("%w: worker spawn rate and burst must be positive, got %v and %d", ErrInvalidConfig, c.spawnRate, c.spawnBurst))
+	}
+	if c.retryAttempts < 1 {
+		errs = append(errs, fmt.Errorf("%w: retry attempts must be at least 1, got %d", ErrInvalidConfig, c.retryAttempts))
+	}
+	if c.retryBackoff < 0 {
+		errs = append(errs, fmt.Errorf("%w: retry backoff must not be negative, got %v", ErrInvalidConfig, c.retryBackoff))
+	}
+	if c.ackMode != AckBefore && c.ackMode != AckAfter {
+		errs = append(errs, fmt.Errorf("%w: unknown ack mode %d", ErrInvalidConfig, c.ackMode))
+	}
+	if c.dedupMode != DedupDrop && c.dedupMode != DedupCoalesce {
+		errs = append(errs, fmt.Errorf("%w: unknown dedup mode %d", ErrInvalidConfig, c.dedupMode))
+	}
+	if c.getMode != GetBlocking && c.getMode != GetPolling {
+		errs = append(errs, fmt.Errorf("%w: unknown get mode %d", ErrInvalidConfig, c.getMode))
+	}
+
+	return joinErrors(errs...)
+}
+
+// ConfigView 是 Config 中设置的只读快照，用于诊断和测试断言。函数、回调函数等不可比较的设置不包含在内
+// ConfigView is a read-only snapshot of the settings of a Config, for diagnostics and test assertions. Settings that
+// are not comparable values, such as functions and callbacks, are not included
+type ConfigView struct {
+	WorkerNumber      int           // 工作者数量 Number of workers
+	Result            bool          // 是否收集结果 Whether results are collected
+	TaskTimeout       time.Duration // 单个任务的超时时间 Timeout of a single task
+	RetryAttempts     int           // 任务的最大尝试次数 Maximum number of attempts of a task
+	RetryBackoff      time.Duration // 重试之间的退避时间 Backoff between retries
+	SpawnRate         float64       // 每秒创建工作协程的速率 Rate of spawning workers per second
+	SpawnBurst        int           // 创建工作协程的突发上限 Burst limit of spawning workers
+	ProcessRate       float64       // 每秒处理任务的速率 Rate of processing tasks per second
+	ProcessBurst      int           // 处理任务的突发上限 Burst limit of processing tasks
+	AckMode           AckMode       // 确认元素的时机 When elements are acknowledged
+	GetMode           GetMode       // 队列为空时等待新元素的方式 How workers wait on an empty queue
+	GetPollInterval   time.Duration // GetPolling 模式下的轮询间隔 Poll interval of the GetPolling mode
+	DedupMode         DedupMode     // 处理重复消息的方式 How duplicate messages are handled
+	Dedup             bool          // 是否启用去重 Whether deduplication is enabled
+	Preemption        bool          // 是否允许抢占 Whether preemption is allowed
+	PreemptRequeue    bool          // 被抢占的任务是否重新入队 Whether preempted tasks are re-queued
+	PersistentWorkers bool          // 是否使用常驻工作协程 Whether persistent workers are used
+	NoIdleReaping     bool          // 是否关闭空闲工作协程的回收 Whether reaping idle workers is disabled
+	OrderedCallbacks  bool          // 是否按提交顺序调用回调函数 Whether callbacks are called in submission order
+	ConcurrencyLimit  int           // 同时执行处理函数的最大数量 Maximum number of concurrent handler calls
+	ChunkSize         int           // 每个窗口的输入元素数量 Number of input elements per window
+	FailFast          bool          // 是否在错误时取消剩余的任务 Whether remaining tasks are cancelled on error
+	NonNilResult      bool          // 空输入是否返回空切片 Whether an empty input returns an empty slice
+	ResultChanBlock   bool          // 结果通道已满时是否阻塞 Whether to block on a full result channel
+	SinkBatchSize     int           // 触发 sink 调用的批次大小 Batch size triggering a sink call
+	SinkInterval      time.Duration // 未满批次的输出间隔 Interval flushing partial batches
+	MaxPending        int64         // 允许的最大待完成任务数量 Maximum number of pending tasks
+	Name              string        // 实例名称 Instance name
+	StrictHandler     bool          // 是否开启严格处理函数模式 Whether the strict handler mode is enabled
+	SingleWorker      bool          // 是否开启单工作者模式 Whether the single worker mode is enabled
+	Timing            bool          // 是否记录处理函数耗时 Whether handler durations are recorded
+}
+
+// Snapshot 返回配置中设置的只读快照。构造函数会就地修正无效的设置，因此在构造 Group 或 Pipeline 之后调用时，快照反映实际生效的设置
+// Snapshot returns a read-only snapshot of the settings of the configuration. Constructors correct invalid settings in place,
+// so a snapshot taken after building a Group or Pipeline reflects the settings in effect
+func (c *Config) Snapshot() ConfigView {
+	return ConfigView{
+		WorkerNumber:      c.num,
+		Result:            c.result,
+		TaskTimeout:       c.taskTimeout,
+		RetryAttempts:     c.retryAttempts,
+		RetryBackoff:      c.retryBackoff,
+		SpawnRate:         c.spawnRate,
+		SpawnBurst:        c.spawnBurst,
+		ProcessRate:       c.processRate,
+		ProcessBurst:      c.processBurst,
+		AckMode:           c.ackMode,
+		GetMode:           c.getMode,
+		GetPollInterval:   c.getPollInterval,
+		DedupMode:         c.dedupMode,
+		Dedup:             c.dedupKey != nil,
+		Preemption:        c.preemption,
+		PreemptRequeue:    c.preemptRequeue,
+		PersistentWorkers: c.persistentWorkers,
+		NoIdleReaping:     c.noIdleReaping,
+		OrderedCallbacks:  c.orderedCallbacks,
+		ConcurrencyLimit:  c.concurrencyLimit,
+		ChunkSize:         c.chunkSize,
+		FailFast:          c.failFast,
+		NonNilResult:      c.nonNilResult,
+		ResultChanBlock:   c.resultChanBlock,
+		SinkBatchSize:     c.sinkBatchSize,
+		SinkInterval:      c.sinkInterval,
+		MaxPending:        c.maxPending,
+		Name:              c.name,
+		StrictHandler:     c.strictHandler,
+		SingleWorker:      c.singleWorker,
+		Timing:            c.timing,
+	}
+}
+
+// WorkerNumber 返回配置的工作者数量
+// WorkerNumber returns the configured number of workers
+func (c *Config) WorkerNumber() int {
+	return c.num
+}
+
+// ResultEnabled 返回是否开启了结果收集
+// ResultEnabled returns whether result collection is enabled
+func (c *Config) ResultEnabled() bool {
+	return c.result
+}
+
+// isWorkerNumberValid 检查工作者数量是否在有效范围内
+// isWorkerNumberValid checks if the number of workers is within the valid range
+func isWorkerNumberValid(num int) bool {
+	return num >= int(defaultMinWorkerNum) && num <= int(defaultMaxWorkerNum)
+}
+
 // isConfigValid 检查配置是否有效，如果无效则返回一个默认的配置
 // isConfigValid checks if the configuration is valid, if not, it returns a default configuration
 func isConfigValid(conf *Config) *Config {
 	// 如果配置不为 nil
 	// If the configuration is not nil
 	if conf != nil {
-		// 如果工作者数量小于等于0或者大于默认的最大工作者数量
-		// If the number of workers is less than or equal to 0 or greater than the default maximum number of workers
-		if conf.num < int(defaultMinWorkerNum) || conf.num > int(defaultMaxWorkerNum) {
-			// 设置工作者数量为默认的最小工作者数量
-			// Set the number of workers to the default minimum number of workers
+		// 如果开启了单工作者模式
+		// If the single worker mode is enabled
+		if conf.singleWorker {
+			// 设置工作者数量为1
+			// Set the number of workers to 1
+			conf.num = 1
+		} else if !isWorkerNumberValid(conf.num) {
+			// 如果工作者数量小于默认的最小工作者数量或者大于默认的最大工作者数量，设置为默认的最小工作者数量
+			// If the number of workers is less than the default minimum or greater than the default maximum number of workers,
+			// set it to the default minimum number of workers
 			conf.num = int(defaultMinWorkerNum)
 		}
 
@@ -121,17 +894,97 @@ func isConfigValid(conf *Config) *Config {
 			conf.callback = NewEmptyCallback()
 		}
 
-		// 如果消息处理函数为 nil
-		// If the message handling function is nil
-		if conf.handleFunc == nil {
+		// 如果日志记录器为 nil
+		// If the logger is nil
+		if conf.logger == nil {
+			// 设置日志记录器为一个空的日志记录器
+			// Set the logger to an empty logger
+			conf.logger = NewEmptyLogger()
+		}
+
+		// 如果时间来源为 nil
+		// If the time source is nil
+		if conf.clock == nil {
+			// 设置时间来源为基于 time 包的时间来源
+			// Set the time source to the one backed by the time package
+			conf.clock = NewRealClock()
+		}
+
+		// 如果消息处理函数为 nil，并且没有开启严格模式
+		// If the message handling function is nil and the strict mode is not enabled
+		if conf.handleFunc == nil && !conf.strictHandler {
 			// 设置消息处理函数为默认的消息处理函数
 			// Set the message handling function to the default message handling function
 			conf.handleFunc = DefaultMsgHandleFunc
 		}
+
+		// 如果工作协程创建速率小于等于0
+		// If the worker spawn rate is less than or equal to 0
+		if conf.spawnRate <= 0 {
+			// 设置为默认的工作协程创建速率
+			// Set it to the default worker spawn rate
+			conf.spawnRate = float64(defaultWorkerSpawnRate)
+		}
+
+		// 如果工作协程创建突发上限小于等于0
+		// If the worker spawn burst limit is less than or equal to 0
+		if conf.spawnBurst <= 0 {
+			// 设置为默认的工作协程创建突发上限
+			// Set it to the default worker spawn burst limit
+			conf.spawnBurst = defaultWorkerBurstLimit
+		}
+
+		// 如果最大尝试次数小于1
+		// If the maximum number of attempts is less than 1
+		if conf.retryAttempts < 1 {
+			// 设置为只执行一次，即不重试
+			// Set it to a single attempt, which means no retry
+			conf.retryAttempts = 1
+		}
+
+		// 如果重试退避时间小于0
+		// If the retry backoff is less than 0
+		if conf.retryBackoff < 0 {
+			// 设置为立即重试
+			// Set it to retry immediately
+			conf.retryBackoff = 0
+		}
+
+		// 如果去重模式无效
+		// If the dedup mode is invalid
+		if conf.dedupMode != DedupDrop && conf.dedupMode != DedupCoalesce {
+			// 设置为默认的去重模式
+			// Set it to the default dedup mode
+			conf.dedupMode = DedupDrop
+		}
+
+		// 如果确认模式无效
+		// If the ack mode is invalid
+		if conf.ackMode != AckBefore && conf.ackMode != AckAfter {
+			// 设置为默认的确认模式
+			// Set it to the default ack mode
+			conf.ackMode = AckBefore
+		}
+
+		// 如果获取模式无效
+		// If the get mode is invalid
+		if conf.getMode != GetBlocking && conf.getMode != GetPolling {
+			// 设置为默认的获取模式
+			// Set it to the default get mode
+			conf.getMode = GetBlocking
+		}
+
+		// 如果轮询间隔小于等于0
+		// If the poll interval is less than or equal to 0
+		if conf.getPollInterval <= 0 {
+			// 设置为默认的轮询间隔
+			// Set it to the default poll interval
+			conf.getPollInterval = defaultGetPollInterval
+		}
 	} else {
-		// 如果配置为 nil，创建一个默认的配置
-		// If the configuration is nil, create a default configuration
-		conf = DefaultConfig()
+		// 如果配置为 nil，创建一个默认的配置，并同样进行修正，以设置默认的消息处理函数
+		// If the configuration is nil, create a default configuration and correct it as well to set the default message handling function
+		conf = isConfigValid(DefaultConfig())
 	}
 
 	// 返回配置
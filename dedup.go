@@ -0,0 +1,63 @@
+package karta
+
+import (
+	"errors"
+
+	"github.com/shengyanli1982/karta/internal"
+)
+
+// ErrDuplicate 表示已有相同键的任务在队列中或正在处理
+// ErrDuplicate indicates that a task with the same key is already queued or being handled
+var ErrDuplicate = errors.New("duplicate task is already queued or being handled")
+
+// reserve records the key of the message as queued or being handled and reports whether it was free.
+// In the DedupCoalesce mode, the result function of a duplicate is attached to the existing task instead
+// reserve 将消息的键记录为排队中或处理中，并返回该键之前是否空闲。在 DedupCoalesce 模式下，重复元素的结果函数会被附加到已有的任务上
+func (pipeline *Pipeline) reserve(element *internal.ElementExt) bool {
+	key := pipeline.config.dedupKey(element.GetData())
+
+	pipeline.dedupLock.Lock()
+	defer pipeline.dedupLock.Unlock()
+
+	if waiters, ok := pipeline.dedupKeys[key]; ok {
+		if resultFunc := element.GetResultFunc(); resultFunc != nil && pipeline.config.dedupMode == DedupCoalesce {
+			pipeline.dedupKeys[key] = append(waiters, resultFunc)
+		}
+		return false
+	}
+	pipeline.dedupKeys[key] = nil
+	return true
+}
+
+// release frees the key of the message once its task has completed or failed to be enqueued, and delivers the
+// outcome of the task to the submitters coalesced into it
+// release 在消息的任务完成或入队失败后释放其键，并将任务的结果传递给合并到该任务中的提交者
+func (pipeline *Pipeline) release(message, result any, err error) {
+	if pipeline.dedupKeys == nil {
+		return
+	}
+
+	key := pipeline.config.dedupKey(message)
+
+	pipeline.dedupLock.Lock()
+	waiters := pipeline.dedupKeys[key]
+	delete(pipeline.dedupKeys, key)
+	pipeline.dedupLock.Unlock()
+
+	for _, resultFunc := range waiters {
+		resultFunc(result, err)
+	}
+}
+
+// duplicate handles a rejected duplicate element according to the dedup mode: DedupDrop returns ErrDuplicate,
+// DedupCoalesce merges it into the existing task, whose outcome a waiting submitter receives, and returns nil
+// duplicate 按去重模式处理被拒绝的重复元素：DedupDrop 返回 ErrDuplicate，DedupCoalesce 将其合并到已有的任务中，
+// 等待中的提交者会收到该任务的结果，并返回 nil
+func (pipeline *Pipeline) duplicate(element *internal.ElementExt) error {
+	defer pipeline.elementPool.Put(element)
+
+	if pipeline.config.dedupMode == DedupDrop {
+		return ErrDuplicate
+	}
+	return nil
+}
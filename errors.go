@@ -0,0 +1,67 @@
+package karta
+
+import (
+	"errors"
+	"strings"
+)
+
+// joinedError 是由多个错误组合而成的错误，与 Go 1.20 的 errors.Join 行为一致，
+// 同时实现了 Is 和 As，使 errors.Is 和 errors.As 在更早的 Go 版本中也能匹配其中任意一个错误
+// joinedError is an error combining several errors, it behaves like errors.Join of Go 1.20 and also implements
+// Is and As, so that errors.Is and errors.As match any of the errors on earlier Go versions as well
+type joinedError struct {
+	errs []error
+}
+
+// joinErrors 组合所有非 nil 的错误，如果全部为 nil 则返回 nil
+// joinErrors combines all non-nil errors, it returns nil if all of them are nil
+func joinErrors(errs ...error) error {
+	var nonNil []error
+	for _, err := range errs {
+		if err != nil {
+			nonNil = append(nonNil, err)
+		}
+	}
+	if len(nonNil) == 0 {
+		return nil
+	}
+	return &joinedError{errs: nonNil}
+}
+
+// Error 返回每个错误的信息，以换行分隔
+// Error returns the message of every error, separated by newlines
+func (e *joinedError) Error() string {
+	messages := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Unwrap 返回被组合的错误
+// Unwrap returns the combined errors
+func (e *joinedError) Unwrap() []error {
+	return e.errs
+}
+
+// Is 判断被组合的错误中是否有与 target 匹配的错误
+// Is reports whether any of the combined errors matches target
+func (e *joinedError) Is(target error) bool {
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As 查找被组合的错误中第一个可以赋值给 target 的错误
+// As finds the first of the combined errors that can be assigned to target
+func (e *joinedError) As(target any) bool {
+	for _, err := range e.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package karta
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// expvarLock protects expvarPipelines
+	// expvarLock 保护 expvarPipelines
+	expvarLock sync.Mutex
+
+	// expvarPipelines maps published names to the pipeline currently reported under them
+	// expvarPipelines 记录已发布的名称及其当前对应的管道
+	expvarPipelines = make(map[string]*atomic.Pointer[Pipeline])
+)
+
+// PublishExpvar publishes the live counters of the pipeline (see Stats) under name in expvar, so they appear at /debug/vars.
+// Publishing again under the same name rebinds it to this pipeline instead of panicking. Names already taken by
+// variables not published by this package are left untouched.
+// PublishExpvar 将管道的实时计数（参见 Stats）以 name 发布到 expvar，使其出现在 /debug/vars 中。
+// 以相同名称再次发布会将其重新绑定到该管道，而不会 panic。已被非本包发布的变量占用的名称保持不变。
+func (pipeline *Pipeline) PublishExpvar(name string) {
+	expvarLock.Lock()
+	defer expvarLock.Unlock()
+
+	// Rebind a name published before
+	// 重新绑定之前发布过的名称
+	if current, ok := expvarPipelines[name]; ok {
+		current.Store(pipeline)
+		return
+	}
+
+	// Leave names owned by others untouched
+	// 不修改被其他变量占用的名称
+	if expvar.Get(name) != nil {
+		return
+	}
+
+	current := &atomic.Pointer[Pipeline]{}
+	current.Store(pipeline)
+	expvarPipelines[name] = current
+
+	expvar.Publish(name, expvar.Func(func() any {
+		return current.Load().Stats()
+	}))
+}
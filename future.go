@@ -0,0 +1,61 @@
+package karta
+
+import (
+	"context"
+	"sync"
+)
+
+// Future 表示一个已提交任务的最终结果
+// Future represents the eventual result of a submitted task
+type Future struct {
+	ctx    context.Context // submission context / 提交时的上下文
+	done   chan struct{}   // closed when the task completes / 任务完成时关闭
+	once   sync.Once       // ensures the future is resolved only once / 确保只完成一次
+	result any             // result of the task / 任务结果
+	err    error           // error of the task / 任务错误
+}
+
+// newFuture creates a new future bound to the submission context
+// newFuture 创建一个绑定提交上下文的 Future
+func newFuture(ctx context.Context) *Future {
+	return &Future{
+		ctx:  ctx,
+		done: make(chan struct{}),
+	}
+}
+
+// resolve stores the task outcome and wakes up all waiters
+// resolve 保存任务结果并唤醒所有等待者
+func (f *Future) resolve(result any, err error) {
+	f.once.Do(func() {
+		f.result, f.err = result, err
+		close(f.done)
+	})
+}
+
+// Done returns a channel that is closed when the task completes
+// Done 返回一个在任务完成时关闭的通道
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Get blocks until the task completes, the submission context is done or ctx is done
+// Get 阻塞直到任务完成、提交时的上下文结束或 ctx 结束
+func (f *Future) Get(ctx context.Context) (any, error) {
+	// Prefer the task outcome if it is already available
+	// 如果任务结果已经可用，则优先返回
+	select {
+	case <-f.done:
+		return f.result, f.err
+	default:
+	}
+
+	select {
+	case <-f.done:
+		return f.result, f.err
+	case <-f.ctx.Done():
+		return nil, f.ctx.Err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
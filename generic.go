@@ -0,0 +1,59 @@
+package karta
+
+import "github.com/shengyanli1982/karta/internal"
+
+// MapMap processes the values of in concurrently with fn, using a temporary Group built from config, and returns the
+// results and the errors keyed identically to in, whatever the completion order. A key is in the result map if its value
+// was handled without error and in the error map otherwise, keys whose values were not handled are in neither. The
+// callbacks, timeout, middleware and worker number of config apply, its handler is not used.
+// MapMap 使用由 config 创建的临时工作组，以 fn 并发处理 in 中的值，并返回与 in 键相同的结果和错误，与完成顺序无关。
+// 值处理成功的键出现在结果中，否则出现在错误中，未被处理的值的键两者都不出现。config 中的回调函数、超时、中间件和工作者数量都会生效，但不使用其处理函数。
+func MapMap[K comparable, V, R any](config *Config, fn func(V) (R, error), in map[K]V) (map[K]R, map[K]error) {
+	results := make(map[K]R, len(in))
+	errs := make(map[K]error)
+	if len(in) == 0 {
+		return results, errs
+	}
+
+	// Flatten the map so the elements can be associated with their keys by index
+	// 将映射展开，使元素可以通过索引与其键关联
+	keys := make([]K, 0, len(in))
+	values := make([]any, 0, len(in))
+	for key, value := range in {
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+
+	group := NewGroup(config)
+	defer group.Stop()
+
+	// Adapt fn to a handler so it runs with the same callbacks, timeout and panic recovery
+	// 将 fn 适配为处理函数，使其同样应用回调函数、超时和 panic 恢复
+	handler := func(msg any) (any, error) {
+		value, _ := msg.(V)
+		return fn(value)
+	}
+
+	outputs := make([]any, len(values))
+	failures := make([]error, len(values))
+	handled := make([]bool, len(values))
+	group.lock.Lock()
+	group.process(group.ctx, values, func(element *internal.Element) {
+		index := element.GetValue()
+		outputs[index], failures[index] = group.invoke(group.ctx, handler, element.GetData())
+		handled[index] = true
+	})
+	group.lock.Unlock()
+
+	for i, key := range keys {
+		switch {
+		case !handled[i]:
+		case failures[i] != nil:
+			errs[key] = failures[i]
+		default:
+			results[key], _ = outputs[i].(R)
+		}
+	}
+
+	return results, errs
+}
@@ -4,10 +4,19 @@ import (
 	"context"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/shengyanli1982/karta/internal"
 )
 
+// IndexedResult is the outcome of a single element streamed by MapStream
+// IndexedResult 是 MapStream 流式输出的单个元素的处理结果
+type IndexedResult struct {
+	Index int   // position of the element in the input / 元素在输入中的位置
+	Value any   // result returned by the handler / 处理函数返回的结果
+	Err   error // error returned by the handler / 处理函数返回的错误
+}
+
 // elementPool is a global pool for reusing Element objects
 // elementPool 是一个全局的 Element 对象复用池
 var elementPool = internal.NewElementPool()
@@ -15,27 +24,80 @@ var elementPool = internal.NewElementPool()
 // Group represents a worker group that processes tasks concurrently
 // Group 表示一个并发处理任务的工作组
 type Group struct {
-	elements []*internal.Element // slice to store task elements / 存储任务元素的切片
-	lock     sync.Mutex          // mutex for protecting shared resources and ensuring exclusive execution / 用于保护共享资源和确保互斥执行的互斥锁
-	config   *Config             // configuration for the group / 工作组的配置信息
-	wg       sync.WaitGroup      // wait group for synchronizing goroutines / 用于同步 goroutine 的等待组
-	once     sync.Once           // ensures Stop is called only once / 确保 Stop 只被调用一次
-	ctx      context.Context     // context for cancellation / 用于取消操作的上下文
-	cancel   context.CancelFunc  // function to cancel the context / 取消上下文的函数
+	elements  []*internal.Element // slice to store task elements / 存储任务元素的切片
+	lock      sync.Mutex          // mutex for protecting shared resources and ensuring exclusive execution / 用于保护共享资源和确保互斥执行的互斥锁
+	config    *Config             // configuration for the group / 工作组的配置信息
+	wg        sync.WaitGroup      // wait group for synchronizing goroutines / 用于同步 goroutine 的等待组
+	once      sync.Once           // ensures Stop is called only once / 确保 Stop 只被调用一次
+	ctx       context.Context     // context for cancellation / 用于取消操作的上下文
+	cancel    context.CancelFunc  // function to cancel the context / 取消上下文的函数
+	processed atomic.Int64        // total number of handled elements / 已处理的元素总数
+	failed    atomic.Int64        // total number of elements handled with an error / 处理出错的元素总数
+	lastBatch atomic.Int64        // duration of the last batch in nanoseconds / 最近一个批次的耗时（纳秒）
+	pool      *SharedPool         // workers the group hands its work to, nil if none / 工作组交付任务的工作协程池，没有时为 nil
+	shared    bool                // whether the pool is shared with other groups / 工作协程池是否与其他工作组共享
+	slots     chan struct{}       // semaphore limiting concurrent handler calls, nil if unlimited / 限制处理函数并发调用的信号量，不限制时为 nil
+	timing    *durationRecorder   // handler duration recorder, nil if not enabled / 处理函数耗时记录器，未启用时为 nil
 }
 
 // NewGroup creates a new Group with the given configuration
 // NewGroup 使用给定的配置创建一个新的工作组
 func NewGroup(config *Config) *Group {
+	return newGroup(config, nil)
+}
+
+// NewGroupStrict creates a new Group like NewGroup, but returns the error of Config.Validate instead of silently correcting
+// an invalid configuration
+// NewGroupStrict 与 NewGroup 一样创建一个新的工作组，但会返回 Config.Validate 的错误，而不是静默修正无效的配置
+func NewGroupStrict(config *Config) (*Group, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return newGroup(config, nil), nil
+}
+
+// NewGroupWithPool creates a new Group that hands its work to the shared pool instead of starting its own goroutines.
+// Each call still uses at most the configured worker number of pool workers. The pool is not stopped with the group,
+// and a handler must not call a group using the same pool, which may deadlock once every pool worker is busy.
+// NewGroupWithPool 创建一个新的工作组，将任务交给共享的工作协程池处理，而不是启动自己的协程。每次调用仍最多使用配置的工作者数量个工作协程。
+// 工作组停止时不会停止该池，并且处理函数不能调用使用同一个池的工作组，否则在所有工作协程都忙碌时可能会死锁。
+func NewGroupWithPool(config *Config, pool *SharedPool) *Group {
+	return newGroup(config, pool)
+}
+
+// newGroup creates a new Group with the given configuration, using the shared pool if not nil
+// newGroup 使用给定的配置创建一个新的工作组，共享池不为 nil 时使用该池
+func newGroup(config *Config, pool *SharedPool) *Group {
 	config = isConfigValid(config)
 	group := &Group{
 		elements: make([]*internal.Element, 0),
 		config:   config,
+		pool:     pool,
+		shared:   pool != nil,
 	}
-	group.ctx, group.cancel = context.WithCancel(context.Background())
+	if config.timing {
+		group.timing = newDurationRecorder()
+	}
+	if config.concurrencyLimit > 0 {
+		group.slots = make(chan struct{}, config.concurrencyLimit)
+	}
+	group.start()
+
 	return group
 }
 
+// start creates a fresh context and starts the persistent workers if enabled
+// start 创建新的上下文，并在启用时启动常驻工作协程
+func (group *Group) start() {
+	group.ctx, group.cancel = context.WithCancel(context.Background())
+
+	// Start long-lived workers reused by every call in persistent mode, unless a shared pool is used
+	// 持久模式下启动被每次调用复用的常驻工作协程，使用共享池时除外
+	if !group.shared && group.config.persistentWorkers && group.config.num > 1 {
+		group.pool = NewSharedPool(group.config.num)
+	}
+}
+
 // cleanup cleans up remaining elements and returns them to the pool
 // cleanup 清理剩余的元素并将它们返回到对象池
 func (group *Group) cleanup() {
@@ -55,130 +117,880 @@ func (group *Group) Stop() {
 	group.once.Do(func() {
 		group.cancel()
 		group.wg.Wait()
+
+		// Stop the persistent workers, a shared pool is stopped by its owner
+		// 停止常驻工作协程，共享池由其所有者停止
+		if group.pool != nil && !group.shared {
+			group.pool.Stop()
+		}
 	})
 }
 
-// prepare initializes the elements slice with data from the input
-// prepare 使用输入数据初始化元素切片
-func (group *Group) prepare(elements []any) {
+// Reset makes a stopped group usable again with a fresh context, keeping its configuration and metrics.
+// It must only be called after Stop has returned, resetting a group with in-flight work is undefined.
+// Reset 使用新的上下文让已停止的工作组可以再次使用，保留其配置和指标。
+// 只能在 Stop 返回之后调用，重置仍有任务在处理的工作组的行为是未定义的。
+func (group *Group) Reset() {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	group.once = sync.Once{}
+	group.start()
+}
+
+// Name returns the name set by WithName, or an empty string if none was set
+// Name 返回通过 WithName 设置的名称，未设置时返回空字符串
+func (group *Group) Name() string {
+	return group.config.name
+}
+
+// measure records the duration of a batch started at start
+// measure 记录从 start 开始的批次耗时
+func (group *Group) measure(start time.Time) {
+	group.lastBatch.Store(int64(time.Since(start)))
+}
+
+// Metrics returns the cumulative counters of the group. The counters are not reset on read, call ResetMetrics to clear them.
+// Metrics 返回工作组的累计计数。读取时不会重置计数，需要调用 ResetMetrics 清零。
+func (group *Group) Metrics() GroupMetrics {
+	return GroupMetrics{
+		Processed:         group.processed.Load(),
+		Failed:            group.failed.Load(),
+		LastBatchDuration: time.Duration(group.lastBatch.Load()),
+	}
+}
+
+// ResetMetrics clears all counters returned by Metrics
+// ResetMetrics 清零 Metrics 返回的所有计数
+func (group *Group) ResetMetrics() {
+	group.processed.Store(0)
+	group.failed.Store(0)
+	group.lastBatch.Store(0)
+}
+
+// Durations returns the distribution of handler durations recorded since the group was created,
+// or a zero value if WithTiming is not enabled
+// Durations 返回自工作组创建以来记录的处理函数耗时分布，未开启 WithTiming 时返回零值
+func (group *Group) Durations() DurationStats {
+	if group.timing == nil {
+		return DurationStats{}
+	}
+	return group.timing.snapshot()
+}
+
+// prepare initializes the elements slice with data from the input, the value of each element is its index in the input
+// shifted by offset. The slice left by the previous call is reused when it is large enough
+// prepare 使用输入数据初始化元素切片，每个元素的值为其在输入中的索引加上 offset。上一次调用留下的切片足够大时会被复用
+func (group *Group) prepare(elements []any, offset int) {
 	count := len(elements)
-	group.elements = make([]*internal.Element, count)
+	if cap(group.elements) >= count {
+		group.elements = group.elements[:count]
+	} else {
+		group.elements = make([]*internal.Element, count)
+	}
 
 	for i := 0; i < count; i++ {
 		element := elementPool.Get()
 		element.SetData(elements[i])
-		element.SetValue(int64(i))
+		element.SetValue(int64(offset + i))
 		group.elements[i] = element
 	}
 }
 
-// execute processes all tasks concurrently and returns the results
-// execute 并发处理所有任务并返回结果
-func (group *Group) execute() []any {
+// invoke runs fn, or the configured handler if fn is nil, on a single message surrounded by the callbacks,
+// ctx is passed to context-aware handlers
+// invoke 在回调函数的包裹下对单条消息执行 fn，如果 fn 为 nil 则执行配置的处理函数，ctx 会传递给可感知上下文的处理函数
+func (group *Group) invoke(ctx context.Context, fn MessageHandleFunc, data any) (any, error) {
+	group.config.callback.OnBefore(data)
+	result, err := group.call(ctx, fn, data)
+	if err != nil {
+		group.failed.Add(1)
+	}
+	group.processed.Add(1)
+	notifyAfter(group.config, data, result, err)
+	return result, err
+}
+
+// call runs the handler on a single message, waiting for a free slot first if the concurrency is limited.
+// It returns the ctx error if ctx is done while waiting
+// call 对单条消息执行处理函数，如果限制了并发数则先等待空闲的名额。等待期间 ctx 结束则返回 ctx 的错误
+func (group *Group) call(ctx context.Context, fn MessageHandleFunc, data any) (any, error) {
+	if group.slots != nil {
+		select {
+		case group.slots <- struct{}{}:
+			defer func() { <-group.slots }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if group.timing != nil {
+		defer group.timing.record(time.Now())
+	}
+	return callHandler(group.config, ctx, fn, data)
+}
+
+// execute processes all prepared elements concurrently, calling process for each of them,
+// until all are processed, ctx is done or the group is stopped
+// execute 并发处理所有已准备的元素，并对每个元素调用 process，直到全部处理完成、ctx 结束或工作组停止
+func (group *Group) execute(ctx context.Context, process func(element *internal.Element)) {
 	// Get total number of tasks to process
 	// 获取需要处理的总任务数
 	totalTasks := len(group.elements)
 
-	// Initialize result slice if result collection is enabled
-	// 如果需要收集结果，则初始化结果切片
-	var taskResults []any
-	if group.config.result {
-		taskResults = make([]any, totalTasks)
-	}
-
 	// Counter for tracking completed tasks, used atomically
 	// 用于原子计数已完成的任务数
 	var completedTaskCount int64 = 0
 
-	// Start worker goroutines based on configured worker count
-	// 根据配置的工作者数量启动工作协程
-	group.wg.Add(group.config.num)
-	for workerID := 0; workerID < group.config.num; workerID++ {
-		go func() {
-			defer group.wg.Done()
+	// worker takes elements in index order until all are processed or ctx is done
+	// worker 按索引顺序获取元素，直到全部处理完成或 ctx 结束
+	worker := func() {
+		for {
+			// Get the current task index and increment the counter atomically
+			// 获取当前任务索引并原子递增计数器
+			taskIndex := atomic.AddInt64(&completedTaskCount, 1) - 1
+			if taskIndex >= int64(totalTasks) {
+				return
+			}
 
-			for {
-				// Get the current task index and increment the counter atomically
-				// 获取当前任务索引并原子递增计数器
-				taskIndex := atomic.AddInt64(&completedTaskCount, 1) - 1
-				if taskIndex >= int64(totalTasks) {
-					return
-				}
+			select {
+			// Check if the call context is done and return if true
+			// 如果调用上下文已完成则返回
+			case <-ctx.Done():
+				return
 
-				select {
-				// Check if the context is done and return if true
-				// 如果上下文已完成则返回
-				case <-group.ctx.Done():
-					return
-
-				default:
-					// Get the current task element and immediately check if it is nil
-					// 获取当前任务元素并立即检查是否为 nil
-					current := group.elements[taskIndex]
-					if current == nil {
-						continue
-					}
-
-					// Set the element to nil immediately to prevent double recycling
-					// 立即将引用置为 nil，防止重复回收
-					group.elements[taskIndex] = nil
-
-					// Execute the task processing flow
-					// 执行任务处理流程
-					data := current.GetData()
-					group.config.callback.OnBefore(data)
-					processedResult, err := group.config.handleFunc(data)
-					group.config.callback.OnAfter(data, processedResult, err)
-
-					if group.config.result {
-						taskResults[current.GetValue()] = processedResult
-					}
-
-					// Mark the element as done and recycle it
-					// 标记元素为已完成并回收
-					elementPool.Put(current)
+			// Check if the group is stopped and return if true
+			// 如果工作组已停止则返回
+			case <-group.ctx.Done():
+				return
+
+			default:
+				// Get the current task element and immediately check if it is nil
+				// 获取当前任务元素并立即检查是否为 nil
+				current := group.elements[taskIndex]
+				if current == nil {
+					continue
 				}
+
+				// Set the element to nil immediately to prevent double recycling
+				// 立即将引用置为 nil，防止重复回收
+				group.elements[taskIndex] = nil
+
+				// Execute the task processing flow
+				// 执行任务处理流程
+				process(current)
+
+				// Mark the element as done and recycle it
+				// 标记元素为已完成并回收
+				elementPool.Put(current)
 			}
+		}
+	}
+
+	// Never start more workers than there are tasks, the extra ones would exit immediately
+	// 启动的工作者不超过任务数，多余的工作者会立即退出
+	workers := group.config.num
+	if totalTasks < workers {
+		workers = totalTasks
+	}
+
+	group.run(worker, workers)
+}
+
+// run runs worker on n workers concurrently and waits for all of them to return.
+// With a single worker, it runs sequentially on the calling goroutine, which keeps callback ordering deterministic
+// and stack traces simple. In persistent mode or with a shared pool, the pool workers are reused instead of starting new goroutines.
+// run 在 n 个工作者上并发运行 worker，并等待全部返回。只有一个工作者时在调用协程上依次运行，保证回调顺序确定且调用栈简单。
+// 持久模式下或使用共享池时复用池中的工作协程，而不是启动新的协程。
+func (group *Group) run(worker func(), n int) {
+	if n == 1 {
+		worker()
+		return
+	}
+
+	// Hand the worker to the persistent or shared pool workers, skipping the rest once the group is stopped
+	// 将 worker 交给常驻或共享池的工作协程，工作组停止后跳过其余部分
+	if group.pool != nil {
+		group.wg.Add(1)
+		defer group.wg.Done()
+		group.pool.dispatch(group.ctx, n, worker)
+		return
+	}
+
+	// Start worker goroutines for the given worker count
+	// 根据给定的工作者数量启动工作协程
+	group.wg.Add(n)
+	for workerID := 0; workerID < n; workerID++ {
+		go func() {
+			defer group.wg.Done()
+			worker()
 		}()
 	}
 
 	// Wait for all workers to complete
 	// 等待所有工作协程完成
 	group.wg.Wait()
+}
+
+// ready reports whether the group is able to process the given elements
+// ready 判断工作组是否可以处理给定的元素
+func (group *Group) ready(elements []any) bool {
+	// Nothing to do if input is empty
+	// 如果输入为空则无需处理
+	return group.running() && len(elements) > 0
+}
+
+// running reports whether neither the group nor the pool it hands its work to has been stopped
+// running 判断工作组及其交付任务的工作协程池是否都没有停止
+func (group *Group) running() bool {
+	// Check if the group has been stopped
+	// 检查工作组是否已经停止
+	select {
+	case <-group.ctx.Done():
+		return false
+	default:
+	}
+
+	// Check if the pool the group hands its work to has been stopped
+	// 检查工作组交付任务的工作协程池是否已经停止
+	return group.pool == nil || !group.pool.stopped()
+}
+
+// emptyResult returns the results of a call that processed nothing: an empty slice if WithNonNilResult is set,
+// elements is empty and the group is running, nil otherwise
+// emptyResult 返回没有处理任何元素的调用的结果：设置了 WithNonNilResult、elements 为空且工作组正在运行时返回空切片，否则返回 nil
+func (group *Group) emptyResult(elements []any) []any {
+	if group.config.nonNilResult && len(elements) == 0 && group.running() {
+		return []any{}
+	}
+	return nil
+}
+
+// process initializes the elements, processes them concurrently until ctx is done and cleans up afterwards.
+// With a chunk size, it does so window by window, skipping the remaining windows once ctx is done or the group is stopped
+// process 初始化元素，并发处理直到 ctx 结束，然后进行清理。设置了窗口大小时逐个窗口进行，ctx 结束或工作组停止后跳过剩余的窗口
+func (group *Group) process(ctx context.Context, elements []any, fn func(element *internal.Element)) {
+	defer group.measure(time.Now())
+
+	size := group.config.chunkSize
+	if size <= 0 || size > len(elements) {
+		size = len(elements)
+	}
+
+	for start := 0; start < len(elements); start += size {
+		if ctx.Err() != nil || group.ctx.Err() != nil {
+			return
+		}
+
+		end := start + size
+		if end > len(elements) {
+			end = len(elements)
+		}
+
+		group.prepare(elements[start:end], start)
+		group.execute(ctx, fn)
 
-	return taskResults
+		// Clean up elements after processing is complete
+		// 处理完成后清理元素
+		group.cleanup()
+	}
 }
 
-// Map processes the input elements concurrently using the configured handler function
-// Map 使用配置的处理函数并发处理输入元素
+// Map processes the input elements concurrently using the configured handler function.
+// Results are aligned with the input: the result of elements[i] is stored at index i, whatever the completion order.
+// Map 使用配置的处理函数并发处理输入元素。结果与输入对齐：无论完成顺序如何，elements[i] 的结果都保存在索引 i 处。
 func (group *Group) Map(elements []any) []any {
+	return group.MapContext(group.ctx, elements)
+}
+
+// Start processes the input elements like Map, it is kept for callers of earlier versions.
+// Start 与 Map 一样处理输入元素，保留它是为了兼容早期版本的调用者。
+//
+// Deprecated: use Map instead. 已弃用：请使用 Map。
+func (group *Group) Start(elements []any) []any {
+	return group.Map(elements)
+}
+
+// MapContext processes the input elements like Map, aborting the remaining tasks once ctx is done.
+// It returns the results completed so far, with nil in the slots of cancelled tasks.
+// MapContext 与 Map 一样处理输入元素，ctx 结束后放弃剩余的任务。返回目前已完成的结果，被取消任务的位置为 nil。
+func (group *Group) MapContext(ctx context.Context, elements []any) []any {
 	// Ensure exclusive execution and protect shared resources
 	// 确保互斥执行并保护共享资源
 	group.lock.Lock()
 	defer group.lock.Unlock()
 
-	// Check if the group has been stopped
-	// 检查工作组是否已经停止
-	select {
-	case <-group.ctx.Done():
+	// Return nil if the group is stopped or input is empty, or an empty slice for an empty input with WithNonNilResult
+	// 如果工作组已停止或输入为空则返回 nil，设置了 WithNonNilResult 时空输入返回空切片
+	if !group.ready(elements) {
+		return group.emptyResult(elements)
+	}
+
+	// Initialize result slice if result collection is enabled
+	// 如果需要收集结果，则初始化结果切片
+	var results []any
+	if group.config.result {
+		results = make([]any, len(elements))
+	}
+
+	return group.mapInto(ctx, results, nil, elements)
+}
+
+// MapContextSkipped processes the input elements like MapContext, and also returns the inputs that never ran because ctx
+// was done or the group was stopped, in input order, so callers can retry them elsewhere. Results follow MapContext: they
+// are only returned if WithResult is set, and the slots of skipped inputs are nil. An input whose handler was started is
+// never skipped, even if it was then cancelled, its outcome is delivered to the callbacks and its result slot as usual.
+// It returns nil results and no skipped inputs if the group is stopped before the call or elements is empty.
+// MapContextSkipped 与 MapContext 一样处理输入元素，同时按输入顺序返回由于 ctx 结束或工作组停止而从未执行的输入，以便调用者在其他地方重试。
+// 结果与 MapContext 相同：只有设置了 WithResult 才会返回，被跳过输入的位置为 nil。处理函数已经开始执行的输入不会被视为跳过，即使之后被取消，
+// 其处理结果也会照常传递给回调函数和对应的结果位置。调用前工作组已停止或 elements 为空时返回 nil 结果且没有被跳过的输入。
+func (group *Group) MapContextSkipped(ctx context.Context, elements []any) (results []any, skipped []any) {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	if !group.ready(elements) {
+		return nil, nil
+	}
+
+	if group.config.result {
+		results = make([]any, len(elements))
+	}
+
+	ran := make([]bool, len(elements))
+	results = group.mapInto(ctx, results, ran, elements)
+
+	for i, ok := range ran {
+		if !ok {
+			skipped = append(skipped, elements[i])
+		}
+	}
+
+	return results, skipped
+}
+
+// MapInto processes the input elements like Map, writing the results into dst resized to len(elements) instead of a new
+// slice, so hot loops can reuse one buffer across calls. dst is reallocated only if its capacity is too small, results
+// are written whether or not WithResult is set, and unfinished slots are nil. The returned slice shares the backing array
+// of dst when it was reused, so results of an earlier call held in it are overwritten, and dst must not overlap elements.
+// It returns nil if the group is stopped or elements is empty.
+// MapInto 与 Map 一样处理输入元素，将结果写入调整为 len(elements) 长度的 dst，而不是新的切片，使热点循环可以在多次调用之间复用同一个缓冲区。
+// 只有在 dst 容量不足时才会重新分配，无论是否设置 WithResult 都会写入结果，未完成的位置为 nil。复用 dst 时返回的切片与 dst 共享底层数组，
+// 因此其中保存的之前调用的结果会被覆盖，并且 dst 不能与 elements 重叠。工作组已停止或 elements 为空时返回 nil。
+func (group *Group) MapInto(dst []any, elements []any) []any {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	if !group.ready(elements) {
 		return nil
-	default:
 	}
 
-	// Return nil if input is empty
-	// 如果输入为空则返回 nil
-	if len(elements) == 0 {
+	// Reuse dst if it is large enough, clearing the slots left over from an earlier call
+	// dst 足够大时复用它，并清除之前调用遗留的结果
+	if cap(dst) >= len(elements) {
+		dst = dst[:len(elements)]
+		for i := range dst {
+			dst[i] = nil
+		}
+	} else {
+		dst = make([]any, len(elements))
+	}
+
+	return group.mapInto(group.ctx, dst, nil, elements)
+}
+
+// mapInto processes the elements until ctx is done, writing the results into results and marking the elements that ran
+// in ran if they are not nil
+// mapInto 处理元素直到 ctx 结束，results 和 ran 不为 nil 时分别写入结果并标记已执行的元素
+func (group *Group) mapInto(ctx context.Context, results []any, ran []bool, elements []any) []any {
+	// Run a single element synchronously on the calling goroutine, avoiding goroutine and pool overhead
+	// 单个元素直接在调用协程上同步处理，避免协程和对象池的开销
+	if len(elements) == 1 {
+		defer group.measure(time.Now())
+		if ctx.Err() == nil {
+			if ran != nil {
+				ran[0] = true
+			}
+			result, _ := group.invoke(ctx, nil, elements[0])
+			if results != nil {
+				results[0] = result
+			}
+		}
+		return results
+	}
+
+	// Cancel the remaining tasks on the first error if fail fast is enabled
+	// 如果启用了快速失败，则在第一个错误时取消剩余的任务
+	ctx, cancel := group.withFailFast(ctx)
+	defer cancel()
+
+	group.process(ctx, elements, func(element *internal.Element) {
+		if ran != nil {
+			ran[element.GetValue()] = true
+		}
+		result, err := group.invoke(ctx, nil, element.GetData())
+		if results != nil {
+			results[element.GetValue()] = result
+		}
+		if err != nil {
+			cancel()
+		}
+	})
+
+	return results
+}
+
+// withFailFast returns a context derived from ctx and the function cancelling it if fail fast is enabled,
+// otherwise it returns ctx unchanged and a function doing nothing
+// withFailFast 如果启用了快速失败，则返回从 ctx 派生的上下文和取消它的函数，否则原样返回 ctx 和一个什么都不做的函数
+func (group *Group) withFailFast(ctx context.Context) (context.Context, context.CancelFunc) {
+	if !group.config.failFast {
+		return ctx, func() {}
+	}
+	return context.WithCancel(ctx)
+}
+
+// MapTimeout processes the input elements like MapContext with a context that times out after d, so the whole call
+// respects a wall-clock budget. Context-aware handlers are cancelled at the deadline, other handlers finish their
+// current element. Unfinished slots are nil.
+// MapTimeout 与 MapContext 一样处理输入元素，使用在 d 之后超时的上下文，使整个调用遵守时间预算。
+// 可感知上下文的处理函数在截止时间被取消，其他处理函数会完成当前的元素。未完成的位置为 nil。
+func (group *Group) MapTimeout(elements []any, d time.Duration) []any {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return group.MapContext(ctx, elements)
+}
+
+// MapN processes the input elements like MapContext, cancelling the remaining tasks once n elements have been handled
+// without error, and returns those n results in completion order. Which results are returned depends on completion
+// timing, not on input order. Fewer than n results are returned if not enough elements succeed, and nil if n is not positive.
+// MapN 与 MapContext 一样处理输入元素，当 n 个元素处理成功后取消剩余的任务，并按完成顺序返回这 n 个结果。
+// 返回哪些结果取决于完成的时机，而不是输入顺序。成功的元素不足 n 个时返回的结果少于 n 个，n 不是正数时返回 nil。
+func (group *Group) MapN(elements []any, n int) []any {
+	if n <= 0 {
+		return nil
+	}
+
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	if !group.ready(elements) {
+		return nil
+	}
+
+	// Cancel the remaining tasks as soon as the n-th success is collected
+	// 收集到第 n 个成功结果后立即取消剩余的任务
+	ctx, cancel := context.WithCancel(group.ctx)
+	defer cancel()
+
+	var lock sync.Mutex
+	results := make([]any, 0, n)
+	group.process(ctx, elements, func(element *internal.Element) {
+		result, err := group.invoke(ctx, nil, element.GetData())
+		if err != nil {
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		if len(results) < n {
+			results = append(results, result)
+			if len(results) == n {
+				cancel()
+			}
+		}
+	})
+
+	return results
+}
+
+// MapKeyed processes the input elements like Map and returns the results keyed by keyFn(element) instead of by position,
+// which suits sparse or pre-numbered inputs. keyFn is called on the calling goroutine. When several elements share a key
+// the last write wins, the last one being the element appearing latest in the input, not the one completing last.
+// Results are returned whether or not WithResult is set, unfinished elements map to nil. It returns nil if the group is
+// stopped or elements is empty.
+// MapKeyed 与 Map 一样处理输入元素，返回以 keyFn(element) 而不是位置为键的结果，适用于稀疏或已编号的输入。keyFn 在调用协程上执行。
+// 多个元素的键相同时以最后写入的为准，即输入中最靠后的元素，而不是最后完成的元素。无论是否设置 WithResult 都会返回结果，
+// 未完成的元素对应 nil。工作组已停止或 elements 为空时返回 nil。
+func (group *Group) MapKeyed(elements []any, keyFn func(msg any) int64) map[int64]any {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	if !group.ready(elements) {
 		return nil
 	}
 
-	// Initialize elements and process them concurrently
-	// 初始化元素并并发处理
-	group.prepare(elements)
-	result := group.execute()
+	results := group.mapInto(group.ctx, make([]any, len(elements)), nil, elements)
+
+	// Key the results in input order, so later elements overwrite earlier ones with the same key
+	// 按输入顺序为结果设置键，使后面的元素覆盖前面键相同的元素
+	keyed := make(map[int64]any, len(elements))
+	for i, element := range elements {
+		keyed[keyFn(element)] = results[i]
+	}
+
+	return keyed
+}
+
+// MapIndexed processes the input elements concurrently with fn instead of the configured handler, passing each element
+// along with its index in the input, so handlers needing the position do not have to embed it in the payload. Results are
+// aligned with the input and returned whether or not WithResult is set, unfinished or failed elements map to nil.
+// It returns nil if the group is stopped or elements is empty.
+// MapIndexed 使用 fn 而不是配置的处理函数并发处理输入元素，并将每个元素及其在输入中的索引一起传递，使需要位置的处理函数无需将其嵌入消息。
+// 结果与输入对齐，无论是否设置 WithResult 都会返回，未完成或失败的元素对应 nil。工作组已停止或 elements 为空时返回 nil。
+func (group *Group) MapIndexed(elements []any, fn func(i int, msg any) (any, error)) []any {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	if !group.ready(elements) {
+		return nil
+	}
+
+	results := make([]any, len(elements))
+	group.process(group.ctx, elements, func(element *internal.Element) {
+		index := int(element.GetValue())
+
+		// Bind the index to fn so it runs with the same callbacks, timeout and panic recovery as a handler
+		// 将索引绑定到 fn，使其与处理函数一样应用回调函数、超时和 panic 恢复
+		handle := func(msg any) (any, error) { return fn(index, msg) }
+		if result, err := group.invoke(group.ctx, handle, element.GetData()); err == nil {
+			results[index] = result
+		}
+	})
+
+	return results
+}
+
+// MapWithRetry processes the input elements like Map, re-running the handler up to maxAttempts times for failed elements.
+// It always returns the final results and the last error of every element, aligned by index.
+// MapWithRetry 与 Map 一样处理输入元素，对失败的元素最多执行 maxAttempts 次处理函数。
+// 无论是否设置 WithResult，都会返回按索引对齐的最终结果和每个元素的最后一次错误。
+func (group *Group) MapWithRetry(elements []any, maxAttempts int) ([]any, []error) {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	if !group.ready(elements) {
+		return nil, nil
+	}
+
+	// At least one attempt is always made
+	// 至少执行一次尝试
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	results := make([]any, len(elements))
+	errs := make([]error, len(elements))
+
+	group.process(group.ctx, elements, func(element *internal.Element) {
+		var (
+			result any
+			err    error
+		)
+
+		// Retry on the same worker until success, attempts exhausted or the group stopped,
+		// other workers keep processing first attempts meanwhile
+		// 在同一工作协程上重试直到成功、尝试次数耗尽或工作组停止，其他工作协程同时继续处理首次尝试
+		attempt := 0
+		for attempt < maxAttempts {
+			attempt++
+			if result, err = group.invoke(group.ctx, nil, element.GetData()); err == nil || group.ctx.Err() != nil {
+				break
+			}
+		}
+
+		// Route the element to the dead-letter function once its last attempt has failed
+		// 最后一次尝试失败后，将元素交给死信函数
+		if err != nil && attempt == maxAttempts && group.config.deadLetter != nil {
+			group.config.deadLetter(element.GetData(), err)
+		}
+
+		results[element.GetValue()] = result
+		errs[element.GetValue()] = err
+	})
+
+	return results, errs
+}
+
+// MapErr processes the input elements like Map and returns the results aligned by index together with a single error
+// joining all non-nil handler errors in input order, or nil if every element succeeded. errors.Is and errors.As match
+// any of the joined errors. With WithFailFast, only the error that cancelled the remaining tasks is returned.
+// Use MapWithRetry when the error of every index is needed.
+// MapErr 与 Map 一样处理输入元素，返回按索引对齐的结果，以及按输入顺序组合所有非 nil 处理错误的单个错误，全部成功时为 nil。
+// errors.Is 和 errors.As 可以匹配其中任意一个错误。设置 WithFailFast 时只返回触发取消剩余任务的错误。需要每个索引的错误时请使用 MapWithRetry。
+func (group *Group) MapErr(elements []any) ([]any, error) {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	if !group.ready(elements) {
+		return group.emptyResult(elements), nil
+	}
+
+	ctx, cancel := group.withFailFast(group.ctx)
+	defer cancel()
+
+	// Remember the error that triggered the cancellation in fail fast mode
+	// 在快速失败模式下记录触发取消的错误
+	var trigger error
+	var once sync.Once
+
+	results := make([]any, len(elements))
+	errs := make([]error, len(elements))
+	group.process(ctx, elements, func(element *internal.Element) {
+		result, err := group.invoke(ctx, nil, element.GetData())
+		results[element.GetValue()], errs[element.GetValue()] = result, err
+		if err != nil && group.config.failFast {
+			once.Do(func() {
+				trigger = err
+				cancel()
+			})
+		}
+	})
+
+	if group.config.failFast {
+		return results, trigger
+	}
+	return results, joinErrors(errs...)
+}
+
+// MapStream processes the input elements concurrently and streams their results in input order: a result is emitted
+// as soon as it and all results before it are available, so downstream work can start before the whole batch finishes.
+// The channel is closed when processing completes, or early if the group is stopped, in which case only the completed
+// prefix is emitted. Results are streamed whether or not WithResult is set.
+// MapStream 并发处理输入元素，并按输入顺序流式输出结果：当某个结果及其之前的所有结果都可用时立即输出，
+// 因此可以在整批任务完成之前开始下游工作。处理完成时关闭通道；如果工作组被停止则提前关闭，此时只输出已完成的前缀部分。
+// 无论是否设置 WithResult，都会输出结果。
+func (group *Group) MapStream(elements []any) <-chan IndexedResult {
+	// Buffer every result so workers never block on a slow consumer
+	// 缓冲所有结果，保证工作协程不会因消费者缓慢而阻塞
+	stream := make(chan IndexedResult, len(elements))
+
+	go func() {
+		defer close(stream)
+
+		group.lock.Lock()
+		defer group.lock.Unlock()
+
+		if !group.ready(elements) {
+			return
+		}
+
+		var (
+			lock     sync.Mutex
+			next     int
+			done     = make([]bool, len(elements))
+			buffered = make([]IndexedResult, len(elements))
+		)
+
+		group.process(group.ctx, elements, func(element *internal.Element) {
+			result, err := group.invoke(group.ctx, nil, element.GetData())
+			index := int(element.GetValue())
+
+			// Reorder: emit every result whose predecessors are all done
+			// 重新排序：输出所有前序结果均已完成的结果
+			lock.Lock()
+			buffered[index] = IndexedResult{Index: index, Value: result, Err: err}
+			done[index] = true
+			for next < len(elements) && done[next] {
+				stream <- buffered[next]
+				buffered[next] = IndexedResult{}
+				next++
+			}
+			lock.Unlock()
+		})
+	}()
+
+	return stream
+}
+
+// Filter runs pred on the input elements concurrently and returns the elements for which it returned true,
+// preserving input order. Elements for which pred returns an error are excluded.
+// Filter 并发地对输入元素执行 pred，按输入顺序返回 pred 结果为 true 的元素。pred 返回错误的元素会被排除。
+func (group *Group) Filter(elements []any, pred func(msg any) (bool, error)) []any {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	if !group.ready(elements) {
+		return nil
+	}
+
+	// Adapt the predicate to a handler so it runs with the same callbacks, timeout and panic recovery
+	// 将谓词适配为处理函数，使其同样应用回调函数、超时和 panic 恢复
+	fn := func(msg any) (any, error) { return pred(msg) }
+
+	keep := make([]bool, len(elements))
+	group.process(group.ctx, elements, func(element *internal.Element) {
+		matched, err := group.invoke(group.ctx, fn, element.GetData())
+		keep[element.GetValue()] = err == nil && matched == true
+	})
+
+	results := make([]any, 0, len(elements))
+	for i, ok := range keep {
+		if ok {
+			results = append(results, elements[i])
+		}
+	}
+
+	return results
+}
+
+// ForEach runs fn on every input element concurrently for its side effects, without allocating a result slice.
+// It returns the first error observed in completion order, which is not necessarily the error of the earliest input.
+// ForEach 并发地对每个输入元素执行 fn 以产生副作用，不会分配结果切片。
+// 它返回按完成顺序观察到的第一个错误，该错误不一定来自最靠前的输入。
+func (group *Group) ForEach(elements []any, fn func(msg any) error) error {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	if !group.ready(elements) {
+		return nil
+	}
+
+	// Adapt fn to a handler so it runs with the same callbacks, timeout and panic recovery
+	// 将 fn 适配为处理函数，使其同样应用回调函数、超时和 panic 恢复
+	handle := func(msg any) (any, error) { return nil, fn(msg) }
+
+	var (
+		once     sync.Once
+		firstErr error
+	)
+
+	group.process(group.ctx, elements, func(element *internal.Element) {
+		if _, err := group.invoke(group.ctx, handle, element.GetData()); err != nil {
+			once.Do(func() { firstErr = err })
+		}
+	})
+
+	return firstErr
+}
+
+// MapReduce maps the input elements concurrently with the configured handler function, then folds the results
+// with reduce starting from initial. The fold runs sequentially in input order on the calling goroutine, so it is
+// deterministic and reduce need not be thread-safe. Results of failed elements are folded as returned by the handler.
+// MapReduce 使用配置的处理函数并发地映射输入元素，然后从 initial 开始使用 reduce 折叠结果。
+// 折叠在调用协程上按输入顺序依次执行，因此结果是确定的，reduce 无需是线程安全的。失败元素的结果按处理函数的返回值参与折叠。
+func (group *Group) MapReduce(elements []any, reduce func(acc, item any) any, initial any) any {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	if !group.ready(elements) {
+		return initial
+	}
+
+	results := make([]any, len(elements))
+	group.process(group.ctx, elements, func(element *internal.Element) {
+		results[element.GetValue()], _ = group.invoke(group.ctx, nil, element.GetData())
+	})
+
+	// Fold sequentially in input order
+	// 按输入顺序依次折叠
+	acc := initial
+	for _, result := range results {
+		acc = reduce(acc, result)
+	}
+
+	return acc
+}
+
+// Reduce runs fn on the input elements concurrently and folds each result into the accumulator as soon as it completes,
+// starting from initial, without allocating a result slice. The fold is serialized behind a lock, so reduce need not be
+// thread-safe, but it runs in completion order, so reduce must be associative and commutative for the outcome to be
+// deterministic. Failed elements are not folded, and the first error observed in completion order is returned along with
+// the accumulator. It returns initial if the group is stopped or elements is empty.
+// Reduce 并发地对输入元素执行 fn，并从 initial 开始在每个结果完成时立即将其折叠到累加器中，不会分配结果切片。折叠在锁的保护下串行执行，
+// 因此 reduce 无需是线程安全的，但它按完成顺序执行，因此 reduce 必须满足结合律和交换律，结果才是确定的。失败的元素不参与折叠，
+// 返回累加器以及按完成顺序观察到的第一个错误。工作组已停止或 elements 为空时返回 initial。
+func (group *Group) Reduce(elements []any, fn func(msg any) (any, error), reduce func(acc, v any) any, initial any) (any, error) {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	if !group.ready(elements) {
+		return initial, nil
+	}
+
+	var (
+		foldLock sync.Mutex
+		acc      = initial
+		firstErr error
+	)
+
+	group.process(group.ctx, elements, func(element *internal.Element) {
+		result, err := group.invoke(group.ctx, fn, element.GetData())
+
+		foldLock.Lock()
+		defer foldLock.Unlock()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		acc = reduce(acc, result)
+	})
+
+	return acc, firstErr
+}
+
+// MapIter processes inputs pulled lazily from next until it returns false, so memory used for inputs stays bounded
+// by the worker count rather than the input size. next is never called concurrently. Results are aligned with the
+// pull order and returned when WithResult is set, otherwise nil is returned.
+// MapIter 从 next 中惰性地拉取输入进行处理，直到 next 返回 false，因此输入占用的内存受工作者数量而非输入规模限制。
+// next 不会被并发调用。结果与拉取顺序对齐，设置 WithResult 时返回结果，否则返回 nil。
+func (group *Group) MapIter(next func() (any, bool)) []any {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	// Return nil if the group is stopped
+	// 如果工作组已停止则返回 nil
+	if group.ctx.Err() != nil {
+		return nil
+	}
+
+	defer group.measure(time.Now())
+
+	var (
+		iterLock   sync.Mutex
+		count      int
+		exhausted  bool
+		resultLock sync.Mutex
+		results    []any
+	)
+
+	// pull takes the next input and its index, serializing calls to next
+	// pull 获取下一个输入及其索引，保证 next 串行调用
+	pull := func() (any, int, bool) {
+		iterLock.Lock()
+		defer iterLock.Unlock()
+		if exhausted || group.ctx.Err() != nil {
+			return nil, 0, false
+		}
+		data, ok := next()
+		if !ok {
+			exhausted = true
+			return nil, 0, false
+		}
+		count++
+		return data, count - 1, true
+	}
+
+	worker := func() {
+		for {
+			data, index, ok := pull()
+			if !ok {
+				return
+			}
+
+			result, _ := group.invoke(group.ctx, nil, data)
+			if group.config.result {
+				resultLock.Lock()
+				for len(results) <= index {
+					results = append(results, nil)
+				}
+				results[index] = result
+				resultLock.Unlock()
+			}
+		}
+	}
 
-	// Clean up elements after processing is complete
-	// 处理完成后清理元素
-	group.cleanup()
+	group.run(worker, group.config.num)
 
-	return result
+	return results
 }
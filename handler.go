@@ -0,0 +1,209 @@
+package karta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+var (
+	// ErrTaskTimeout 表示处理函数没有在配置的任务超时时间内返回
+	// ErrTaskTimeout indicates that the handler did not return within the configured task timeout
+	ErrTaskTimeout = errors.New("task timed out")
+
+	// ErrDeadlineExceeded 表示任务在被工作协程获取时已经超过了提交时指定的截止时间，处理函数不会被执行
+	// ErrDeadlineExceeded indicates that the task deadline given at submission had passed when a worker picked it up, the handler is not run
+	ErrDeadlineExceeded = errors.New("task deadline exceeded")
+
+	// ErrHandlerPanic 表示处理函数发生了 panic，返回的错误会包装该错误和 recover 得到的值
+	// ErrHandlerPanic indicates that the handler panicked, the returned error wraps it along with the recovered value
+	ErrHandlerPanic = errors.New("handler panicked")
+
+	// ErrNoHandler 表示在严格处理函数模式下没有可用的处理函数
+	// ErrNoHandler indicates that no handler is available in the strict handler mode
+	ErrNoHandler = errors.New("no handler function")
+)
+
+// RetryAfterError 是处理函数请求在 Delay 之后重新处理任务时返回的错误，由 ErrRetryAfter 创建
+// RetryAfterError is the error a handler returns to ask for the task to be handled again after Delay, it is created by ErrRetryAfter
+type RetryAfterError struct {
+	Delay time.Duration // delay before the task is handled again / 重新处理任务前的延迟
+}
+
+// Error returns the error message
+// Error 返回错误信息
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("retry after %s", e.Delay)
+}
+
+// ErrRetryAfter returns an error a Pipeline handler can return to re-queue its task after d instead of failing it.
+// The re-queue does not count as an attempt and skips OnAfter, but a task is re-queued at most 64 times, after which
+// the error is handled like any other failure, as it is when the pipeline is stopping. Group treats it as a plain error.
+// ErrRetryAfter 返回一个错误，Pipeline 的处理函数返回该错误可以让任务在 d 之后重新入队，而不是失败。重新入队不计为尝试次数，
+// 也不会调用 OnAfter，但一个任务最多重新入队 64 次，之后该错误按普通失败处理，管道正在停止时也是如此。Group 将其视为普通错误。
+func ErrRetryAfter(d time.Duration) error {
+	return &RetryAfterError{Delay: d}
+}
+
+// retryAfterDelay returns the delay requested by the handler if err is a RetryAfterError
+// retryAfterDelay 如果 err 是 RetryAfterError，则返回处理函数请求的延迟
+func retryAfterDelay(err error) (time.Duration, bool) {
+	var retryAfter *RetryAfterError
+	if errors.As(err, &retryAfter) {
+		return retryAfter.Delay, true
+	}
+	return 0, false
+}
+
+// handlerOutcome 保存处理函数的返回值
+// handlerOutcome holds the return values of a handler
+type handlerOutcome struct {
+	result any
+	err    error
+}
+
+// runHandler runs fn on the message, or the configured default handler if fn is nil, wrapped in the configured middleware.
+// The context-aware default handler takes precedence over the plain one, ErrNoHandler is returned if neither is set. A panic in the handler
+// is recovered, reported to a PanicCallback and converted into an error wrapping ErrHandlerPanic.
+// runHandler 对消息执行 fn，如果 fn 为 nil 则执行配置的默认处理函数，并使用配置的中间件包装。可感知上下文的默认处理函数优先于普通处理函数，
+// 两者都未设置时返回 ErrNoHandler。处理函数中的 panic 会被恢复，通知 PanicCallback，并转换为包装 ErrHandlerPanic 的错误。
+func runHandler(config *Config, ctx context.Context, fn MessageHandleFunc, msg any) (result any, err error) {
+	// Keep a bad task from taking down the worker
+	// 防止单个异常任务导致工作协程崩溃
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			// Notify the callback if it wants to know about panics
+			// 如果回调函数关心 panic，则通知它
+			if callback, ok := config.callback.(PanicCallback); ok {
+				callback.OnPanic(msg, recovered, debug.Stack())
+			}
+			result, err = nil, fmt.Errorf("%w: %v", ErrHandlerPanic, recovered)
+		}
+	}()
+
+	// Adapt the context-aware handler so middleware sees a plain handler
+	// 适配可感知上下文的处理函数，使中间件看到的是普通的处理函数
+	if fn == nil && config.ctxHandleFunc != nil {
+		fn = func(msg any) (any, error) { return config.ctxHandleFunc(ctx, msg) }
+	}
+	if fn == nil {
+		fn = config.handleFunc
+	}
+	// The handler is only missing in the strict handler mode
+	// 只有在严格处理函数模式下才会缺少处理函数
+	if fn == nil {
+		return nil, ErrNoHandler
+	}
+
+	// Wrap the handler in the middleware, the first one being the outermost
+	// 使用中间件包装处理函数，第一个中间件位于最外层
+	for i := len(config.middleware) - 1; i >= 0; i-- {
+		fn = config.middleware[i](fn)
+	}
+	return fn(msg)
+}
+
+// notifyAfter calls OnAfter with the outcome of the message, then OnError if it failed and the callback implements ErrorCallback
+// notifyAfter 使用消息的处理结果调用 OnAfter，如果处理失败且回调函数实现了 ErrorCallback，则接着调用 OnError
+func notifyAfter(config *Config, msg, result any, err error) {
+	config.callback.OnAfter(msg, result, err)
+	if err == nil {
+		return
+	}
+	if callback, ok := config.callback.(ErrorCallback); ok {
+		callback.OnError(msg, err)
+	}
+}
+
+// metaContextKey is the context key of the metadata attached to a task
+// metaContextKey 是附加到任务的元数据的上下文键
+type metaContextKey struct{}
+
+// MetaFromContext returns the metadata attached with SubmitWithMeta to the task whose context is ctx, or nil if none.
+// Context-aware handlers set with WithContextHandleFunc receive that context
+// MetaFromContext 返回通过 SubmitWithMeta 附加到上下文为 ctx 的任务的元数据，没有时返回 nil。
+// 通过 WithContextHandleFunc 设置的可感知上下文的处理函数会收到该上下文
+func MetaFromContext(ctx context.Context) map[string]any {
+	meta, _ := ctx.Value(metaContextKey{}).(map[string]any)
+	return meta
+}
+
+// taskOutcome is the outcome of a Pipeline task delivered to the callback
+// taskOutcome 是传递给回调函数的 Pipeline 任务处理结果
+type taskOutcome struct {
+	msg    any      // message submitted to the pipeline / 提交到管道的消息
+	id     string   // ID given at submission, empty if none / 提交时给定的 ID，没有时为空
+	result any      // result returned by the handler / 处理函数返回的结果
+	err    error    // error returned by the handler / 处理函数返回的错误
+	meta   TaskMeta // details of how the task was handled / 任务处理方式的详细信息
+	skip   bool     // whether the sequence was never enqueued / 该序号是否从未入队
+}
+
+// notifyOutcome calls OnAfter, then OnError, OnAfterID and OnAfterMeta if the callback implements them
+// notifyOutcome 调用 OnAfter，如果回调函数实现了 OnError、OnAfterID 和 OnAfterMeta，则接着调用它们
+func notifyOutcome(config *Config, outcome *taskOutcome) {
+	notifyAfter(config, outcome.msg, outcome.result, outcome.err)
+	notifyAfterID(config, outcome.id, outcome.msg, outcome.result, outcome.err)
+	if callback, ok := config.callback.(MetaCallback); ok {
+		callback.OnAfterMeta(outcome.msg, outcome.result, outcome.err, outcome.meta)
+	}
+}
+
+// notifyAfterID calls OnAfterID with the outcome of a message submitted with an ID if the callback implements IDCallback
+// notifyAfterID 如果回调函数实现了 IDCallback，则使用携带 ID 提交的消息的处理结果调用 OnAfterID
+func notifyAfterID(config *Config, id string, msg, result any, err error) {
+	if id == "" {
+		return
+	}
+	if callback, ok := config.callback.(IDCallback); ok {
+		callback.OnAfterID(id, msg, result, err)
+	}
+}
+
+// callHandler runs the handler on the message inside the tracer span, applying the task timeout if one is configured.
+// When the timeout expires the handler keeps running in its own goroutine and its late result is discarded,
+// so handlers that never return will leak that goroutine.
+// callHandler 在追踪区间内对消息执行处理函数，如果配置了任务超时则应用超时控制。
+// 超时后处理函数仍会在自己的协程中继续运行，其迟到的结果会被丢弃，因此永不返回的处理函数会导致该协程泄漏。
+func callHandler(config *Config, ctx context.Context, fn MessageHandleFunc, msg any) (result any, err error) {
+	// Start a span covering the whole call, ended with its outcome
+	// 开始一个覆盖整个调用的追踪区间，并以调用结果结束它
+	if config.tracer != nil {
+		var end func(err error)
+		ctx, end = config.tracer(ctx, msg)
+		if end != nil {
+			defer func() { end(err) }()
+		}
+	}
+
+	// Call the handler directly when no timeout is configured
+	// 未配置超时时直接调用处理函数
+	if config.taskTimeout <= 0 {
+		return runHandler(config, ctx, fn, msg)
+	}
+
+	// Context-aware handlers are told about the timeout through their context
+	// 通过上下文通知可感知上下文的处理函数超时
+	ctx, cancel := context.WithTimeout(ctx, config.taskTimeout)
+	defer cancel()
+
+	// Buffered channel so the handler goroutine never blocks after a timeout
+	// 使用带缓冲的通道，保证超时后处理函数协程不会阻塞
+	done := make(chan handlerOutcome, 1)
+	go func() {
+		result, err := runHandler(config, ctx, fn, msg)
+		done <- handlerOutcome{result: result, err: err}
+	}()
+
+	timer := time.NewTimer(config.taskTimeout)
+	defer timer.Stop()
+
+	select {
+	case outcome := <-done:
+		return outcome.result, outcome.err
+	case <-timer.C:
+		return nil, ErrTaskTimeout
+	}
+}
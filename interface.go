@@ -1,6 +1,12 @@
 // abpxx6d04wxr 包含队列接口的定义
 package karta
 
+import (
+	"time"
+
+	"github.com/shengyanli1982/karta/internal"
+)
+
 // Callback 是一个接口，定义了在消息处理前后需要调用的方法
 // Callback is an interface that defines methods to be called before and after message processing
 type Callback = interface {
@@ -13,6 +19,115 @@ type Callback = interface {
 	OnAfter(msg, result any, err error)
 }
 
+// PanicCallback 是一个可选接口，Callback 实现该接口后，会在处理函数发生 panic 时收到通知。
+// OnPanic 在 OnAfter（收到包装 ErrHandlerPanic 的错误）之前被调用
+// PanicCallback is an optional interface, a Callback implementing it is notified when the handler panics.
+// OnPanic is called before OnAfter, which receives an error wrapping ErrHandlerPanic
+type PanicCallback = interface {
+	// OnPanic 是一个方法，它在处理函数发生 panic 时被调用，接收消息 msg、recover 得到的值 recovered 和调用栈 stack
+	// OnPanic is a method that is called when the handler panics, it receives the message msg, the recovered value recovered and the call stack
+	OnPanic(msg any, recovered any, stack []byte)
+}
+
+// ErrorCallback 是一个可选接口，Callback 实现该接口后，会在任务以错误结束时收到通知，无需在 OnAfter 中过滤错误。
+// OnError 在 OnAfter 之后被调用，只在 OnAfter 收到非 nil 的错误时调用
+// ErrorCallback is an optional interface, a Callback implementing it is notified when a task completes with an error, without
+// filtering errors in OnAfter. OnError is called after OnAfter, only when OnAfter receives a non-nil error
+type ErrorCallback = interface {
+	// OnError 是一个方法，它在消息 msg 的处理以错误 err 结束时被调用
+	// OnError is a method that is called when handling the message msg completes with the error err
+	OnError(msg any, err error)
+}
+
+// WorkerCallback 是一个可选接口，Callback 实现该接口后，会在 Pipeline 的工作协程启动和退出时收到通知，用于观察工作协程的动态伸缩。
+// 两个方法都会收到变化之后的运行中工作协程数量，它们在工作协程自己的协程中被调用，可能并发执行
+// WorkerCallback is an optional interface, a Callback implementing it is notified when a Pipeline worker starts and exits,
+// which makes the dynamic scaling observable. Both methods receive the number of running workers after the change, they are
+// called on the worker goroutine itself and may run concurrently
+type WorkerCallback = interface {
+	// OnWorkerStart 是一个方法，它在工作协程启动时被调用，running 包含该工作协程
+	// OnWorkerStart is a method that is called when a worker starts, running includes that worker
+	OnWorkerStart(running int64)
+
+	// OnWorkerStop 是一个方法，它在工作协程退出时被调用，running 不包含该工作协程
+	// OnWorkerStop is a method that is called when a worker exits, running excludes that worker
+	OnWorkerStop(running int64)
+}
+
+// DropCallback 是一个可选接口，Callback 实现该接口后，会在 Pipeline 接收的消息因队列拒绝放入而被丢弃时收到通知，
+// 使生产者可以重试、记录日志或统计指标。提交方法同时会返回相同的错误
+// DropCallback is an optional interface, a Callback implementing it is notified when a message is dropped because the queue
+// rejects it, so producers can retry, log or count it. The submit method returns the same error as well
+type DropCallback = interface {
+	// OnDrop 是一个方法，它在消息 msg 因放入队列失败（错误为 err）而被丢弃时被调用
+	// OnDrop is a method that is called when the message msg is dropped because putting it into the queue failed with err
+	OnDrop(msg any, err error)
+}
+
+// IDCallback 是一个可选接口，Callback 实现该接口后，会在通过 Pipeline 的 SubmitWithID 提交的任务完成时，在 OnAfter 之后收到提交时给定的 ID，
+// 从而无需将 ID 嵌入消息就能关联提交和处理结果
+// IDCallback is an optional interface, a Callback implementing it receives the ID given at submission after OnAfter when a task
+// submitted with the SubmitWithID method of Pipeline completes, so submissions can be correlated with their outcome without
+// embedding IDs in the messages
+type IDCallback = interface {
+	// OnAfterID 是一个方法，它在 ID 为 id 的消息 msg 处理完成后被调用，参数与 OnAfter 相同
+	// OnAfterID is a method that is called after the message msg with the ID id has been handled, with the same arguments as OnAfter
+	OnAfterID(id string, msg, result any, err error)
+}
+
+// TaskMeta 描述 Pipeline 任务的处理方式
+// TaskMeta describes how a Pipeline task was handled
+type TaskMeta struct {
+	// Custom 表示任务是否通过 SubmitWithFunc 等方式使用了自定义处理函数，而不是配置的处理函数
+	// Custom reports whether the task ran a custom handler, as with SubmitWithFunc, instead of the configured one
+	Custom bool
+
+	// Duration 是处理函数的执行耗时，未调用处理函数时为 0
+	// Duration is how long the handler ran, 0 when the handler was not called
+	Duration time.Duration
+
+	// Attempts 是包括本次在内的处理次数
+	// Attempts is the number of times the task has been handled, including this one
+	Attempts int
+
+	// Headers 是通过 SubmitWithMeta 附加到任务的元数据，没有时为 nil
+	// Headers is the metadata attached to the task with SubmitWithMeta, nil if none
+	Headers map[string]any
+}
+
+// MetaCallback 是一个可选接口，Callback 实现该接口后，会在 Pipeline 任务完成时，在 OnAfter 之后收到任务的处理元数据
+// MetaCallback is an optional interface, a Callback implementing it receives the metadata of how a Pipeline task was handled
+// after OnAfter when the task completes
+type MetaCallback = interface {
+	// OnAfterMeta 是一个方法，它在消息 msg 处理完成后被调用，参数与 OnAfter 相同，并附带任务元数据 meta
+	// OnAfterMeta is a method that is called after the message msg has been handled, with the same arguments as OnAfter and
+	// the task metadata meta
+	OnAfterMeta(msg, result any, err error, meta TaskMeta)
+}
+
+// ChunkCallback 是一个可选接口，Callback 实现该接口后，Pipeline 会将处理函数返回的 <-chan any 中的部分结果
+// 按到达顺序逐个传递给 OnChunk。工作协程在通道关闭前一直处于忙碌状态，通道关闭后 OnAfter 收到的结果为 nil
+// ChunkCallback is an optional interface, when a Callback implements it, Pipeline streams the partial results from
+// a <-chan any returned by the handler to OnChunk in arrival order. The worker stays busy until the channel is closed,
+// after which OnAfter receives a nil result
+type ChunkCallback = interface {
+	// OnChunk 是一个方法，它在收到消息 msg 的每一个部分结果 chunk 时被调用
+	// OnChunk is a method that is called for every partial result chunk of the message msg
+	OnChunk(msg any, chunk any)
+}
+
+// SubmitCallback 是一个可选接口，Callback 实现该接口后，会在 Pipeline 接收任务（成功放入队列）时收到通知。
+// OnSubmit 在任务放入队列之后调用，而工作协程可能立即取出并处理该任务，因此它与 OnBefore 和 OnAfter 之间没有顺序保证，
+// 可能在 OnAfter 之后才运行。重试时重新入队不会再次调用 OnSubmit
+// SubmitCallback is an optional interface, a Callback implementing it is notified when Pipeline accepts a task into the queue.
+// OnSubmit is called after the task is put into the queue, where a worker may pick it up and process it right away, so it is
+// not ordered with OnBefore and OnAfter and may even run after OnAfter. Re-queueing on retry does not call OnSubmit again
+type SubmitCallback = interface {
+	// OnSubmit 是一个方法，它在消息 msg 被成功放入队列后被调用
+	// OnSubmit is a method that is called after the message msg is successfully put into the queue
+	OnSubmit(msg any)
+}
+
 // emptyCallback 是一个实现了 Callback 接口的结构体，但是它的方法都是空的
 // emptyCallback is a struct that implements the Callback interface, but its methods are all empty
 type emptyCallback struct{}
@@ -25,10 +140,98 @@ func (emptyCallback) OnBefore(msg any) {}
 // OnAfter is a method of emptyCallback, it is called after message processing, but does nothing
 func (emptyCallback) OnAfter(msg, result any, err error) {}
 
+// OnPanic 是 emptyCallback 的方法，它在处理函数发生 panic 时被调用，但是什么都不做
+// OnPanic is a method of emptyCallback, it is called when the handler panics, but does nothing
+func (emptyCallback) OnPanic(msg any, recovered any, stack []byte) {}
+
 // NewEmptyCallback 是一个函数，它创建并返回一个新的 emptyCallback
 // NewEmptyCallback is a function that creates and returns a new emptyCallback
 func NewEmptyCallback() Callback { return &emptyCallback{} }
 
+// funcCallback 是一个使用函数实现 Callback 接口的结构体，为 nil 的函数不做任何事情
+// funcCallback is a struct that implements the Callback interface with functions, nil functions do nothing
+type funcCallback struct {
+	onBefore func(msg any)
+	onAfter  func(msg, result any, err error)
+}
+
+// OnBefore 是 funcCallback 的方法，它在消息处理前调用 onBefore
+// OnBefore is a method of funcCallback, it calls onBefore before message processing
+func (c *funcCallback) OnBefore(msg any) {
+	if c.onBefore != nil {
+		c.onBefore(msg)
+	}
+}
+
+// OnAfter 是 funcCallback 的方法，它在消息处理后调用 onAfter
+// OnAfter is a method of funcCallback, it calls onAfter after message processing
+func (c *funcCallback) OnAfter(msg, result any, err error) {
+	if c.onAfter != nil {
+		c.onAfter(msg, result, err)
+	}
+}
+
+// CallbackFunc 是一个函数，它使用给定的函数创建一个 Callback，只需提供关心的回调函数，为 nil 的函数不做任何事情
+// CallbackFunc is a function that creates a Callback from the given functions, so only the hooks of interest need to be
+// supplied, nil functions do nothing
+func CallbackFunc(onBefore func(msg any), onAfter func(msg, result any, err error)) Callback {
+	return &funcCallback{onBefore: onBefore, onAfter: onAfter}
+}
+
+// Logger 是一个接口，定义了输出内部调试和警告日志的方法
+// Logger is an interface that defines methods to output internal debug and warning logs
+type Logger = interface {
+	// Debugf 是一个方法，用于输出调试日志，例如工作协程的创建和回收
+	// Debugf is a method used to output debug logs, such as workers being spawned and reaped
+	Debugf(format string, args ...any)
+
+	// Warnf 是一个方法，用于输出警告日志，例如向已关闭的管道提交消息
+	// Warnf is a method used to output warning logs, such as submitting to a closed pipeline
+	Warnf(format string, args ...any)
+}
+
+// emptyLogger 是一个实现了 Logger 接口的结构体，但是它的方法都是空的
+// emptyLogger is a struct that implements the Logger interface, but its methods are all empty
+type emptyLogger struct{}
+
+// Debugf 是 emptyLogger 的方法，它什么都不输出
+// Debugf is a method of emptyLogger, it outputs nothing
+func (emptyLogger) Debugf(format string, args ...any) {}
+
+// Warnf 是 emptyLogger 的方法，它什么都不输出
+// Warnf is a method of emptyLogger, it outputs nothing
+func (emptyLogger) Warnf(format string, args ...any) {}
+
+// NewEmptyLogger 是一个函数，它创建并返回一个新的 emptyLogger
+// NewEmptyLogger is a function that creates and returns a new emptyLogger
+func NewEmptyLogger() Logger { return &emptyLogger{} }
+
+// ElementExt 是 Pipeline 放入队列的元素类型，零值即可直接使用
+// ElementExt is the type of the elements Pipeline puts into the queue, its zero value is ready to use
+type ElementExt = internal.ElementExt
+
+// ElementPooler 是一个接口，定义了 Pipeline 获取和归还元素的对象池，可用于为大消息提供定制的对象池
+// ElementPooler is an interface that defines the pool Pipeline takes elements from and returns them to, it can be used
+// to supply a customized pool for large messages
+type ElementPooler = interface {
+	// Get 方法用于从池中获取一个元素，池为空时应返回一个新的元素
+	// The Get method is used to take an element from the pool, it should return a new element if the pool is empty
+	Get() *ElementExt
+
+	// Put 方法用于将元素归还到池中，元素在归还前已被重置
+	// The Put method is used to return an element to the pool, the element has been reset before it is returned
+	Put(element *ElementExt)
+}
+
+// AllocCounter 是一个可选接口，元素池可以实现它来报告新分配的元素数量，该值会出现在 PoolStats 中
+// AllocCounter is an optional interface an element pool can implement to report the number of newly allocated
+// elements, the value shows up in PoolStats
+type AllocCounter = interface {
+	// Allocs 方法返回池为空时新分配的元素数量
+	// The Allocs method returns the number of elements newly allocated because the pool was empty
+	Allocs() int64
+}
+
 // Queue 接口定义了一个队列应该具备的基本操作。
 // The Queue interface defines the basic operations that a queue should have.
 type Queue = interface {
@@ -1,6 +1,11 @@
 package internal
 
-import "sync"
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 type Element struct {
 	data  any
@@ -55,9 +60,21 @@ func (elementPool *ElementPool) Put(element *Element) {
 
 type MessageHandleFunc = func(msg any) (any, error)
 
+type ResultFunc = func(result any, err error)
+
 type ElementExt struct {
 	Element
-	fn MessageHandleFunc
+	fn         MessageHandleFunc
+	attempts   int
+	requeues   int
+	ctx        context.Context
+	resultFunc ResultFunc
+	priority   int64
+	deadline   time.Time
+	id         string
+	meta       map[string]any
+	scheduled  bool
+	started    bool
 }
 
 func (e *ElementExt) GetHandleFunc() MessageHandleFunc {
@@ -68,23 +85,123 @@ func (e *ElementExt) SetHandleFunc(fn MessageHandleFunc) {
 	e.fn = fn
 }
 
+func (e *ElementExt) GetAttempts() int {
+	return e.attempts
+}
+
+func (e *ElementExt) SetAttempts(attempts int) {
+	e.attempts = attempts
+}
+
+func (e *ElementExt) GetRequeues() int {
+	return e.requeues
+}
+
+func (e *ElementExt) SetRequeues(requeues int) {
+	e.requeues = requeues
+}
+
+func (e *ElementExt) GetContext() context.Context {
+	return e.ctx
+}
+
+func (e *ElementExt) SetContext(ctx context.Context) {
+	e.ctx = ctx
+}
+
+func (e *ElementExt) GetResultFunc() ResultFunc {
+	return e.resultFunc
+}
+
+func (e *ElementExt) SetResultFunc(fn ResultFunc) {
+	e.resultFunc = fn
+}
+
+func (e *ElementExt) GetPriority() int64 {
+	return e.priority
+}
+
+func (e *ElementExt) SetPriority(priority int64) {
+	e.priority = priority
+}
+
+func (e *ElementExt) GetDeadline() time.Time {
+	return e.deadline
+}
+
+func (e *ElementExt) SetDeadline(deadline time.Time) {
+	e.deadline = deadline
+}
+
+func (e *ElementExt) GetID() string {
+	return e.id
+}
+
+func (e *ElementExt) SetID(id string) {
+	e.id = id
+}
+
+func (e *ElementExt) GetMeta() map[string]any {
+	return e.meta
+}
+
+func (e *ElementExt) SetMeta(meta map[string]any) {
+	e.meta = meta
+}
+
+func (e *ElementExt) IsScheduled() bool {
+	return e.scheduled
+}
+
+func (e *ElementExt) SetScheduled(scheduled bool) {
+	e.scheduled = scheduled
+}
+
+func (e *ElementExt) IsStarted() bool {
+	return e.started
+}
+
+func (e *ElementExt) SetStarted(started bool) {
+	e.started = started
+}
+
+func (e *ElementExt) IsExpired(now time.Time) bool {
+	return !e.deadline.IsZero() && !now.Before(e.deadline)
+}
+
 func (e *ElementExt) Reset() {
 	e.Element.Reset()
 	e.fn = nil
+	e.attempts = 0
+	e.requeues = 0
+	e.ctx = nil
+	e.resultFunc = nil
+	e.priority = 0
+	e.deadline = time.Time{}
+	e.id = ""
+	e.meta = nil
+	e.scheduled = false
+	e.started = false
 }
 
 type ElementExtPool struct {
 	syncPool *sync.Pool
+	allocs   atomic.Int64
 }
 
 func NewElementExtPool() *ElementExtPool {
-	return &ElementExtPool{
-		syncPool: &sync.Pool{
-			New: func() any {
-				return &ElementExt{}
-			},
+	pool := &ElementExtPool{}
+	pool.syncPool = &sync.Pool{
+		New: func() any {
+			pool.allocs.Add(1)
+			return &ElementExt{}
 		},
 	}
+	return pool
+}
+
+func (elementExtPool *ElementExtPool) Allocs() int64 {
+	return elementExtPool.allocs.Load()
 }
 
 func (elementExtPool *ElementExtPool) Get() *ElementExt {
@@ -0,0 +1,58 @@
+package karta
+
+import "sync"
+
+// callbackSequencer is a reorder buffer delivering task outcomes to the callback in submission sequence.
+// Outcomes completed ahead of an earlier task are held until that task completes.
+// callbackSequencer 是一个重排序缓冲区，按提交序号将任务处理结果传递给回调函数。先于更早的任务完成的结果会被保留，直到该任务完成。
+type callbackSequencer struct {
+	lock     sync.Mutex
+	config   *Config
+	next     int64                  // next sequence to deliver / 下一个要传递的序号
+	outcomes map[int64]*taskOutcome // outcomes completed out of order / 乱序完成的结果
+}
+
+// newCallbackSequencer creates a sequencer delivering to the callback of config, starting at the first submission sequence
+// newCallbackSequencer 创建一个将结果传递给 config 中回调函数的重排序缓冲区，从第一个提交序号开始
+func newCallbackSequencer(config *Config) *callbackSequencer {
+	return &callbackSequencer{
+		config:   config,
+		next:     1,
+		outcomes: make(map[int64]*taskOutcome),
+	}
+}
+
+// complete records the outcome of the task with the given sequence and delivers every outcome that is now in order
+// complete 记录给定序号的任务处理结果，并传递所有已经按顺序就绪的结果
+func (s *callbackSequencer) complete(seq int64, outcome *taskOutcome) {
+	s.deliver(seq, outcome)
+}
+
+// skip marks a sequence that was never enqueued, so that it does not hold back later outcomes
+// skip 标记一个从未入队的序号，使其不会阻塞之后的结果
+func (s *callbackSequencer) skip(seq int64) {
+	s.deliver(seq, &taskOutcome{skip: true})
+}
+
+// deliver stores the outcome and fires OnBefore and the after callbacks for every consecutive outcome from the next sequence.
+// The lock is held while calling back, so callbacks never run concurrently.
+// deliver 保存结果，并从下一个序号开始为每个连续的结果调用 OnBefore 和处理后的回调函数。回调期间持有锁，因此回调函数不会并发执行。
+func (s *callbackSequencer) deliver(seq int64, outcome *taskOutcome) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.outcomes[seq] = outcome
+	for {
+		current, ok := s.outcomes[s.next]
+		if !ok {
+			return
+		}
+		delete(s.outcomes, s.next)
+		s.next++
+
+		if !current.skip {
+			s.config.callback.OnBefore(current.msg)
+			notifyOutcome(s.config, current)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package karta
 import (
 	"context"
 	"errors"
+	"math/rand"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -13,41 +14,95 @@ import (
 
 // 常量定义 Constants definition
 const (
-	immediateDelay        = 0 // 立即执行的迟值 Immediate execution delay value
-	defaultMinWorkerCount = 1 // 默认最小工作协程数 Default minimum number of worker goroutines
+	immediateDelay        = 0  // 立即执行的迟值 Immediate execution delay value
+	defaultMinWorkerCount = 1  // 默认最小工作协程数 Default minimum number of worker goroutines
+	maxRetryAfterRequeues = 64 // 处理函数请求重新入队的最大次数 Maximum number of re-queues requested by the handler
 )
 
 // 变量定义 Variables definition
 var (
-	ErrorQueueClosed          = errors.New("pipeline is closed")  // 管道关闭错误 Pipeline closed error
-	defaultWorkerIdleTimeout  = (10 * time.Second).Milliseconds() // 默认工作协程空闲超时时间 Default worker idle timeout
-	defaultWorkerScanInterval = 3 * time.Second                   // 默认工作协程扫描间隔 Default worker scan interval
-	defaultWorkerBurstLimit   = 8                                 // 默认工作协程突发限制 Default worker burst limit
-	defaultWorkerSpawnRate    = 4                                 // 默认工作协程生成速率 Default worker spawn rate
+	ErrorQueueClosed            = errors.New("pipeline is closed")                           // 管道关闭错误 Pipeline closed error
+	ErrDrainTimeout             = errors.New("pipeline drain timed out with pending tasks")  // 排空超时错误 Drain timeout error
+	ErrNilQueue                 = errors.New("queue is nil")                                 // 队列为空错误 Nil queue error
+	ErrQueueFull                = errors.New("pipeline is full")                             // 管道已满错误 Pipeline full error
+	ErrStopTimeout              = errors.New("pipeline stop timed out with running workers") // 停止超时错误 Stop timeout error
+	defaultBlockingPollInterval = 10 * time.Millisecond                                      // 默认阻塞提交检查间隔 Default blocking submit poll interval
+	defaultDrainPollInterval    = 10 * time.Millisecond                                      // 默认排空检查间隔 Default drain poll interval
+	defaultGetPollInterval      = 10 * time.Millisecond                                      // 默认队列轮询间隔 Default queue poll interval
+	defaultWorkerIdleTimeout    = (10 * time.Second).Milliseconds()                          // 默认工作协程空闲超时时间 Default worker idle timeout
+	defaultWorkerScanInterval   = 3 * time.Second                                            // 默认工作协程扫描间隔 Default worker scan interval
+	defaultWorkerBurstLimit     = 8                                                          // 默认工作协程突发限制 Default worker burst limit
+	defaultWorkerSpawnRate      = 4                                                          // 默认工作协程生成速率 Default worker spawn rate
 )
 
 // Pipeline 结构体定义了一个消息处理管道
 // Pipeline struct defines a message processing pipeline
 type Pipeline struct {
-	queue        DelayingQueue            // 延迟队列 Delaying queue
-	config       *Config                  // 配置信息 Configuration
-	wg           sync.WaitGroup           // 等待组 Wait group
-	once         sync.Once                // 确保只执行一次 Ensure single execution
-	ctx          context.Context          // 上下文 Context
-	cancel       context.CancelFunc       // 取消函数 Cancel function
-	timer        atomic.Int64             // 计时器 Timer
-	runningCount atomic.Int64             // 运行中的工作协程数量 Number of running workers
-	elementPool  *internal.ElementExtPool // 元素池 Element pool
-	workerLimit  *rate.Limiter            // 工作协程限制器 Worker limiter
-}
-
-// NewPipeline creates a new pipeline instance with the given queue and configuration
-// NewPipeline 使用给定的队列和配置创建一个新的管道实例
+	queue        DelayingQueue                          // 延迟队列 Delaying queue
+	config       *Config                                // 配置信息 Configuration
+	wg           sync.WaitGroup                         // 等待组 Wait group
+	once         sync.Once                              // 确保只执行一次 Ensure single execution
+	ctx          context.Context                        // 上下文 Context
+	cancel       context.CancelFunc                     // 取消函数 Cancel function
+	timer        atomic.Int64                           // 计时器 Timer
+	runningCount atomic.Int64                           // 运行中的工作协程数量 Number of running workers
+	maxWorkers   atomic.Int64                           // 工作协程数量上限 Worker ceiling
+	handleFunc   atomic.Pointer[MessageHandleFunc]      // 默认处理函数 Default handler function
+	fanOutOn     atomic.Bool                            // 默认处理函数是否为扇出处理函数 Whether the default handler is the fan-out handler
+	sequence     atomic.Int64                           // 提交序号 Submission sequence
+	pending      atomic.Int64                           // 已提交但尚未完成的任务数量 Number of submitted but not completed tasks
+	queued       atomic.Int64                           // 已入队但尚未被工作协程取出的任务数量 Number of enqueued tasks not yet dequeued by workers
+	enqueueLock  sync.RWMutex                           // 入队与停止时清点剩余任务之间的锁 Lock between enqueueing and forgetting the tasks left behind on stop
+	scheduled    atomic.Int64                           // 延迟入队但尚未完成的任务数量 Number of tasks enqueued with a delay and not yet completed
+	closing      atomic.Bool                            // 是否停止接收新任务 Whether new submissions are rejected
+	collecting   atomic.Bool                            // 工作协程是否停止获取任务以便收集剩余任务 Whether workers stop taking tasks so the rest can be collected
+	inflightLock sync.Mutex                             // 处理中任务集合的锁 Lock of the in-flight task set
+	inflight     map[*internal.ElementExt]*inflightTask // 处理中的任务 In-flight tasks
+	inflightNum  atomic.Int64                           // 正在处理的任务数量 Number of tasks being handled
+	waitLock     sync.Mutex                             // 工作协程空闲通知通道的锁 Lock of the worker freed channel
+	freed        chan struct{}                          // 工作协程空闲时关闭的通知通道 Channel closed when a worker is freed
+	waiters      atomic.Int64                           // 等待工作协程空闲的提交数量 Number of submissions waiting for a free worker
+	dedupLock    sync.Mutex                             // 去重键集合的锁 Lock of the dedup key set
+	dedupKeys    map[string][]internal.ResultFunc       // 排队中或处理中任务的去重键及合并到其中的提交者 Dedup keys of queued or handled tasks and the submitters coalesced into them
+	processed    atomic.Int64                           // 已完成的任务总数 Total number of completed tasks
+	failed       atomic.Int64                           // 以错误结束的任务总数 Total number of failed tasks
+	elementPool  *elementExtPoolCounter                 // 元素池 Element pool
+	workerLimit  *rate.Limiter                          // 工作协程限制器 Worker limiter
+	processLimit *rate.Limiter                          // 任务处理速率限制器，未启用时为 nil Task processing rate limiter, nil if not enabled
+	timing       *durationRecorder                      // 处理函数耗时记录器，未启用时为 nil Handler duration recorder, nil if not enabled
+	measure      bool                                   // 是否测量处理函数耗时 Whether handler durations are measured
+	sequencer    *callbackSequencer                     // 按提交顺序调用回调函数的缓冲区，未启用时为 nil Buffer calling back in submission order, nil if not enabled
+	batcher      *resultBatcher                         // 分批输出结果到 sink 的批处理器，未启用时为 nil Batcher flushing results to the sink, nil if not enabled
+	stopped      chan struct{}                          // 管道停止且工作协程结束后关闭的通道 Channel closed once the pipeline is stopped and its workers are done
+	futureLock   sync.Mutex                             // 未完成 Future 集合的锁 Lock of the unresolved future set
+	futures      map[*Future]struct{}                   // 任务尚未完成的 Future Futures whose task has not completed yet
+}
+
+// NewPipeline creates a new pipeline instance with the given queue and configuration.
+// It returns nil if queue is nil, and silently replaces an invalid worker number with the default one.
+// NewPipeline 使用给定的队列和配置创建一个新的管道实例。如果队列为空则返回 nil，无效的工作协程数量会被静默替换为默认值。
 func NewPipeline(queue DelayingQueue, config *Config) *Pipeline {
-	// Check if queue is nil, return nil if true
-	// 检查队列是否为空，如果为空则返回 nil
+	// Normalize the configuration first so that it always passes validation
+	// 先规范化配置，使其总能通过验证
+	pipeline, _ := NewPipelineWithError(queue, isConfigValid(config))
+	return pipeline
+}
+
+// NewPipelineWithError creates a new pipeline instance with the given queue and configuration, returning
+// ErrNilQueue if queue is nil and ErrInvalidWorkerNumber if the worker number is out of range instead of clamping it
+// NewPipelineWithError 使用给定的队列和配置创建一个新的管道实例。如果队列为空则返回 ErrNilQueue，
+// 如果工作协程数量超出范围则返回 ErrInvalidWorkerNumber，而不是将其修正为默认值
+func NewPipelineWithError(queue DelayingQueue, config *Config) (*Pipeline, error) {
+	// Check if queue is nil
+	// 检查队列是否为空
 	if queue == nil {
-		return nil
+		return nil, ErrNilQueue
+	}
+
+	// Check if the worker number is in range
+	// 检查工作协程数量是否在有效范围内
+	if config != nil && !config.singleWorker && !isWorkerNumberValid(config.num) {
+		return nil, ErrInvalidWorkerNumber
 	}
 
 	// Validate and normalize configuration
@@ -61,43 +116,300 @@ func NewPipeline(queue DelayingQueue, config *Config) *Pipeline {
 	// Initialize pipeline instance with basic components
 	// 初始化管道实例的基本组件
 	pipeline := &Pipeline{
-		queue:       queue,
-		config:      config,
-		elementPool: internal.NewElementExtPool(),
-		// Create rate limiter for worker spawning with default settings
-		// 使用默认设置创建工作协程生成的速率限制器
-		workerLimit: rate.NewLimiter(rate.Limit(defaultWorkerSpawnRate), defaultWorkerBurstLimit),
+		queue:  queue,
+		config: config,
+		// Create rate limiter for worker spawning with configured settings
+		// 使用配置的参数创建工作协程生成的速率限制器
+		workerLimit: rate.NewLimiter(rate.Limit(config.spawnRate), config.spawnBurst),
 		ctx:         ctx,
 		cancel:      cancel,
+		stopped:     make(chan struct{}),
+		futures:     make(map[*Future]struct{}),
+	}
+
+	// Use the configured element pool, or a default one
+	// 使用配置的元素池，没有则使用默认的元素池
+	if config.elementPool != nil {
+		pipeline.elementPool = newElementExtPoolCounter(config.elementPool)
+	} else {
+		pipeline.elementPool = newElementExtPoolCounter(internal.NewElementExtPool())
+	}
+
+	// Throttle task processing only when a processing rate is configured
+	// 仅在配置了处理速率时限制任务处理
+	if config.processRate > 0 {
+		burst := config.processBurst
+		if burst < 1 {
+			burst = 1
+		}
+		pipeline.processLimit = rate.NewLimiter(rate.Limit(config.processRate), burst)
+	}
+
+	// Record handler durations only when timing is enabled
+	// 仅在启用计时时记录处理函数耗时
+	if config.timing {
+		pipeline.timing = newDurationRecorder()
+	}
+
+	// Measure handler durations only when they are recorded or reported to a MetaCallback
+	// 仅在需要记录耗时或将耗时报告给 MetaCallback 时测量处理函数耗时
+	_, metaCallback := config.callback.(MetaCallback)
+	pipeline.measure = pipeline.timing != nil || metaCallback
+
+	// Reorder callbacks only when ordered callbacks are enabled
+	// 仅在启用有序回调时对回调进行重排序
+	if config.orderedCallbacks {
+		pipeline.sequencer = newCallbackSequencer(config)
+	}
+
+	// Batch results only when a sink is configured
+	// 仅在配置了 sink 时分批输出结果
+	if config.sink != nil {
+		pipeline.batcher = newResultBatcher(config)
+	}
+
+	// Track dedup keys only when deduplication is enabled
+	// 仅在启用去重时跟踪去重键
+	if config.dedupKey != nil {
+		pipeline.dedupKeys = make(map[string][]internal.ResultFunc)
+	}
+
+	// Track in-flight tasks only when preemption is enabled
+	// 仅在启用抢占时跟踪处理中的任务
+	if config.preemption {
+		pipeline.inflight = make(map[*internal.ElementExt]*inflightTask)
 	}
 
 	// Initialize timer with current timestamp
 	// 使用当前时间戳初始化计时器
-	pipeline.timer.Store(time.Now().UnixMilli())
+	pipeline.timer.Store(config.clock.Now().UnixMilli())
+
+	// Set default handler function from configuration
+	// 使用配置设置默认处理函数
+	pipeline.handleFunc.Store(&config.handleFunc)
+	pipeline.fanOutOn.Store(config.fanOut != nil)
+
+	// Set worker ceiling from configuration
+	// 使用配置设置工作协程数量上限
+	pipeline.maxWorkers.Store(int64(config.num))
 
 	// Set initial running worker count
 	// 设置初始运行的工作协程数量
 	pipeline.runningCount.Store(1)
 
-	// Start background goroutines for execution and timer update
-	// 启动用于执行和计时器更新的后台协程
-	pipeline.wg.Add(2)
+	// Start background goroutines for execution and timer update, the first worker is always started whatever the global limit
+	// 启动用于执行和计时器更新的后台协程，无论全局限制如何，第一个工作协程总会启动
+	globalWorkers.pipelines.Add(1)
+	globalWorkers.running.Add(1)
+	pipeline.wg.Add(1)
 	go pipeline.executor()
-	go pipeline.updateTimer()
 
-	return pipeline
+	// Flush partial batches periodically only when a flush interval is configured
+	// 仅在配置了输出间隔时定期输出未满的批次
+	if pipeline.batcher != nil && config.sinkInterval > 0 {
+		pipeline.wg.Add(1)
+		go pipeline.flushSink()
+	}
+
+	// The timer is only used for reaping idle workers
+	// 计时器仅用于回收空闲的工作协程
+	if !config.noIdleReaping {
+		pipeline.wg.Add(1)
+		go pipeline.updateTimer()
+	}
+
+	return pipeline, nil
 }
 
 // Stop 停止管道的运行
 // Stop stops the pipeline
 func (pipeline *Pipeline) Stop() {
+	pipeline.stop(false)
+}
+
+// StopWithTimeout 停止管道的运行，如果工作协程未能在 d 内结束则返回 ErrStopTimeout，剩余的工作协程会在其上下文被取消后自行退出
+// StopWithTimeout stops the pipeline, returning ErrStopTimeout if the workers do not finish within d, the remaining workers
+// are left to exit on their own once their cancelled context is observed
+func (pipeline *Pipeline) StopWithTimeout(d time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		pipeline.stop(false)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-pipeline.config.clock.After(d):
+		return ErrStopTimeout
+	}
+}
+
+// stop 停止管道的运行，abandon 为 true 时先关闭队列，使工作协程不再获取剩余的任务
+// stop stops the pipeline, if abandon is true the queue is shut down first so workers don't pick up the remaining tasks
+func (pipeline *Pipeline) stop(abandon bool) {
 	pipeline.once.Do(func() {
+		pipeline.closing.Store(true)
 		pipeline.cancel()
+		if abandon {
+			pipeline.queue.Shutdown()
+		}
 		pipeline.wg.Wait()
+		globalWorkers.pipelines.Add(-1)
+		pipeline.flushFinal()
 		pipeline.queue.Shutdown()
+		pipeline.forget()
+		close(pipeline.stopped)
 	})
 }
 
+// forget stops counting the tasks left behind in the queue, including delayed tasks that are not due yet, as pending
+// once the workers are done, since they never run after the pipeline is stopped
+// forget 在工作协程结束后不再将留在队列中的任务（包括尚未到期的延迟任务）计入待完成数量，因为管道停止后它们永远不会被执行
+func (pipeline *Pipeline) forget() {
+	// Wait for the enqueues in progress, which either count their element or fail on the shut down queue
+	// 等待进行中的入队操作，它们要么计入其元素，要么因队列已关闭而失败
+	pipeline.enqueueLock.Lock()
+	defer pipeline.enqueueLock.Unlock()
+
+	pipeline.pending.Add(-pipeline.queued.Swap(0))
+	pipeline.scheduled.Store(0)
+
+	// The futures still unresolved belong to the tasks left behind, which never run
+	// 仍未完成的 Future 属于被留下的任务，这些任务永远不会执行
+	pipeline.futureLock.Lock()
+	futures := pipeline.futures
+	pipeline.futures = make(map[*Future]struct{})
+	pipeline.futureLock.Unlock()
+
+	for future := range futures {
+		future.resolve(nil, ErrorQueueClosed)
+	}
+}
+
+// StopAndCollect stops the pipeline like Stop, but instead of leaving the tasks still waiting in the queue behind, it takes
+// them out of the queue and returns their messages in dequeue order so they can be persisted and replayed later.
+// Tasks being handled when it is called are completed first, submitters waiting for the result of a collected task receive
+// ErrorQueueClosed. Delayed tasks that are not due yet are only returned if the queue hands them out through Get, and a queue
+// whose Get cannot drain it returns an empty slice. It returns nil if the pipeline was already stopped.
+// StopAndCollect 与 Stop 一样停止管道，但不会丢下仍在队列中等待的任务，而是将它们从队列中取出，并按出队顺序返回其消息，以便持久化后重放。
+// 调用时正在处理的任务会先完成，等待被收集任务结果的提交者会收到 ErrorQueueClosed。尚未到期的延迟任务只有在队列通过 Get 交出时才会返回，
+// 无法通过 Get 排空的队列返回空切片。如果管道已经停止则返回 nil。
+func (pipeline *Pipeline) StopAndCollect() []any {
+	var remaining []any
+	pipeline.once.Do(func() {
+		pipeline.closing.Store(true)
+		pipeline.collecting.Store(true)
+		pipeline.cancel()
+		pipeline.wg.Wait()
+		globalWorkers.pipelines.Add(-1)
+		pipeline.flushFinal()
+		remaining = pipeline.collect()
+		pipeline.queue.Shutdown()
+		pipeline.forget()
+		close(pipeline.stopped)
+	})
+	return remaining
+}
+
+// collect takes every element left in the queue, returning their messages and the elements to the pool
+// collect 取出队列中剩余的所有元素，返回其消息并将元素归还到对象池
+func (pipeline *Pipeline) collect() []any {
+	remaining := []any{}
+	for {
+		value, err := pipeline.queue.Get()
+		if err != nil {
+			return remaining
+		}
+		pipeline.queue.Done(value)
+		pipeline.queued.Add(-1)
+
+		element, ok := value.(*internal.ElementExt)
+		if !ok {
+			continue
+		}
+		data := element.GetData()
+		remaining = append(remaining, data)
+
+		// The task is not going to run, release whatever was waiting for it
+		// 任务不会再被执行，释放所有等待它的资源
+		if resultFunc := element.GetResultFunc(); resultFunc != nil {
+			resultFunc(nil, ErrorQueueClosed)
+		}
+		pipeline.release(data, nil, ErrorQueueClosed)
+		pipeline.unschedule(element)
+		pipeline.elementPool.Put(element)
+		pipeline.pending.Add(-1)
+	}
+}
+
+// StopAndDrain stops accepting new submissions and keeps the workers running until every pending task,
+// including retries and delayed tasks, has completed, then stops the pipeline. If ctx is done first,
+// the remaining tasks are abandoned and ErrDrainTimeout is returned.
+// StopAndDrain 停止接收新任务，并保持工作协程运行直到所有待完成的任务（包括重试和延迟任务）都已完成，然后停止管道。
+// 如果 ctx 先结束，剩余的任务将被放弃并返回 ErrDrainTimeout。
+func (pipeline *Pipeline) StopAndDrain(ctx context.Context) error {
+	// Reject new submissions
+	// 拒绝新的任务提交
+	pipeline.closing.Store(true)
+
+	// Wait for pending tasks to complete, there is nothing left to drain if the pipeline is already stopped
+	// 等待待完成的任务处理完毕，如果管道已经停止则没有需要排空的任务
+	if err := pipeline.WaitIdle(ctx); err != nil && err != ErrorQueueClosed {
+		pipeline.stop(true)
+		return ErrDrainTimeout
+	}
+
+	pipeline.stop(false)
+	return nil
+}
+
+// WaitIdle blocks until every submitted task, including retries and delayed tasks, has completed,
+// or returns the error of ctx if it is done first. It returns ErrorQueueClosed once the pipeline is stopped,
+// since the tasks left behind never complete. It does not stop the pipeline or reject new submissions
+// WaitIdle 阻塞直到所有已提交的任务（包括重试和延迟任务）都已完成，如果 ctx 先结束则返回 ctx 的错误。管道停止后返回 ErrorQueueClosed，
+// 因为留下的任务永远不会完成。它不会停止管道，也不会拒绝新的提交
+func (pipeline *Pipeline) WaitIdle(ctx context.Context) error {
+	return pipeline.waitZero(ctx, &pipeline.pending)
+}
+
+// WaitForScheduled blocks until every task enqueued with a delay, such as by SubmitAfter or a retry backoff, has been
+// dequeued and completed, or returns the error of ctx if it is done first. Delayed tasks scheduled while waiting are waited
+// for as well. Unlike WaitIdle, it does not wait for immediate submissions, which makes tests of delayed tasks deterministic
+// without sleeping. Like WaitIdle, it returns ErrorQueueClosed once the pipeline is stopped. It does not stop the pipeline or
+// reject new submissions
+// WaitForScheduled 阻塞直到所有延迟入队的任务（例如通过 SubmitAfter 提交或因重试退避而延迟的任务）都已被取出并完成，如果 ctx 先结束则返回
+// ctx 的错误。等待期间安排的延迟任务同样会被等待。与 WaitIdle 不同，它不等待立即提交的任务，使延迟任务的测试无需休眠即可确定。
+// 与 WaitIdle 一样，管道停止后返回 ErrorQueueClosed。它不会停止管道，也不会拒绝新的提交
+func (pipeline *Pipeline) WaitForScheduled(ctx context.Context) error {
+	return pipeline.waitZero(ctx, &pipeline.scheduled)
+}
+
+// waitZero polls counter until it drops to zero, returning the error of ctx if it is done first, or ErrorQueueClosed
+// if the pipeline is stopped
+// waitZero 轮询 counter 直到其降为零，如果 ctx 先结束则返回 ctx 的错误，如果管道已停止则返回 ErrorQueueClosed
+func (pipeline *Pipeline) waitZero(ctx context.Context, counter *atomic.Int64) error {
+	ticker := time.NewTicker(defaultDrainPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pipeline.stopped:
+			return ErrorQueueClosed
+		default:
+		}
+		if counter.Load() <= 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-pipeline.stopped:
+		case <-ticker.C:
+		}
+	}
+}
+
 // handleMessage 处理单个消息
 // handleMessage 处理单个消息
 func (pipeline *Pipeline) handleMessage(element *internal.ElementExt) {
@@ -105,30 +417,297 @@ func (pipeline *Pipeline) handleMessage(element *internal.ElementExt) {
 	// 获取消息数据
 	data := element.GetData()
 
-	// Execute callback before message processing
-	// 执行消息处理前的回调函数
-	pipeline.config.callback.OnBefore(data)
+	// Execute callback before message processing, only the first time the task runs so that retries and re-queues
+	// are paired with a single OnAfter, ordered callbacks are fired on completion
+	// 执行消息处理前的回调函数，仅在任务首次执行时调用，使重试和重新入队只对应一次 OnAfter，有序回调在完成时调用
+	if !element.IsStarted() {
+		element.SetStarted(true)
+		if pipeline.sequencer == nil {
+			pipeline.config.callback.OnBefore(data)
+		}
+	}
 
-	var (
-		result any
-		err    error
-	)
+	// Get the task context, tasks submitted without a context are never cancelled
+	// 获取任务上下文，未携带上下文提交的任务不会被取消
+	ctx := element.GetContext()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// Expose the task metadata to context-aware handlers
+	// 将任务元数据提供给可感知上下文的处理函数
+	if meta := element.GetMeta(); meta != nil {
+		ctx = context.WithValue(ctx, metaContextKey{}, meta)
+	}
+
+	// Make the task preemptible by registering it as in flight
+	// 将任务登记为处理中，使其可以被抢占
+	if pipeline.inflight != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = pipeline.track(ctx, element)
+		defer cancel()
+	}
+
+	var result any
+
+	// Skip the handler if the task was cancelled or its deadline passed before it started, otherwise use
+	// the custom handler function if exists, or the default handler
+	// 如果任务在开始前已被取消或已超过截止时间则跳过处理函数，否则优先使用自定义处理函数，没有则使用默认处理函数
+	err := ctx.Err()
+	if err == nil && element.IsExpired(pipeline.config.clock.Now()) {
+		err = ErrDeadlineExceeded
+	}
+	// Wait for the processing rate, giving up if the task is cancelled meanwhile
+	// 等待处理速率允许，期间任务被取消则放弃
+	if err == nil && pipeline.processLimit != nil {
+		err = pipeline.processLimit.Wait(ctx)
+	}
+	var duration time.Duration
+	if err == nil {
+		var start time.Time
+		if pipeline.measure {
+			start = pipeline.config.clock.Now()
+		}
+		result, err = callHandler(pipeline.config, ctx, pipeline.handlerOf(element), data)
+		if pipeline.measure {
+			duration = pipeline.config.clock.Now().Sub(start)
+		}
+		if pipeline.timing != nil {
+			pipeline.timing.add(duration)
+		}
+	}
+
+	// Stream partial results if the handler returned a channel and the callback wants chunks
+	// 如果处理函数返回了通道且回调函数需要部分结果，则逐个传递部分结果
+	if err == nil {
+		result = pipeline.streamChunks(data, result)
+	}
+
+	// Acknowledge the element now that the handler has returned, before it is re-queued or recycled
+	// 处理函数已返回，在元素重新入队或回收之前进行确认
+	if pipeline.config.ackMode == AckAfter {
+		pipeline.queue.Done(element)
+	}
+
+	// A preempted task either goes back to the queue or fails with ErrTaskPreempted
+	// 被抢占的任务要么重新入队，要么以 ErrTaskPreempted 失败
+	if pipeline.inflight != nil && pipeline.untrack(element) && err != nil {
+		if pipeline.config.preemptRequeue && pipeline.ctx.Err() == nil && pipeline.enqueue(element, immediateDelay) == nil {
+			return
+		}
+		result, err = nil, ErrTaskPreempted
+	}
 
-	// Check if there's a custom handler function, use it if exists, otherwise use default handler
-	// 判断是否有自定义处理函数，如果有则使用自定义函数，否则使用默认处理函数
-	if handleFunc := element.GetHandleFunc(); handleFunc != nil {
-		result, err = handleFunc(data)
+	// Re-queue the task after the delay the handler asked for, without counting it as a failed attempt
+	// 按处理函数请求的延迟重新入队，不计为失败的尝试
+	if delay, ok := retryAfterDelay(err); ok && pipeline.requeue(element, delay) {
+		return
+	}
+
+	// Count this attempt
+	// 记录本次尝试
+	element.SetAttempts(element.GetAttempts() + 1)
+
+	// Re-submit the failed task if it still has attempts left
+	// 如果失败的任务还有剩余尝试次数，则重新提交
+	if err != nil && pipeline.retry(element) {
+		return
+	}
+
+	// Execute callback after message processing, in submission order if ordered callbacks are enabled
+	// 执行消息处理后的回调函数，启用有序回调时按提交顺序执行
+	outcome := &taskOutcome{
+		msg:    data,
+		id:     element.GetID(),
+		result: result,
+		err:    err,
+		meta: TaskMeta{
+			Custom:   element.GetHandleFunc() != nil,
+			Duration: duration,
+			Attempts: element.GetAttempts(),
+			Headers:  element.GetMeta(),
+		},
+	}
+	if pipeline.sequencer != nil {
+		pipeline.sequencer.complete(element.GetValue(), outcome)
 	} else {
-		result, err = pipeline.config.handleFunc(data)
+		notifyOutcome(pipeline.config, outcome)
+	}
+
+	// Route the message to the dead-letter function once its last allowed attempt has failed
+	// 最后一次允许的尝试失败后，将消息交给死信函数
+	if err != nil && pipeline.config.deadLetter != nil && element.GetAttempts() >= pipeline.config.retryAttempts {
+		pipeline.config.deadLetter(data, err)
 	}
 
-	// Execute callback after message processing
-	// 执行消息处理后的回调函数
-	pipeline.config.callback.OnAfter(data, result, err)
+	// Collect the result keyed by its submission sequence
+	// 以提交序号为键收集结果
+	if pipeline.config.collector != nil {
+		pipeline.config.collector.add(element.GetValue(), TaskResult{Input: data, Output: result, Err: err})
+	}
+
+	// Send the result to the result channel if configured
+	// 如果配置了结果通道，则发送结果
+	if pipeline.config.resultChan != nil {
+		pipeline.sendResult(TaskResult{Input: data, Output: result, Err: err})
+	}
+
+	// Add the result to the batch flushed to the sink if configured
+	// 如果配置了 sink，则将结果加入输出到 sink 的批次
+	if pipeline.batcher != nil {
+		pipeline.batcher.add(TaskResult{Input: data, Output: result, Err: err})
+	}
+
+	// Deliver the outcome to the submitter if it is waiting for it
+	// 如果提交者正在等待结果，则将结果传递给提交者
+	if resultFunc := element.GetResultFunc(); resultFunc != nil {
+		resultFunc(result, err)
+	}
+
+	// Submit the items returned by the fan-out handler as new tasks, while this task still counts as pending so that
+	// draining waits for them too
+	// 将扇出处理函数返回的元素作为新任务提交，此时本任务仍计入待完成数量，使排空同样会等待这些新任务
+	if err == nil && pipeline.fanOutOn.Load() && element.GetHandleFunc() == nil && pipeline.config.ctxHandleFunc == nil {
+		if items, ok := result.([]any); ok {
+			pipeline.fanOut(items)
+		}
+	}
+
+	// Free the dedup key so that the message can be submitted again, sharing the outcome with coalesced submitters
+	// 释放去重键，使该消息可以再次提交，并将结果共享给合并进来的提交者
+	pipeline.release(data, result, err)
 
 	// Return the element to the pool
 	// 将元素放回对象池
+	pipeline.unschedule(element)
 	pipeline.elementPool.Put(element)
+
+	// Mark the task as completed
+	// 标记任务已完成
+	if err != nil {
+		pipeline.failed.Add(1)
+	}
+	pipeline.processed.Add(1)
+	pipeline.pending.Add(-1)
+}
+
+// fanOut submits every item as a new task handled by the fan-out handler, giving up once the pipeline is stopped.
+// Items that cannot be submitted are logged as warnings
+// fanOut 将每个元素作为由扇出处理函数处理的新任务提交，管道停止后放弃。无法提交的元素会作为警告日志输出
+func (pipeline *Pipeline) fanOut(items []any) {
+	admitted := 0
+	for _, item := range items {
+		if pipeline.ctx.Err() != nil || pipeline.queue.IsClosed() {
+			pipeline.config.logger.Warnf("%s: fan-out on stopped pipeline, %d items dropped", pipeline.config.logPrefix(), len(items)-admitted)
+			break
+		}
+		if err := pipeline.admit(nil, item, immediateDelay, nil); err != nil {
+			pipeline.config.logger.Warnf("%s: fan-out item dropped, message: %v, error: %v", pipeline.config.logPrefix(), item, err)
+			continue
+		}
+		admitted++
+	}
+
+	// Try to create a worker once for the whole fan-out
+	// 整个扇出只尝试创建一次工作协程
+	if admitted > 0 {
+		pipeline.tryCreateExecutor(1)
+	}
+}
+
+// sendResult sends the result to the result channel, dropping it when the channel is full unless blocking is enabled.
+// A blocked send gives up once the pipeline is stopped.
+// sendResult 将结果发送到结果通道，除非启用了阻塞，否则通道已满时丢弃结果。阻塞的发送在管道停止后放弃。
+func (pipeline *Pipeline) sendResult(result TaskResult) {
+	if pipeline.config.resultChanBlock {
+		select {
+		case pipeline.config.resultChan <- result:
+		case <-pipeline.ctx.Done():
+		}
+		return
+	}
+
+	select {
+	case pipeline.config.resultChan <- result:
+	default:
+	}
+}
+
+// handlerOf returns the handler function of the element, or the current default handler function if the element has none
+// and no context-aware handler function is configured
+// handlerOf 返回元素的处理函数，如果元素没有处理函数且未配置可感知上下文的处理函数，则返回当前的默认处理函数
+func (pipeline *Pipeline) handlerOf(element *internal.ElementExt) MessageHandleFunc {
+	if fn := element.GetHandleFunc(); fn != nil || pipeline.config.ctxHandleFunc != nil {
+		return fn
+	}
+	return *pipeline.handleFunc.Load()
+}
+
+// hasHandler reports whether a message submitted with fn has a handler to run, which is only false in the strict handler mode
+// hasHandler 检查使用 fn 提交的消息是否有可执行的处理函数，只有在严格处理函数模式下才可能为 false
+func (pipeline *Pipeline) hasHandler(fn MessageHandleFunc) bool {
+	return fn != nil || pipeline.config.ctxHandleFunc != nil || *pipeline.handleFunc.Load() != nil
+}
+
+// streamChunks delivers every value of a chunk channel to the ChunkCallback until the channel is closed.
+// It returns nil once the channel is drained, or the result unchanged if it is not streamed.
+// streamChunks 将部分结果通道中的每个值传递给 ChunkCallback，直到通道关闭。
+// 通道读取完毕后返回 nil，如果结果无需流式传递则原样返回。
+func (pipeline *Pipeline) streamChunks(data, result any) any {
+	chunks, ok := result.(<-chan any)
+	if !ok {
+		return result
+	}
+	callback, ok := pipeline.config.callback.(ChunkCallback)
+	if !ok {
+		return result
+	}
+
+	// The worker stays busy until the channel is closed
+	// 工作协程在通道关闭前一直处于忙碌状态
+	for chunk := range chunks {
+		callback.OnChunk(data, chunk)
+	}
+
+	return nil
+}
+
+// retry re-submits a failed element after the configured backoff, it returns false if no retry was scheduled
+// retry 在配置的退避时间后重新提交失败的元素，如果没有安排重试则返回 false
+func (pipeline *Pipeline) retry(element *internal.ElementExt) bool {
+	// No retry once attempts are exhausted or the pipeline is stopping
+	// 尝试次数耗尽或管道正在停止时不再重试
+	if element.GetAttempts() >= pipeline.config.retryAttempts || pipeline.ctx.Err() != nil {
+		return false
+	}
+
+	// No retry once the task itself has been cancelled or its deadline has passed
+	// 任务本身已被取消或已超过截止时间时不再重试
+	if ctx := element.GetContext(); (ctx != nil && ctx.Err() != nil) || element.IsExpired(pipeline.config.clock.Now()) {
+		return false
+	}
+
+	return pipeline.enqueue(element, pipeline.config.retryBackoff.Milliseconds()) == nil
+}
+
+// requeue re-submits an element whose handler returned ErrRetryAfter after the requested delay, it returns false if
+// the element was not re-queued, in which case the error is handled like any other failure
+// requeue 在请求的延迟后重新提交处理函数返回了 ErrRetryAfter 的元素，如果元素没有重新入队则返回 false，此时该错误按普通失败处理
+func (pipeline *Pipeline) requeue(element *internal.ElementExt, delay time.Duration) bool {
+	// Guard against handlers that keep asking to be retried
+	// 防止处理函数无休止地请求重试
+	if element.GetRequeues() >= maxRetryAfterRequeues || pipeline.ctx.Err() != nil {
+		return false
+	}
+
+	// No re-queue once the task itself has been cancelled or its deadline has passed
+	// 任务本身已被取消或已超过截止时间时不再重新入队
+	if ctx := element.GetContext(); (ctx != nil && ctx.Err() != nil) || element.IsExpired(pipeline.config.clock.Now()) {
+		return false
+	}
+
+	element.SetRequeues(element.GetRequeues() + 1)
+	return pipeline.enqueue(element, delay.Milliseconds()) == nil
 }
 
 // executor 执行器，负责处理队列中的消息
@@ -140,71 +719,150 @@ func (pipeline *Pipeline) executor() {
 
 	// Create state scan ticker
 	// 创建状态扫描定时器
-	stateScanTicker := time.NewTicker(defaultWorkerScanInterval)
+	stateScanTicker := pipeline.config.clock.NewTicker(defaultWorkerScanInterval)
+
+	// Poll an empty queue again at the poll interval only in the polling mode, a nil channel never fires
+	// 仅在轮询模式下按轮询间隔重新获取空队列，nil 通道永远不会触发
+	var pollC <-chan time.Time
+	if pipeline.config.getMode == GetPolling {
+		pollTicker := pipeline.config.clock.NewTicker(pipeline.config.getPollInterval)
+		defer pollTicker.Stop()
+		pollC = pollTicker.C()
+	}
+
+	// Notify the callback that the worker has started
+	// 通知回调函数工作协程已启动
+	callback, watched := pipeline.config.callback.(WorkerCallback)
+	if watched {
+		callback.OnWorkerStart(pipeline.runningCount.Load())
+	}
 
 	// Ensure resource cleanup and counter update
 	// 确保资源清理和计数更新
 	defer func() {
-		pipeline.runningCount.Add(-1)
+		running := pipeline.runningCount.Add(-1)
+		if watched {
+			callback.OnWorkerStop(running)
+		}
+		globalWorkers.release()
+		pipeline.notifyFreed()
 		pipeline.wg.Done()
 		stateScanTicker.Stop()
 	}()
 
-	// Continue processing queue messages until queue is closed
-	// 持续处理队列消息，直到队列关闭
-	for !pipeline.queue.IsClosed() {
+	// Continue processing queue messages until queue is closed, or the remaining tasks are about to be collected
+	// 持续处理队列消息，直到队列关闭或剩余的任务即将被收集
+	for !pipeline.queue.IsClosed() && !pipeline.collecting.Load() {
 		// Get element from queue
 		// 从队列获取元素
 		element, err := pipeline.queue.Get()
 		if err != nil {
+			// Block until the next scan, or the next poll in the polling mode, so an empty queue does not spin the worker
+			// 阻塞到下一次扫描，轮询模式下则阻塞到下一次轮询，避免空队列时工作协程空转
 			select {
 			// Check if need to exit
 			// 检查是否需要退出
 			case <-pipeline.ctx.Done():
 				return
+			// Get again in the polling mode
+			// 轮询模式下再次获取
+			case <-pollC:
 			// Check worker goroutine status
 			// 检查工作协程状态
-			case <-stateScanTicker.C:
+			case <-stateScanTicker.C():
 				// Exit if idle time exceeds threshold and running workers count is greater than minimum
 				// 如果空闲时间超过阈值且运行的工作协程数量大于最小值，则退出
-				if pipeline.timer.Load()-lastUpdateTime >= defaultWorkerIdleTimeout &&
+				if !pipeline.config.noIdleReaping && pipeline.timer.Load()-lastUpdateTime >= defaultWorkerIdleTimeout &&
 					pipeline.runningCount.Load() > defaultMinWorkerCount {
+					pipeline.config.logger.Debugf("%s: worker reaped after idle timeout, running: %d", pipeline.config.logPrefix(), pipeline.runningCount.Load()-1)
+					return
+				}
+				// Exit if running workers exceed the ceiling lowered by SetMaxWorkers
+				// 如果运行的工作协程数量超过被 SetMaxWorkers 降低的上限，则退出
+				if pipeline.runningCount.Load() > pipeline.maxWorkers.Load() {
+					pipeline.config.logger.Debugf("%s: surplus worker reaped, running: %d", pipeline.config.logPrefix(), pipeline.runningCount.Load()-1)
+					return
+				}
+				// Exit if the global worker limit is reached and this pipeline runs more than its fair share
+				// 如果达到全局工作协程限制且该管道运行的数量超过其公平份额，则退出
+				if globalWorkers.surplus(pipeline.runningCount.Load()) {
+					pipeline.config.logger.Debugf("%s: worker reaped over global fair share, running: %d", pipeline.config.logPrefix(), pipeline.runningCount.Load()-1)
 					return
 				}
 			}
 			continue
 		}
+		pipeline.queued.Add(-1)
 
-		// Mark element as done
-		// 标记元素已处理
-		pipeline.queue.Done(element)
+		// Mark element as done before handling unless acknowledging after handling
+		// 除非在处理之后确认，否则在处理之前标记元素已处理
+		if pipeline.config.ackMode == AckBefore {
+			pipeline.queue.Done(element)
+		}
 		// Process the message
 		// 处理消息
+		pipeline.inflightNum.Add(1)
 		pipeline.handleMessage(element.(*internal.ElementExt))
+		pipeline.inflightNum.Add(-1)
+		pipeline.notifyFreed()
 		// Update last processing time
 		// 更新最后处理时间
 		lastUpdateTime = pipeline.timer.Load()
 	}
 }
 
-// submit 提交消息到管道
-// submit 提交消息到管道
-func (pipeline *Pipeline) submit(handleFunc MessageHandleFunc, message any, delay int64) error {
-	// Check if queue is closed
-	// 检查队列是否已关闭
-	if pipeline.queue.IsClosed() {
-		return ErrorQueueClosed
+// busy reports whether every worker allowed by the ceiling is running and has a task assigned
+// busy 检查上限允许的工作协程是否都在运行并且都已分配了任务
+func (pipeline *Pipeline) busy() bool {
+	running := pipeline.runningCount.Load()
+	return running >= pipeline.maxWorkers.Load() && pipeline.inflightNum.Load()+pipeline.queued.Load() >= running
+}
+
+// notifyFreed wakes up the submissions waiting in SubmitWait, it does nothing if no one is waiting
+// notifyFreed 唤醒在 SubmitWait 中等待的提交，没有等待者时什么都不做
+func (pipeline *Pipeline) notifyFreed() {
+	if pipeline.waiters.Load() == 0 {
+		return
 	}
 
-	// Get element from object pool
-	// 从对象池获取元素
-	element := pipeline.elementPool.Get()
-	// Set message data and handler function
-	// 设置消息数据和处理函数
-	element.SetData(message)
-	element.SetHandleFunc(handleFunc)
+	pipeline.waitLock.Lock()
+	if pipeline.freed != nil {
+		close(pipeline.freed)
+		pipeline.freed = nil
+	}
+	pipeline.waitLock.Unlock()
+}
+
+// freedChan returns the channel closed the next time a worker is freed
+// freedChan 返回下一次工作协程空闲时关闭的通道
+func (pipeline *Pipeline) freedChan() <-chan struct{} {
+	pipeline.waitLock.Lock()
+	defer pipeline.waitLock.Unlock()
+
+	if pipeline.freed == nil {
+		pipeline.freed = make(chan struct{})
+	}
+	return pipeline.freed
+}
+
+// enqueue 将元素放入队列
+// enqueue puts the element into the queue
+func (pipeline *Pipeline) enqueue(element *internal.ElementExt, delay int64) error {
+	pipeline.enqueueLock.RLock()
+	defer pipeline.enqueueLock.RUnlock()
+
+	// Count the element as queued before it becomes visible to workers, so a worker never dequeues it uncounted,
+	// and as scheduled until it completes if it is delayed for the first time
+	// 在元素对工作协程可见之前将其计入排队数量，使工作协程不会取出未计数的元素，如果是首次延迟入队，则在其完成之前计入已安排的数量
+	pipeline.queued.Add(1)
+	scheduled := delay > 0 && !element.IsScheduled()
+	if scheduled {
+		element.SetScheduled(true)
+		pipeline.scheduled.Add(1)
+	}
 
 	var err error
+
 	// Choose submission method based on delay time
 	// 根据延迟时间选择提交方式
 	if delay > 0 {
@@ -217,24 +875,173 @@ func (pipeline *Pipeline) submit(handleFunc MessageHandleFunc, message any, dela
 		err = pipeline.queue.Put(element)
 	}
 
-	// If submission fails, return element to pool
-	// 如果提交失败，返回元素到对象池
+	// Undo the counts if the element did not make it into the queue
+	// 如果元素未能放入队列，则撤销计数
 	if err != nil {
-		pipeline.elementPool.Put(element)
+		pipeline.queued.Add(-1)
+		if scheduled {
+			element.SetScheduled(false)
+			pipeline.scheduled.Add(-1)
+		}
+	}
+
+	return err
+}
+
+// unschedule stops counting a completed element as scheduled if it was enqueued with a delay
+// unschedule 如果已完成的元素曾被延迟入队，则不再将其计入已安排的数量
+func (pipeline *Pipeline) unschedule(element *internal.ElementExt) {
+	if element.IsScheduled() {
+		pipeline.scheduled.Add(-1)
+	}
+}
+
+// submit 提交消息到管道，setup 不为 nil 时用于在入队前设置元素的其他属性
+// submit submits a message to the pipeline, setup is used to set other element attributes before enqueueing if not nil
+func (pipeline *Pipeline) submit(handleFunc MessageHandleFunc, message any, delay int64, setup func(element *internal.ElementExt)) error {
+	if err := pipeline.put(handleFunc, message, delay, setup); err != nil {
 		return err
 	}
 
 	// Try to create new executor if possible
 	// 如果可能，尝试创建新的执行器
-	pipeline.tryCreateExecutor()
+	pipeline.tryCreateExecutor(pipeline.weightOf(message))
 
 	return nil
 }
 
+// put 将消息封装为元素放入队列，但不会创建新的执行器
+// put wraps the message into an element and puts it into the queue, without creating new executors
+func (pipeline *Pipeline) put(handleFunc MessageHandleFunc, message any, delay int64, setup func(element *internal.ElementExt)) error {
+	// Check if queue is closed or the pipeline is stopping
+	// 检查队列是否已关闭或管道是否正在停止
+	if pipeline.closing.Load() || pipeline.queue.IsClosed() {
+		pipeline.config.logger.Warnf("%s: submit on closed pipeline, message: %v", pipeline.config.logPrefix(), message)
+		return ErrorQueueClosed
+	}
+
+	return pipeline.admit(handleFunc, message, delay, setup)
+}
+
+// admit wraps the message into an element and puts it into the queue like put, but also while the pipeline is draining,
+// which lets tasks produced by running tasks complete before the pipeline stops
+// admit 与 put 一样将消息封装为元素放入队列，但在管道排空期间同样可以放入，使正在运行的任务产生的任务能在管道停止前完成
+func (pipeline *Pipeline) admit(handleFunc MessageHandleFunc, message any, delay int64, setup func(element *internal.ElementExt)) error {
+	// Reject the message up front if there is no handler to run it
+	// 如果没有可执行的处理函数，则直接拒绝消息
+	if !pipeline.hasHandler(handleFunc) {
+		return ErrNoHandler
+	}
+
+	// Get element from object pool
+	// 从对象池获取元素
+	element := pipeline.elementPool.Get()
+	// Set message data and handler function
+	// 设置消息数据和处理函数
+	element.SetData(message)
+	element.SetHandleFunc(handleFunc)
+	// Record the submission sequence
+	// 记录提交序号
+	element.SetValue(pipeline.sequence.Add(1))
+	if setup != nil {
+		setup(element)
+	}
+
+	// Reject or coalesce a message whose key is already queued or being handled
+	// 拒绝或合并键已在排队或处理中的消息
+	if pipeline.dedupKeys != nil && !pipeline.reserve(element) {
+		pipeline.skipSequence(element)
+		return pipeline.duplicate(element)
+	}
+
+	// Count the task as pending before it becomes visible to workers, rejecting it if the limit is exceeded
+	// 在任务对工作协程可见之前将其计入待完成数量，超过上限则拒绝
+	if pending := pipeline.pending.Add(1); pipeline.config.maxPending > 0 && pending > pipeline.config.maxPending {
+		pipeline.pending.Add(-1)
+		pipeline.release(message, nil, ErrQueueFull)
+		pipeline.skipSequence(element)
+		pipeline.elementPool.Put(element)
+		return ErrQueueFull
+	}
+
+	// If submission fails, return element to pool
+	// 如果提交失败，返回元素到对象池
+	if err := pipeline.enqueue(element, delay); err != nil {
+		pipeline.pending.Add(-1)
+		pipeline.release(message, nil, err)
+		pipeline.skipSequence(element)
+		pipeline.elementPool.Put(element)
+
+		// Notify the callback that the message has been dropped
+		// 通知回调函数消息已被丢弃
+		if callback, ok := pipeline.config.callback.(DropCallback); ok {
+			callback.OnDrop(message, err)
+		}
+		return err
+	}
+
+	// Notify the callback that the task has been accepted, a worker may already be handling it
+	// 通知回调函数任务已被接收，此时工作协程可能已经在处理该任务
+	if callback, ok := pipeline.config.callback.(SubmitCallback); ok {
+		callback.OnSubmit(message)
+	}
+
+	return nil
+}
+
+// skipSequence releases the submission sequence of an element that is not enqueued, so ordered callbacks do not wait for it
+// skipSequence 释放未入队元素的提交序号，使有序回调不会等待它
+func (pipeline *Pipeline) skipSequence(element *internal.ElementExt) {
+	if pipeline.sequencer != nil {
+		pipeline.sequencer.skip(element.GetValue())
+	}
+}
+
+// submitBatch 在一次遍历中提交一批消息，并在最后尝试创建一次执行器。failFast 为 true 时在第一个失败的消息处停止
+// submitBatch submits a batch of messages in one pass and tries to create an executor once at the end. It stops at the
+// first failed message if failFast is true
+func (pipeline *Pipeline) submitBatch(msgs []any, delay int64, failFast bool) (int, error) {
+	var (
+		submitted int
+		weight    = 1
+		firstErr  error
+	)
+
+	for _, msg := range msgs {
+		if err := pipeline.put(nil, msg, delay, nil); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+
+			// Stop right away on failure in fail-fast mode, or once the pipeline no longer accepts submissions
+			// 快速失败模式下失败时立即停止，管道不再接收任务时也立即停止
+			if failFast || err == ErrorQueueClosed || pipeline.queue.IsClosed() {
+				break
+			}
+			continue
+		}
+		submitted++
+
+		// The batch weighs as much as its heaviest message
+		// 整批任务的权重等于其中最重的消息的权重
+		if w := pipeline.weightOf(msg); w > weight {
+			weight = w
+		}
+	}
+
+	// Try to create new executor once for the whole batch
+	// 整批任务只尝试创建一次执行器
+	if submitted > 0 {
+		pipeline.tryCreateExecutor(weight)
+	}
+
+	return submitted, firstErr
+}
+
 // SubmitWithFunc submits a message with a custom handler function
 // SubmitWithFunc 使用自定义处理函数提交消息
 func (pipeline *Pipeline) SubmitWithFunc(fn MessageHandleFunc, msg any) error {
-	return pipeline.submit(fn, msg, immediateDelay)
+	return pipeline.submit(fn, msg, immediateDelay, nil)
 }
 
 // Submit submits a message using the default handler function
@@ -246,7 +1053,7 @@ func (pipeline *Pipeline) Submit(msg any) error {
 // SubmitAfterWithFunc submits a message with delay using a custom handler function
 // SubmitAfterWithFunc 延迟提交消息并使用自定义处理函数
 func (pipeline *Pipeline) SubmitAfterWithFunc(fn MessageHandleFunc, msg any, delay time.Duration) error {
-	return pipeline.submit(fn, msg, delay.Milliseconds())
+	return pipeline.submit(fn, msg, delay.Milliseconds(), nil)
 }
 
 // SubmitAfter submits a message with delay using the default handler function
@@ -255,48 +1062,472 @@ func (pipeline *Pipeline) SubmitAfter(msg any, delay time.Duration) error {
 	return pipeline.SubmitAfterWithFunc(nil, msg, delay)
 }
 
+// SubmitAfterJitter submits a message using the default handler function with a delay picked uniformly in [base, base+jitter),
+// so that many tasks scheduled together do not all fire at once. The delay is picked from the source set by WithJitterSource,
+// or the math/rand global source by default. A jitter less than or equal to 0 delays the message by base exactly
+// SubmitAfterJitter 使用默认处理函数提交消息，延迟在 [base, base+jitter) 范围内均匀选取，避免大量同时安排的任务在同一时刻触发。
+// 延迟从 WithJitterSource 设置的随机源中选取，默认使用 math/rand 的全局随机源。jitter 小于等于 0 时消息的延迟恰好为 base
+func (pipeline *Pipeline) SubmitAfterJitter(msg any, base, jitter time.Duration) error {
+	return pipeline.SubmitAfter(msg, base+pipeline.jitter(jitter))
+}
+
+// jitter returns a random duration in [0, d), or 0 if d is not positive
+// jitter 返回 [0, d) 范围内的随机时长，d 不是正数时返回 0
+func (pipeline *Pipeline) jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	if pipeline.config.jitterSource != nil {
+		return time.Duration(pipeline.config.jitterSource(int64(d)))
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// SubmitBlocking submits a message using the default handler function, waiting while the pipeline is full
+// until capacity is available. It returns ErrorQueueClosed if the pipeline is stopped while waiting
+// SubmitBlocking 使用默认处理函数提交消息，管道已满时等待直到有空余容量。如果等待期间管道被停止则返回 ErrorQueueClosed
+func (pipeline *Pipeline) SubmitBlocking(msg any) error {
+	return pipeline.submitBlocking(context.Background(), msg, nil)
+}
+
+// SubmitContext submits a message using the default handler function, waiting while the pipeline is full like
+// SubmitBlocking. It gives up with the ctx error if ctx is done before the message is enqueued. Once enqueued, the task
+// carries ctx: it is skipped if ctx is done before it starts, and context-aware handlers receive ctx
+// SubmitContext 使用默认处理函数提交消息，管道已满时像 SubmitBlocking 一样等待。如果消息入队前 ctx 结束，则放弃提交并返回 ctx 的错误。
+// 入队后任务携带 ctx：如果 ctx 在任务开始前结束则跳过该任务，可感知上下文的处理函数会收到 ctx
+func (pipeline *Pipeline) SubmitContext(ctx context.Context, msg any) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return pipeline.submitBlocking(ctx, msg, func(element *internal.ElementExt) {
+		element.SetContext(ctx)
+	})
+}
+
+// submitBlocking submits a message like SubmitBlocking, returning the ctx error if ctx is done while waiting.
+// setup, if not nil, is applied to the element before it is enqueued
+// submitBlocking 与 SubmitBlocking 一样提交消息，如果等待期间 ctx 结束则返回 ctx 的错误。setup 不为 nil 时在元素入队前对其调用
+func (pipeline *Pipeline) submitBlocking(ctx context.Context, msg any, setup func(element *internal.ElementExt)) error {
+	ticker := time.NewTicker(defaultBlockingPollInterval)
+	defer ticker.Stop()
+	for {
+		if err := pipeline.submit(nil, msg, immediateDelay, setup); err != ErrQueueFull {
+			return err
+		}
+
+		// Wait for capacity, ctx or the pipeline to stop
+		// 等待空余容量、ctx 结束或管道停止
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-pipeline.ctx.Done():
+			return ErrorQueueClosed
+		case <-ticker.C:
+		}
+	}
+}
+
+// Consume reads messages from ch and submits each of them using the default handler function until ch is closed or
+// ctx is done, turning the pipeline into a sink for channel based producers. It waits while the pipeline is full like
+// SubmitBlocking, and keeps consuming after a failed submission, returning the first submit error once ch is closed.
+// It stops early with ErrorQueueClosed once the pipeline is stopped, or with the ctx error if no submission failed before.
+// Consume 从 ch 读取消息，并使用默认处理函数逐个提交，直到 ch 关闭或 ctx 结束，使管道成为基于通道的生产者的接收端。
+// 管道已满时像 SubmitBlocking 一样等待，提交失败后继续读取，并在 ch 关闭后返回第一个提交错误。
+// 管道停止后以 ErrorQueueClosed 提前结束，ctx 结束时如果之前没有提交失败则返回 ctx 的错误。
+func (pipeline *Pipeline) Consume(ctx context.Context, ch <-chan any) error {
+	var firstErr error
+	for {
+		select {
+		case <-ctx.Done():
+			if firstErr == nil {
+				firstErr = ctx.Err()
+			}
+			return firstErr
+
+		case <-pipeline.ctx.Done():
+			if firstErr == nil {
+				firstErr = ErrorQueueClosed
+			}
+			return firstErr
+
+		case msg, ok := <-ch:
+			if !ok {
+				return firstErr
+			}
+
+			err := pipeline.submitBlocking(ctx, msg, nil)
+			if err == nil {
+				continue
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			// Nothing more can be submitted once the pipeline is stopped or ctx is done
+			// 管道停止或 ctx 结束后无法再提交任何消息
+			if err == ErrorQueueClosed || ctx.Err() != nil {
+				return firstErr
+			}
+		}
+	}
+}
+
+// SubmitWait submits a message using the default handler function, waiting while the worker count is at the ceiling and
+// every worker is busy, so submissions are paced by worker availability rather than by the queue size. It returns the
+// ctx error if ctx is done first, or ErrorQueueClosed if the pipeline is stopped while waiting
+// SubmitWait 使用默认处理函数提交消息，在工作协程数量达到上限且所有工作协程都在忙碌时等待，使提交速度取决于工作协程是否空闲，
+// 而不是队列的大小。如果 ctx 先结束则返回 ctx 的错误，如果等待期间管道被停止则返回 ErrorQueueClosed
+func (pipeline *Pipeline) SubmitWait(ctx context.Context, msg any) error {
+	pipeline.waiters.Add(1)
+	defer pipeline.waiters.Add(-1)
+
+	for {
+		// Take the channel before checking, so a worker freed in between is not missed
+		// 在检查之前获取通道，避免错过在此期间空闲的工作协程
+		freed := pipeline.freedChan()
+		if !pipeline.busy() {
+			return pipeline.Submit(msg)
+		}
+
+		// Wait for a free worker, ctx or the pipeline to stop
+		// 等待空闲的工作协程、ctx 结束或管道停止
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-pipeline.ctx.Done():
+			return ErrorQueueClosed
+		}
+	}
+}
+
+// SubmitBatch submits a batch of messages using the default handler function in one pass. Failed messages are
+// skipped, and it returns how many were enqueued along with the first error. It stops early if the pipeline is closed.
+// SubmitBatch 在一次遍历中使用默认处理函数提交一批消息。失败的消息会被跳过，返回成功入队的数量和第一个错误。如果管道已关闭则提前停止。
+func (pipeline *Pipeline) SubmitBatch(msgs []any) (submitted int, err error) {
+	return pipeline.submitBatch(msgs, immediateDelay, false)
+}
+
+// SubmitAll submits messages in order using the default handler function, stopping at the first one that fails to
+// enqueue. It returns the number of messages enqueued, which is also the index of the failed message, along with its error.
+// Messages enqueued before the failure are not withdrawn. It tries to create a worker once at the end
+// SubmitAll 使用默认处理函数按顺序提交消息，在第一个入队失败的消息处停止。返回已入队的消息数量（即失败消息的索引）以及其错误。
+// 失败之前已入队的消息不会被撤回。在最后只尝试创建一次工作协程
+func (pipeline *Pipeline) SubmitAll(msgs []any) (int, error) {
+	return pipeline.submitBatch(msgs, immediateDelay, true)
+}
+
+// SubmitBatchAfter submits a batch of messages with delay using the default handler function, like SubmitBatch
+// SubmitBatchAfter 与 SubmitBatch 一样，使用默认处理函数延迟提交一批消息
+func (pipeline *Pipeline) SubmitBatchAfter(msgs []any, delay time.Duration) (submitted int, err error) {
+	return pipeline.submitBatch(msgs, delay.Milliseconds(), false)
+}
+
+// SubmitFuture submits a message using the default handler function and returns a future of its result,
+// so that the caller can correlate the submission with its outcome through Future.Get. If the pipeline is stopped
+// before the task runs, the future is resolved with ErrorQueueClosed instead of being left pending
+// SubmitFuture 使用默认处理函数提交消息，并返回其结果的 Future，调用者可以通过 Future.Get 获取该任务的结果。
+// 如果任务执行前管道被停止，Future 会以 ErrorQueueClosed 完成，而不会一直处于等待状态
+func (pipeline *Pipeline) SubmitFuture(msg any) (*Future, error) {
+	return pipeline.SubmitFutureContext(context.Background(), msg)
+}
+
+// SubmitFutureContext submits a message using the default handler function and returns a future of its result.
+// The task carries ctx: it is skipped if ctx is done before it starts, context-aware handlers receive ctx,
+// and the future's Get returns once the task completes or ctx is done.
+// SubmitFutureContext 使用默认处理函数提交消息，并返回其结果的 Future。
+// 任务携带 ctx：如果 ctx 在任务开始前结束则跳过该任务，可感知上下文的处理函数会收到 ctx，Future 的 Get 在任务完成或 ctx 结束时返回。
+func (pipeline *Pipeline) SubmitFutureContext(ctx context.Context, msg any) (*Future, error) {
+	return pipeline.submitFuture(ctx, msg, immediateDelay)
+}
+
+// SubmitAfterFuture submits a message with delay using the default handler function and returns a future of its result,
+// so the caller can schedule a task and await its outcome later. If the pipeline is stopped before the task runs, the future
+// is resolved with ErrorQueueClosed instead of being left pending
+// SubmitAfterFuture 使用默认处理函数延迟提交消息，并返回其结果的 Future，调用者可以先安排任务，之后再等待其结果。
+// 如果任务执行前管道被停止，Future 会以 ErrorQueueClosed 完成，而不会一直处于等待状态
+func (pipeline *Pipeline) SubmitAfterFuture(msg any, delay time.Duration) (*Future, error) {
+	return pipeline.submitFuture(context.Background(), msg, delay.Milliseconds())
+}
+
+// submitFuture submits a message carrying ctx with delay and returns a future of its result. The future is tracked until
+// the task completes, so it is resolved with ErrorQueueClosed if the task is left behind when the pipeline stops
+// submitFuture 延迟提交携带 ctx 的消息，并返回其结果的 Future。Future 在任务完成之前一直被跟踪，因此如果管道停止时任务被留下，
+// 它会以 ErrorQueueClosed 完成
+func (pipeline *Pipeline) submitFuture(ctx context.Context, msg any, delay int64) (*Future, error) {
+	future := newFuture(ctx)
+
+	pipeline.futureLock.Lock()
+	pipeline.futures[future] = struct{}{}
+	pipeline.futureLock.Unlock()
+
+	err := pipeline.submit(nil, msg, delay, func(element *internal.ElementExt) {
+		element.SetContext(ctx)
+		element.SetResultFunc(func(result any, err error) {
+			pipeline.untrackFuture(future)
+			future.resolve(result, err)
+		})
+	})
+	if err != nil {
+		pipeline.untrackFuture(future)
+		return nil, err
+	}
+
+	return future, nil
+}
+
+// untrackFuture stops tracking a future whose task has completed or failed to be submitted
+// untrackFuture 停止跟踪任务已完成或提交失败的 Future
+func (pipeline *Pipeline) untrackFuture(future *Future) {
+	pipeline.futureLock.Lock()
+	delete(pipeline.futures, future)
+	pipeline.futureLock.Unlock()
+}
+
+// SubmitWithDeadline submits a message using the default handler function that must start before deadline.
+// If the deadline has passed when a worker picks the task up, the handler is skipped and OnAfter receives ErrDeadlineExceeded
+// SubmitWithDeadline 使用默认处理函数提交必须在 deadline 之前开始处理的消息。
+// 如果工作协程获取任务时已超过截止时间，则跳过处理函数，OnAfter 收到 ErrDeadlineExceeded
+func (pipeline *Pipeline) SubmitWithDeadline(msg any, deadline time.Time) error {
+	return pipeline.submit(nil, msg, immediateDelay, func(element *internal.ElementExt) {
+		element.SetDeadline(deadline)
+	})
+}
+
+// SubmitWithID submits a message using the default handler function along with a caller-supplied ID. Once the task completes,
+// a callback implementing IDCallback receives the ID in OnAfterID after OnAfter, so submissions can be correlated with their
+// outcome without wrapping the message. An empty ID is not delivered
+// SubmitWithID 使用默认处理函数提交消息，并附带调用者提供的 ID。任务完成后，实现了 IDCallback 的回调函数会在 OnAfter 之后通过 OnAfterID 收到该 ID，
+// 从而无需包装消息就能关联提交和处理结果。空 ID 不会被传递
+func (pipeline *Pipeline) SubmitWithID(id string, msg any) error {
+	return pipeline.submit(nil, msg, immediateDelay, func(element *internal.ElementExt) {
+		element.SetID(id)
+	})
+}
+
+// SubmitWithMeta submits a message using the default handler function along with metadata, such as headers, that the
+// handler and callbacks can read without it being encoded in the message. Context-aware handlers read it with
+// MetaFromContext, and a callback implementing MetaCallback receives it in TaskMeta.Headers. The map is shared with the
+// task, so it must not be modified after submission
+// SubmitWithMeta 使用默认处理函数提交消息，并附带元数据（例如消息头），处理函数和回调函数无需将其编码到消息中即可读取。
+// 可感知上下文的处理函数通过 MetaFromContext 读取，实现了 MetaCallback 的回调函数通过 TaskMeta.Headers 收到。该映射与任务共享，提交后不能再修改
+func (pipeline *Pipeline) SubmitWithMeta(msg any, meta map[string]any) error {
+	return pipeline.submit(nil, msg, immediateDelay, func(element *internal.ElementExt) {
+		element.SetMeta(meta)
+	})
+}
+
+// SubmitWithResultChan submits a message with a handler function, nil uses the default one, and sends the TaskResult of
+// just this submission to resultCh exactly once when it completes. The send gives up if the pipeline is stopped, so an
+// abandoned unbuffered channel never blocks a worker past Stop
+// SubmitWithResultChan 使用处理函数提交消息，nil 表示使用默认处理函数，任务完成时仅将本次提交的 TaskResult 发送到 resultCh 一次。
+// 管道停止时放弃发送，因此被放弃的无缓冲通道不会在 Stop 之后继续阻塞工作协程
+func (pipeline *Pipeline) SubmitWithResultChan(fn MessageHandleFunc, msg any, resultCh chan<- TaskResult) error {
+	return pipeline.submit(fn, msg, immediateDelay, func(element *internal.ElementExt) {
+		element.SetResultFunc(func(result any, err error) {
+			select {
+			case resultCh <- TaskResult{Input: msg, Output: result, Err: err}:
+			case <-pipeline.ctx.Done():
+			}
+		})
+	})
+}
+
+// SubmitWithPriority submits a message with the given priority using the default handler function.
+// Higher priorities are handled first only when the pipeline uses a PriorityQueue, on a plain FIFO queue the priority
+// does not change the order. With WithPreemption, a task that finds all workers busy preempts the lowest-priority running task.
+// SubmitWithPriority 使用默认处理函数提交给定优先级的消息。
+// 只有管道使用 PriorityQueue 时高优先级的任务才会被优先处理，在普通的先进先出队列上优先级不会改变处理顺序。
+// 启用 WithPreemption 时，发现所有工作协程都在忙碌的任务会抢占优先级最低的运行中任务。
+func (pipeline *Pipeline) SubmitWithPriority(msg any, priority int) error {
+	err := pipeline.submit(nil, msg, immediateDelay, func(element *internal.ElementExt) {
+		element.SetPriority(int64(priority))
+	})
+	if err != nil {
+		return err
+	}
+
+	// Free a worker for this task if all of them are busy with lower-priority tasks
+	// 如果所有工作协程都在处理更低优先级的任务，则为该任务释放一个工作协程
+	if pipeline.inflight != nil {
+		pipeline.preempt(int64(priority))
+	}
+
+	return nil
+}
+
 // updateTimer updates the pipeline timer
 // updateTimer 更新管道计时器
 func (pipeline *Pipeline) updateTimer() {
-	ticker := time.NewTicker(time.Second)
+	ticker := pipeline.config.clock.NewTicker(time.Second)
 	defer ticker.Stop()
 	defer pipeline.wg.Done()
 	for {
 		select {
 		case <-pipeline.ctx.Done():
 			return
-		case <-ticker.C:
-			pipeline.timer.Store(time.Now().UnixMilli())
+		case <-ticker.C():
+			pipeline.timer.Store(pipeline.config.clock.Now().UnixMilli())
+		}
+	}
+}
+
+// flushSink flushes the partial batch to the sink every flush interval until the pipeline is stopped
+// flushSink 每隔输出间隔将未满的批次输出到 sink，直到管道停止
+func (pipeline *Pipeline) flushSink() {
+	ticker := pipeline.config.clock.NewTicker(pipeline.config.sinkInterval)
+	defer ticker.Stop()
+	defer pipeline.wg.Done()
+	for {
+		select {
+		case <-pipeline.ctx.Done():
+			return
+		case <-ticker.C():
+			pipeline.batcher.flush()
 		}
 	}
 }
 
+// flushFinal flushes the last partial batch once the workers are done, so stopping does not lose results
+// flushFinal 在工作协程结束后输出最后未满的批次，使停止时不会丢失结果
+func (pipeline *Pipeline) flushFinal() {
+	if pipeline.batcher != nil {
+		pipeline.batcher.flush()
+	}
+}
+
+// SetHandleFunc atomically replaces the default handler function used by tasks submitted without one, nil restores
+// DefaultMsgHandleFunc, or removes the handler in the strict handler mode. Tasks already picked up by a worker may
+// still run with the previous function. A context-aware handler function set by WithContextHandleFunc keeps taking precedence.
+// It also replaces a handler set by WithFanOutHandler, whose results are no longer re-submitted afterwards
+// SetHandleFunc 原子地替换未携带处理函数的任务所使用的默认处理函数，nil 会恢复为 DefaultMsgHandleFunc，在严格处理函数模式下则会移除处理函数。
+// 已被工作协程获取的任务仍可能使用之前的处理函数。通过 WithContextHandleFunc 设置的可感知上下文的处理函数仍然优先。
+// 它同样会替换通过 WithFanOutHandler 设置的处理函数，之后的结果不再被重新提交
+func (pipeline *Pipeline) SetHandleFunc(fn MessageHandleFunc) {
+	if fn == nil && !pipeline.config.strictHandler {
+		fn = DefaultMsgHandleFunc
+	}
+	pipeline.fanOutOn.Store(false)
+	pipeline.handleFunc.Store(&fn)
+}
+
+// Name returns the name set by WithName, or an empty string if none was set
+// Name 返回通过 WithName 设置的名称，未设置时返回空字符串
+func (pipeline *Pipeline) Name() string {
+	return pipeline.config.name
+}
+
+// IsRunning reports whether the pipeline accepts new submissions, it returns false once Stop or StopAndDrain
+// has been called or the queue has been shut down. It is read-only and suitable for health checks
+// IsRunning 判断管道是否接收新的提交，调用 Stop 或 StopAndDrain 之后或队列已关闭时返回 false。它是只读的，适用于健康检查
+func (pipeline *Pipeline) IsRunning() bool {
+	return pipeline.ctx.Err() == nil && !pipeline.closing.Load() && !pipeline.queue.IsClosed()
+}
+
 // GetWorkerNumber gets the current number of worker goroutines
 // GetWorkerNumber 获取当前工作协程数量
 func (pipeline *Pipeline) GetWorkerNumber() int64 {
 	return pipeline.runningCount.Load()
 }
 
-// tryCreateExecutor checks if a new executor can be created
-// tryCreateExecutor 检查是否可以创建新的执行器
-func (pipeline *Pipeline) tryCreateExecutor() bool {
+// PendingCount gets the number of tasks enqueued but not yet picked up by a worker, including delayed tasks and retries
+// waiting for their backoff. Unlike Stats().Pending, tasks being handled are not counted, so it is a pure backlog signal.
+// PendingCount 获取已入队但尚未被工作协程取出的任务数量，包括延迟任务和等待退避的重试任务。
+// 与 Stats().Pending 不同，它不包含正在处理的任务，因此是纯粹的积压信号。
+func (pipeline *Pipeline) PendingCount() int64 {
+	return pipeline.queued.Load()
+}
+
+// SetMaxWorkers updates the worker ceiling at runtime, n is clamped to [1, defaultMaxWorkerNum].
+// Raising it lets new workers spawn on the next submission, lowering it makes surplus idle workers exit on their next scan
+// SetMaxWorkers 在运行时更新工作协程数量上限，n 会被限制在 [1, defaultMaxWorkerNum] 范围内。
+// 提高上限后下一次提交时可以创建新的工作协程，降低上限后多余的空闲工作协程会在下一次扫描时退出
+func (pipeline *Pipeline) SetMaxWorkers(n int) {
+	limit := int64(n)
+	if limit < defaultMinWorkerCount {
+		limit = defaultMinWorkerCount
+	}
+	if limit > defaultMaxWorkerNum {
+		limit = defaultMaxWorkerNum
+	}
+	pipeline.maxWorkers.Store(limit)
+}
+
+// GetMaxWorkers gets the current worker ceiling
+// GetMaxWorkers 获取当前工作协程数量上限
+func (pipeline *Pipeline) GetMaxWorkers() int64 {
+	return pipeline.maxWorkers.Load()
+}
+
+// Stats returns a snapshot of the pipeline running state
+// Stats 返回管道运行状态的快照
+func (pipeline *Pipeline) Stats() PipelineStats {
+	return PipelineStats{
+		Workers:   pipeline.runningCount.Load(),
+		Pending:   pipeline.pending.Load(),
+		InFlight:  pipeline.inflightNum.Load(),
+		Processed: pipeline.processed.Load(),
+		Failed:    pipeline.failed.Load(),
+	}
+}
+
+// PoolStats returns the usage counters of the pipeline element pool
+// PoolStats 返回管道元素池的使用计数
+func (pipeline *Pipeline) PoolStats() PoolStats {
+	return pipeline.elementPool.Stats()
+}
+
+// Durations returns the distribution of handler durations recorded since the pipeline was created,
+// or a zero value if WithTiming is not enabled
+// Durations 返回自管道创建以来记录的处理函数耗时分布，未开启 WithTiming 时返回零值
+func (pipeline *Pipeline) Durations() DurationStats {
+	if pipeline.timing == nil {
+		return DurationStats{}
+	}
+	return pipeline.timing.snapshot()
+}
+
+// weightOf returns the number of spawn tokens the message consumes, at least 1
+// weightOf 返回消息消耗的创建令牌数量，至少为 1
+func (pipeline *Pipeline) weightOf(msg any) int {
+	if pipeline.config.taskWeight == nil {
+		return 1
+	}
+	if weight := pipeline.config.taskWeight(msg); weight > 1 {
+		return weight
+	}
+	return 1
+}
+
+// tryCreateExecutor checks if a new executor can be created, reserving weight tokens from the worker limiter
+// tryCreateExecutor 检查是否可以创建新的执行器，会从工作协程限制器中预留 weight 个令牌
+func (pipeline *Pipeline) tryCreateExecutor(weight int) bool {
 	// Check if current running count reaches the limit
 	// 检查当前运行数量是否达到上限
-	if current := pipeline.runningCount.Load(); current >= int64(pipeline.config.num) {
+	current := pipeline.runningCount.Load()
+	if current >= pipeline.maxWorkers.Load() {
+		return false
+	}
+
+	// Reserve a slot of the global worker limit
+	// 预留全局工作协程限制的名额
+	if !globalWorkers.acquire(current) {
 		return false
 	}
 
 	// Check if worker token is available
 	// 检查是否能获取工作令牌
-	if !pipeline.workerLimit.Allow() {
+	if !pipeline.workerLimit.AllowN(pipeline.config.clock.Now(), weight) {
+		globalWorkers.release()
 		return false
 	}
 
 	// Increment counter atomically
 	// 原子操作增加计数
 	newCount := pipeline.runningCount.Add(1)
-	if newCount > int64(pipeline.config.num) {
+	if newCount > pipeline.maxWorkers.Load() {
 		pipeline.runningCount.Add(-1)
+		globalWorkers.release()
 		return false
 	}
 
@@ -304,6 +1535,7 @@ func (pipeline *Pipeline) tryCreateExecutor() bool {
 	// 创建新的执行器
 	pipeline.wg.Add(1)
 	go pipeline.executor()
+	pipeline.config.logger.Debugf("%s: worker spawned, running: %d", pipeline.config.logPrefix(), newCount)
 
 	return true
 }
@@ -0,0 +1,79 @@
+package karta
+
+import (
+	"context"
+	"errors"
+
+	"github.com/shengyanli1982/karta/internal"
+)
+
+// ErrTaskPreempted 表示任务被更高优先级的任务抢占
+// ErrTaskPreempted indicates that the task was preempted by a higher-priority task
+var ErrTaskPreempted = errors.New("task preempted by a higher-priority task")
+
+// inflightTask 记录一个正在处理的任务，以便被抢占
+// inflightTask records a task being handled so that it can be preempted
+type inflightTask struct {
+	priority  int64              // priority of the task / 任务优先级
+	cancel    context.CancelFunc // cancels the task context / 取消任务上下文
+	preempted bool               // whether the task has been preempted / 任务是否已被抢占
+}
+
+// track registers the element as in flight and returns the cancellable context its handler runs with
+// track 将元素登记为处理中，并返回其处理函数使用的可取消上下文
+func (pipeline *Pipeline) track(ctx context.Context, element *internal.ElementExt) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	pipeline.inflightLock.Lock()
+	pipeline.inflight[element] = &inflightTask{priority: element.GetPriority(), cancel: cancel}
+	pipeline.inflightLock.Unlock()
+
+	return ctx, cancel
+}
+
+// untrack removes the element from the in-flight set and reports whether it was preempted
+// untrack 将元素从处理中集合移除，并返回它是否被抢占
+func (pipeline *Pipeline) untrack(element *internal.ElementExt) bool {
+	pipeline.inflightLock.Lock()
+	defer pipeline.inflightLock.Unlock()
+
+	task := pipeline.inflight[element]
+	delete(pipeline.inflight, element)
+
+	return task != nil && task.preempted
+}
+
+// preempt cancels the lowest-priority in-flight task below the given priority, but only when the workers
+// are at the limit and all of them are busy. It returns true if a task was preempted.
+// preempt 取消优先级低于给定优先级且最低的处理中任务，但仅在工作协程数量达到上限且全部忙碌时进行。如果有任务被抢占则返回 true。
+func (pipeline *Pipeline) preempt(priority int64) bool {
+	pipeline.inflightLock.Lock()
+	defer pipeline.inflightLock.Unlock()
+
+	// A worker is still available, nothing to preempt
+	// 仍有可用的工作协程，无需抢占
+	running := pipeline.runningCount.Load()
+	if running < pipeline.maxWorkers.Load() || int64(len(pipeline.inflight)) < running {
+		return false
+	}
+
+	// Find the lowest-priority task which has not been preempted yet
+	// 查找尚未被抢占且优先级最低的任务
+	var victim *inflightTask
+	for _, task := range pipeline.inflight {
+		if task.preempted || task.priority >= priority {
+			continue
+		}
+		if victim == nil || task.priority < victim.priority {
+			victim = task
+		}
+	}
+	if victim == nil {
+		return false
+	}
+
+	victim.preempted = true
+	victim.cancel()
+
+	return true
+}
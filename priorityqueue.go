@@ -0,0 +1,182 @@
+package karta
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueEmpty 表示队列中没有可获取的元素
+// ErrQueueEmpty indicates that there is no element to get from the queue
+var ErrQueueEmpty = errors.New("queue is empty")
+
+// prioritized 是带有优先级的元素，Pipeline 提交的元素实现了该接口
+// prioritized is an element carrying a priority, elements submitted by Pipeline implement it
+type prioritized = interface {
+	GetPriority() int64
+}
+
+// priorityItem 是优先级堆中的一项
+// priorityItem is an item of the priority heap
+type priorityItem struct {
+	value    any   // 元素 Element
+	priority int64 // 优先级 Priority
+	sequence int64 // 入队序号，用于相同优先级时保持先进先出 Enqueue sequence, keeps FIFO order for equal priorities
+}
+
+// priorityHeap 实现了 heap.Interface，优先级高的元素在前，相同优先级按入队顺序排列
+// priorityHeap implements heap.Interface, higher priorities come first and equal priorities keep the enqueue order
+type priorityHeap []*priorityItem
+
+func (h priorityHeap) Len() int { return len(h) }
+
+func (h priorityHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].sequence < h[j].sequence
+}
+
+func (h priorityHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *priorityHeap) Push(x any) { *h = append(*h, x.(*priorityItem)) }
+
+func (h *priorityHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// PriorityQueue 是一个实现了 DelayingQueue 接口的优先级队列，Get 按优先级从高到低返回元素，相同优先级按先进先出返回。
+// 与 Pipeline.SubmitWithPriority 一起使用，未携带优先级的元素按优先级 0 处理。Get 从不阻塞，在默认的 GetBlocking 模式下，
+// 空闲的工作协程要到下一次状态扫描（最长 3 秒）才会取出新元素，因此需要低延迟时请配合 WithGetMode(GetPolling) 使用
+// PriorityQueue is a priority queue implementing the DelayingQueue interface, Get returns elements from the highest priority
+// to the lowest, in FIFO order for equal priorities. It is used with Pipeline.SubmitWithPriority, elements without a priority
+// are treated as priority 0. Get never blocks, so in the default GetBlocking mode an idle worker only picks up a new element
+// on its next state scan, up to 3 seconds later, use it with WithGetMode(GetPolling) when latency matters
+type PriorityQueue struct {
+	lock     sync.Mutex               // 保护队列状态的锁 Lock protecting the queue state
+	items    priorityHeap             // 优先级堆 Priority heap
+	sequence int64                    // 入队序号 Enqueue sequence
+	timers   map[*time.Timer]struct{} // 等待中的延迟定时器 Pending delay timers
+	closed   bool                     // 是否已关闭 Whether the queue is shut down
+}
+
+// NewPriorityQueue 创建并返回一个新的 PriorityQueue
+// NewPriorityQueue creates and returns a new PriorityQueue
+func NewPriorityQueue() *PriorityQueue {
+	return &PriorityQueue{
+		items:  make(priorityHeap, 0),
+		timers: make(map[*time.Timer]struct{}),
+	}
+}
+
+// Put 将元素按优先级放入队列
+// Put puts an element into the queue by its priority
+func (q *PriorityQueue) Put(value any) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return ErrorQueueClosed
+	}
+
+	item := &priorityItem{value: value, sequence: q.sequence}
+	if p, ok := value.(prioritized); ok {
+		item.priority = p.GetPriority()
+	}
+	q.sequence++
+	heap.Push(&q.items, item)
+
+	return nil
+}
+
+// PutWithDelay 在延迟 delay 毫秒后将元素按优先级放入队列
+// PutWithDelay puts an element into the queue by its priority after delay milliseconds
+func (q *PriorityQueue) PutWithDelay(value any, delay int64) error {
+	if delay <= 0 {
+		return q.Put(value)
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return ErrorQueueClosed
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Duration(delay)*time.Millisecond, func() {
+		q.lock.Lock()
+		closed := q.closed
+		delete(q.timers, timer)
+		q.lock.Unlock()
+
+		// A timer firing while the queue is shut down discards its element like the stopped ones
+		// 在队列关闭期间触发的定时器与已停止的定时器一样丢弃其元素
+		if !closed {
+			_ = q.Put(value)
+		}
+	})
+	q.timers[timer] = struct{}{}
+
+	return nil
+}
+
+// Get 获取优先级最高的元素，队列为空时返回 ErrQueueEmpty
+// Get gets the element with the highest priority, it returns ErrQueueEmpty if the queue is empty
+func (q *PriorityQueue) Get() (any, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return nil, ErrorQueueClosed
+	}
+	if q.items.Len() == 0 {
+		return nil, ErrQueueEmpty
+	}
+
+	return heap.Pop(&q.items).(*priorityItem).value, nil
+}
+
+// Done 标记元素处理完成，PriorityQueue 不跟踪处理中的元素，因此什么都不做
+// Done marks the element as done, PriorityQueue does not track elements being processed, so it does nothing
+func (q *PriorityQueue) Done(value any) {}
+
+// Len 返回队列中立即可获取的元素数量
+// Len returns the number of elements immediately available in the queue
+func (q *PriorityQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.items.Len()
+}
+
+// Shutdown 关闭队列，丢弃队列中的元素和尚未到期的延迟元素，停止后的 Pipeline 不再将它们计入待完成数量
+// Shutdown shuts the queue down, discarding queued elements and delayed elements that are not due yet, a stopped Pipeline no
+// longer counts them as pending
+func (q *PriorityQueue) Shutdown() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.closed = true
+	for timer := range q.timers {
+		timer.Stop()
+	}
+	q.timers = nil
+	q.items = nil
+}
+
+// IsClosed 检查队列是否已关闭
+// IsClosed checks if the queue is closed
+func (q *PriorityQueue) IsClosed() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.closed
+}
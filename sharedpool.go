@@ -0,0 +1,89 @@
+package karta
+
+import (
+	"context"
+	"sync"
+)
+
+// SharedPool is a bounded pool of long-lived workers that many groups hand their work to, so that short-lived groups
+// do not each start their own goroutines. Work is handed to idle workers in the order it was offered, a Map call uses
+// at most its configured worker number of pool workers and keeps each of them until the call runs out of elements.
+// SharedPool 是一个有上限的常驻工作协程池，多个工作组将任务交给它处理，使短生命周期的工作组不必各自启动协程。
+// 任务按提交的先后顺序交给空闲的工作协程，每次 Map 调用最多使用其配置的工作者数量个工作协程，并一直占用直到该调用的元素处理完毕。
+type SharedPool struct {
+	jobs   chan func()        // jobs handed over to the workers / 交给工作协程的任务
+	wg     sync.WaitGroup     // wait group of the workers / 工作协程的等待组
+	once   sync.Once          // ensures Stop is called only once / 确保 Stop 只被调用一次
+	ctx    context.Context    // context cancelled on Stop / Stop 时取消的上下文
+	cancel context.CancelFunc // function to cancel the context / 取消上下文的函数
+}
+
+// NewSharedPool creates a pool running the given number of workers, an invalid number is replaced with the default one
+// NewSharedPool 创建一个运行给定数量工作协程的池，无效的数量会被替换为默认值
+func NewSharedPool(workers int) *SharedPool {
+	if !isWorkerNumberValid(workers) {
+		workers = int(defaultMinWorkerNum)
+	}
+
+	pool := &SharedPool{jobs: make(chan func())}
+	pool.ctx, pool.cancel = context.WithCancel(context.Background())
+
+	pool.wg.Add(workers)
+	for workerID := 0; workerID < workers; workerID++ {
+		go pool.worker()
+	}
+
+	return pool
+}
+
+// worker runs the jobs handed over by dispatch until the pool is stopped
+// worker 运行 dispatch 交付的任务，直到池停止
+func (pool *SharedPool) worker() {
+	defer pool.wg.Done()
+	for {
+		select {
+		case job := <-pool.jobs:
+			job()
+		case <-pool.ctx.Done():
+			return
+		}
+	}
+}
+
+// dispatch hands n copies of job to the workers and waits for all of them to return. Copies not yet handed over
+// are skipped once ctx is done or the pool is stopped.
+// dispatch 将 n 份 job 交给工作协程，并等待全部返回。ctx 结束或池停止后，尚未交付的部分会被跳过。
+func (pool *SharedPool) dispatch(ctx context.Context, n int, job func()) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	run := func() {
+		defer wg.Done()
+		job()
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case pool.jobs <- run:
+		case <-ctx.Done():
+			wg.Done()
+		case <-pool.ctx.Done():
+			wg.Done()
+		}
+	}
+	wg.Wait()
+}
+
+// stopped reports whether the pool has been stopped
+// stopped 判断池是否已经停止
+func (pool *SharedPool) stopped() bool {
+	return pool.ctx.Err() != nil
+}
+
+// Stop stops the pool, letting every worker finish its current job. Groups using the pool are not stopped, but their
+// calls return nil from then on, so they should be stopped before the pool.
+// Stop 停止池，每个工作协程会完成其当前的任务。使用该池的工作组不会被停止，但此后它们的调用会返回 nil，因此应先于池停止它们。
+func (pool *SharedPool) Stop() {
+	pool.once.Do(func() {
+		pool.cancel()
+		pool.wg.Wait()
+	})
+}
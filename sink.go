@@ -0,0 +1,56 @@
+package karta
+
+import "sync"
+
+// resultBatcher accumulates task results and flushes them to the sink of the config in batches
+// resultBatcher 累积任务结果，并将其按批次输出到配置的 sink
+type resultBatcher struct {
+	lock   sync.Mutex
+	config *Config
+	batch  []TaskResult // results not flushed yet / 尚未输出的结果
+}
+
+// newResultBatcher creates a batcher flushing to the sink of config
+// newResultBatcher 创建一个输出到 config 中 sink 的批处理器
+func newResultBatcher(config *Config) *resultBatcher {
+	return &resultBatcher{
+		config: config,
+		batch:  make([]TaskResult, 0, config.sinkBatchSize),
+	}
+}
+
+// add appends a result, flushing the batch once it is full
+// add 追加一个结果，批次已满时将其输出
+func (b *resultBatcher) add(result TaskResult) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.batch = append(b.batch, result)
+	if len(b.batch) >= b.config.sinkBatchSize {
+		b.flushLocked()
+	}
+}
+
+// flush hands the accumulated results to the sink, if any
+// flush 将累积的结果交给 sink，没有结果时不做任何事
+func (b *resultBatcher) flush() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.flushLocked()
+}
+
+// flushLocked hands the accumulated results to the sink, the lock must be held so sink calls are never concurrent.
+// The sink owns the batch once called, a new one is started for the following results
+// flushLocked 将累积的结果交给 sink，调用时必须持有锁，使 sink 不会被并发调用。调用后批次归 sink 所有，之后的结果使用新的批次
+func (b *resultBatcher) flushLocked() {
+	if len(b.batch) == 0 {
+		return
+	}
+
+	batch := b.batch
+	b.batch = make([]TaskResult, 0, b.config.sinkBatchSize)
+	if err := b.config.sink(batch); err != nil {
+		b.config.logger.Warnf("%s: sink failed to flush %d results: %v", b.config.logPrefix(), len(batch), err)
+	}
+}
@@ -0,0 +1,216 @@
+package karta
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shengyanli1982/karta/internal"
+)
+
+// PoolStats 描述元素池的使用情况
+// PoolStats describes the usage of an element pool
+type PoolStats struct {
+	// Gets 是从池中获取元素的总次数
+	// Gets is the total number of elements taken from the pool
+	Gets int64 `json:"gets"`
+
+	// Puts 是归还到池中元素的总次数
+	// Puts is the total number of elements returned to the pool
+	Puts int64 `json:"puts"`
+
+	// Outstanding 是已获取但尚未归还的元素数量，持续增长说明存在泄漏
+	// Outstanding is the number of elements taken but not yet returned, a steadily growing value indicates a leak
+	Outstanding int64 `json:"outstanding"`
+
+	// Allocs 是池为空时新分配的元素数量，远小于 Gets 说明对象池有效地减少了分配。自定义的对象池只有实现了 AllocCounter 才会报告该值
+	// Allocs is the number of elements newly allocated because the pool was empty, a value far below Gets shows that pooling
+	// effectively reduces allocations. Customized pools only report it if they implement AllocCounter
+	Allocs int64 `json:"allocs"`
+}
+
+// PipelineStats 描述管道的运行状态
+// PipelineStats describes the running state of a pipeline
+type PipelineStats struct {
+	// Workers 是当前运行中的工作协程数量
+	// Workers is the number of workers currently running
+	Workers int64 `json:"workers"`
+
+	// Pending 是已提交但尚未处理完成的任务数量
+	// Pending is the number of tasks submitted but not yet completed
+	Pending int64 `json:"pending"`
+
+	// InFlight 是正在被工作协程处理的任务数量
+	// InFlight is the number of tasks currently being handled by workers
+	InFlight int64 `json:"in_flight"`
+
+	// Processed 是已处理完成的任务总数
+	// Processed is the total number of tasks completed
+	Processed int64 `json:"processed"`
+
+	// Failed 是最终以错误结束的任务总数，包含在 Processed 中
+	// Failed is the total number of tasks that finally completed with an error, included in Processed
+	Failed int64 `json:"failed"`
+}
+
+// GroupMetrics 描述工作组的累计处理情况
+// GroupMetrics describes the cumulative processing of a group
+type GroupMetrics struct {
+	// Processed 是已处理的元素总数
+	// Processed is the total number of elements handled
+	Processed int64 `json:"processed"`
+
+	// Failed 是处理出错的元素总数，包含在 Processed 中
+	// Failed is the total number of elements handled with an error, included in Processed
+	Failed int64 `json:"failed"`
+
+	// LastBatchDuration 是最近一个批次的耗时
+	// LastBatchDuration is the duration of the most recent batch
+	LastBatchDuration time.Duration `json:"last_batch_duration"`
+}
+
+// defaultTimingReservoirSize 是计算分位数时保留的处理耗时样本数量
+// defaultTimingReservoirSize is the number of handler duration samples kept for computing percentiles
+const defaultTimingReservoirSize = 1024
+
+// DurationStats 描述处理函数耗时的分布
+// DurationStats describes the distribution of handler durations
+type DurationStats struct {
+	// Count 是已记录的处理函数调用次数
+	// Count is the number of handler calls recorded
+	Count int64 `json:"count"`
+
+	// Min 是最短的处理耗时
+	// Min is the shortest handler duration
+	Min time.Duration `json:"min"`
+
+	// Max 是最长的处理耗时
+	// Max is the longest handler duration
+	Max time.Duration `json:"max"`
+
+	// P50 是处理耗时的中位数，根据采样估算
+	// P50 is the median handler duration, estimated from samples
+	P50 time.Duration `json:"p50"`
+
+	// P99 是处理耗时的第 99 百分位数，根据采样估算
+	// P99 is the 99th percentile handler duration, estimated from samples
+	P99 time.Duration `json:"p99"`
+}
+
+// durationRecorder records handler durations, keeping a uniform reservoir sample for percentiles
+// durationRecorder 记录处理函数耗时，并保留均匀的蓄水池样本用于计算分位数
+type durationRecorder struct {
+	lock    sync.Mutex      // lock protecting the recorder / 保护记录器的锁
+	count   int64           // number of recorded durations / 已记录的耗时数量
+	min     time.Duration   // shortest duration / 最短耗时
+	max     time.Duration   // longest duration / 最长耗时
+	samples []time.Duration // reservoir of sampled durations / 耗时样本蓄水池
+}
+
+// newDurationRecorder creates an empty duration recorder
+// newDurationRecorder 创建一个空的耗时记录器
+func newDurationRecorder() *durationRecorder {
+	return &durationRecorder{samples: make([]time.Duration, 0, defaultTimingReservoirSize)}
+}
+
+// record adds the duration elapsed since start
+// record 添加从 start 开始经过的耗时
+func (r *durationRecorder) record(start time.Time) {
+	r.add(time.Since(start))
+}
+
+// add adds a measured duration
+// add 添加一个已测量的耗时
+func (r *durationRecorder) add(d time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.count++
+	if r.count == 1 || d < r.min {
+		r.min = d
+	}
+	if d > r.max {
+		r.max = d
+	}
+
+	// Keep every sample until the reservoir is full, then replace a random one with decreasing probability
+	// 蓄水池未满时保留所有样本，之后以递减的概率替换随机的样本
+	if len(r.samples) < cap(r.samples) {
+		r.samples = append(r.samples, d)
+	} else if i := rand.Int63n(r.count); i < int64(len(r.samples)) {
+		r.samples[i] = d
+	}
+}
+
+// snapshot returns the current duration distribution
+// snapshot 返回当前的耗时分布
+func (r *durationRecorder) snapshot() DurationStats {
+	r.lock.Lock()
+	stats := DurationStats{Count: r.count, Min: r.min, Max: r.max}
+	samples := make([]time.Duration, len(r.samples))
+	copy(samples, r.samples)
+	r.lock.Unlock()
+
+	if len(samples) > 0 {
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		stats.P50 = samples[(len(samples)-1)*50/100]
+		stats.P99 = samples[(len(samples)-1)*99/100]
+	}
+
+	return stats
+}
+
+// elementExtPoolCounter wraps an element pool and counts Get and Put calls
+// elementExtPoolCounter 包装元素池并统计 Get 和 Put 的调用次数
+type elementExtPoolCounter struct {
+	pool ElementPooler // wrapped element pool / 被包装的元素池
+	gets atomic.Int64  // number of Get calls / Get 调用次数
+	puts atomic.Int64  // number of Put calls / Put 调用次数
+}
+
+// newElementExtPoolCounter creates a counting wrapper around the given pool
+// newElementExtPoolCounter 创建一个包装给定元素池的计数器
+func newElementExtPoolCounter(pool ElementPooler) *elementExtPoolCounter {
+	return &elementExtPoolCounter{pool: pool}
+}
+
+// Get takes an element from the pool and records the call
+// Get 从池中获取元素并记录调用
+func (p *elementExtPoolCounter) Get() *internal.ElementExt {
+	p.gets.Add(1)
+	return p.pool.Get()
+}
+
+// Put resets the element, releasing its message, then returns it to the pool and records the call
+// Put 重置元素以释放其消息，然后将其归还到池中并记录调用
+func (p *elementExtPoolCounter) Put(element *internal.ElementExt) {
+	if element != nil {
+		element.Reset()
+		p.puts.Add(1)
+		p.pool.Put(element)
+	}
+}
+
+// Stats returns a snapshot of the pool counters
+// Stats 返回池计数器的快照
+func (p *elementExtPoolCounter) Stats() PoolStats {
+	// Load puts before gets so that outstanding never goes negative under concurrency
+	// 先读取 puts 再读取 gets，保证并发情况下 outstanding 不会为负数
+	puts := p.puts.Load()
+	gets := p.gets.Load()
+	stats := PoolStats{
+		Gets:        gets,
+		Puts:        puts,
+		Outstanding: gets - puts,
+	}
+
+	// Report allocations if the pool counts them
+	// 如果池统计了分配次数，则报告该值
+	if counter, ok := p.pool.(AllocCounter); ok {
+		stats.Allocs = counter.Allocs()
+	}
+
+	return stats
+}
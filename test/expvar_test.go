@@ -0,0 +1,64 @@
+package test
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+	"time"
+
+	k "github.com/shengyanli1982/karta"
+	wkq "github.com/shengyanli1982/workqueue/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPipeline_PublishExpvar tests that the published counters reflect the workload
+func TestPipeline_PublishExpvar(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		// 偶数任务失败
+		if msg.(int)%2 == 0 {
+			return nil, assert.AnError
+		}
+		return msg, nil
+	}).WithWorkerNumber(2)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	pl.PublishExpvar("karta_test_pipeline")
+	// 重复发布不应该 panic
+	pl.PublishExpvar("karta_test_pipeline")
+
+	for i := 0; i < 10; i++ {
+		err := pl.Submit(i)
+		assert.Nil(t, err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if pl.Stats().Processed == 10 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	v := expvar.Get("karta_test_pipeline")
+	assert.NotNil(t, v)
+
+	var stats k.PipelineStats
+	assert.Nil(t, json.Unmarshal([]byte(v.String()), &stats))
+	assert.Equal(t, int64(10), stats.Processed)
+	assert.Equal(t, int64(5), stats.Failed)
+	assert.Equal(t, int64(0), stats.Pending)
+	assert.Equal(t, int64(0), stats.InFlight)
+	assert.GreaterOrEqual(t, stats.Workers, int64(1))
+
+	// 另一个管道以相同名称发布时重新绑定
+	other := k.NewPipeline(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), k.NewConfig())
+	other.PublishExpvar("karta_test_pipeline")
+	assert.Nil(t, json.Unmarshal([]byte(expvar.Get("karta_test_pipeline").String()), &stats))
+	assert.Equal(t, int64(0), stats.Processed)
+
+	other.Stop()
+	pl.Stop()
+}
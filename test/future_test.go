@@ -0,0 +1,197 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	k "github.com/shengyanli1982/karta"
+	wkq "github.com/shengyanli1982/workqueue/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPipeline_SubmitFutureContext_Basic tests that the future resolves with the task result
+func TestPipeline_SubmitFutureContext_Basic(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(handleFunc).WithWorkerNumber(2)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	future, err := pl.SubmitFutureContext(context.Background(), 1)
+	assert.Nil(t, err)
+	assert.NotNil(t, future)
+
+	result, err := future.Get(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result)
+
+	pl.Stop()
+}
+
+// TestPipeline_SubmitFutureContext_Cancel tests that cancelling the submission context cancels the task
+func TestPipeline_SubmitFutureContext_Cancel(t *testing.T) {
+	c := k.NewConfig()
+	c.WithContextHandleFunc(func(ctx context.Context, msg any) (any, error) {
+		// 阻塞直到上下文被取消
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}).WithWorkerNumber(2)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	future, err := pl.SubmitFutureContext(ctx, 1)
+	assert.Nil(t, err)
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	result, err := future.Get(context.Background())
+	assert.Nil(t, result)
+	assert.Equal(t, context.Canceled, err)
+
+	// 任务本身也应该以取消错误结束
+	select {
+	case <-future.Done():
+	case <-time.After(time.Second):
+		t.Fatal("task was not cancelled")
+	}
+
+	pl.Stop()
+}
+
+// TestPipeline_SubmitFutureContext_WhenQueueClosed tests submission when queue is closed
+func TestPipeline_SubmitFutureContext_WhenQueueClosed(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(handleFunc).WithWorkerNumber(2)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	pl.Stop()
+
+	future, err := pl.SubmitFutureContext(context.Background(), 1)
+	assert.Nil(t, future)
+	assert.Equal(t, k.ErrorQueueClosed, err)
+}
+
+// TestPipeline_SubmitFuture_Correlate tests that every future resolves with the outcome of its own task
+func TestPipeline_SubmitFuture_Correlate(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		if msg.(int)%3 == 0 {
+			return nil, assert.AnError
+		}
+		return msg.(int) * 2, nil
+	}).WithWorkerNumber(4)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	futures := make([]*k.Future, 20)
+	for i := range futures {
+		future, err := pl.SubmitFuture(i)
+		assert.Nil(t, err)
+		futures[i] = future
+	}
+
+	for i, future := range futures {
+		result, err := future.Get(context.Background())
+		if i%3 == 0 {
+			assert.Equal(t, assert.AnError, err)
+			assert.Nil(t, result)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, i*2, result)
+		}
+	}
+
+	pl.Stop()
+
+	future, err := pl.SubmitFuture(1)
+	assert.Nil(t, future)
+	assert.Equal(t, k.ErrorQueueClosed, err)
+}
+
+// TestPipeline_SubmitAfterFuture tests that the future of a delayed task resolves with its result, or with
+// ErrorQueueClosed when the pipeline is stopped before the task runs
+func TestPipeline_SubmitAfterFuture(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		return msg.(int) * 2, nil
+	}).WithGetMode(k.GetPolling)
+	queue := wkq.NewDelayingQueue(nil)
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	start := time.Now()
+	future, err := pl.SubmitAfterFuture(1, 50*time.Millisecond)
+	assert.Nil(t, err)
+	result, err := future.Get(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// 任务执行前管道停止，Future 以 ErrorQueueClosed 完成
+	pending, err := pl.SubmitAfterFuture(2, time.Minute)
+	assert.Nil(t, err)
+	pl.Stop()
+	result, err = pending.Get(context.Background())
+	assert.Nil(t, result)
+	assert.Equal(t, k.ErrorQueueClosed, err)
+
+	future, err = pl.SubmitAfterFuture(3, time.Millisecond)
+	assert.Nil(t, future)
+	assert.Equal(t, k.ErrorQueueClosed, err)
+}
+
+// TestPipeline_SubmitFuture_StopAndDrainTimeout tests that the future of a task abandoned by StopAndDrain resolves
+// with ErrorQueueClosed
+func TestPipeline_SubmitFuture_StopAndDrainTimeout(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		started <- struct{}{}
+		<-release
+		return msg, nil
+	}).WithSingleWorker()
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	running, err := pl.SubmitFuture(1)
+	assert.Nil(t, err)
+	<-started
+
+	// 唯一的工作协程被占用，第二个任务留在队列中
+	waiting, err := pl.SubmitFuture(2)
+	assert.Nil(t, err)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, k.ErrDrainTimeout, pl.StopAndDrain(ctx))
+
+	result, err := running.Get(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result)
+
+	// 被放弃的任务不会执行，Future 以 ErrorQueueClosed 完成
+	getCtx, getCancel := context.WithTimeout(context.Background(), time.Second)
+	defer getCancel()
+	result, err = waiting.Get(getCtx)
+	assert.Nil(t, result)
+	assert.Equal(t, k.ErrorQueueClosed, err)
+}
@@ -1,7 +1,14 @@
 package test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -56,6 +63,73 @@ func TestGroup_Map_WithLargeWorkerPool(t *testing.T) {
 	g.Stop()
 }
 
+// TestGroup_Map_WorkersClampedToInput tests that Map starts no more workers than there are inputs
+func TestGroup_Map_WorkersClampedToInput(t *testing.T) {
+	inputs := []any{1, 2, 3}
+
+	var arrived sync.WaitGroup
+	arrived.Add(len(inputs))
+	var peak int64
+
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		// 所有任务都在运行时统计协程数量
+		arrived.Done()
+		arrived.Wait()
+		n := int64(runtime.NumGoroutine())
+		for {
+			old := atomic.LoadInt64(&peak)
+			if n <= old || atomic.CompareAndSwapInt64(&peak, old, n) {
+				break
+			}
+		}
+		return msg, nil
+	}).WithWorkerNumber(200).WithResult()
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+	defer g.Stop()
+
+	before := runtime.NumGoroutine()
+	assert.Equal(t, inputs, g.Map(inputs))
+	assert.LessOrEqual(t, atomic.LoadInt64(&peak)-int64(before), int64(len(inputs)))
+}
+
+// TestGroup_WithChunkSize tests that Map processes the input window by window with results aligned to the input
+func TestGroup_WithChunkSize(t *testing.T) {
+	var running, peak int32
+
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if n <= old || atomic.CompareAndSwapInt32(&peak, old, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return msg.(int) * 2, nil
+	}).WithWorkerNumber(8).WithChunkSize(3).WithResult()
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+	defer g.Stop()
+
+	inputs := make([]any, 10)
+	expected := make([]any, 10)
+	for i := range inputs {
+		inputs[i] = i
+		expected[i] = i * 2
+	}
+
+	// 每个窗口最多并发处理 3 个元素，结果仍与输入对齐
+	assert.Equal(t, expected, g.Map(inputs))
+	assert.LessOrEqual(t, atomic.LoadInt32(&peak), int32(3))
+	assert.Equal(t, int64(10), g.Metrics().Processed)
+}
+
 // TestGroup_Map_WithCallback tests Map with a callback
 func TestGroup_Map_WithCallback(t *testing.T) {
 	c := k.NewConfig()
@@ -130,6 +204,58 @@ func TestGroup_Map_WithNegativeWorkers(t *testing.T) {
 	g.Stop()
 }
 
+// TestConfig_Validate tests that Validate reports invalid settings without correcting them
+func TestConfig_Validate(t *testing.T) {
+	assert.Nil(t, k.NewConfig().Validate())
+
+	c := k.NewConfig()
+	c.WithWorkerNumber(-1).WithStrictHandler().WithRetry(0, -time.Second)
+	err := c.Validate()
+	assert.True(t, errors.Is(err, k.ErrInvalidWorkerNumber))
+	assert.True(t, errors.Is(err, k.ErrNoHandler))
+	assert.True(t, errors.Is(err, k.ErrInvalidConfig))
+	assert.Contains(t, err.Error(), "got -1")
+
+	// 校验不会修改配置
+	assert.Equal(t, err.Error(), c.Validate().Error())
+}
+
+// TestConfig_Snapshot tests that the snapshot and getters reflect the settings in effect after construction
+func TestConfig_Snapshot(t *testing.T) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(-1).WithResult().WithChunkSize(8).WithName("batch")
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+	defer g.Stop()
+
+	// 构造函数修正了无效的工作者数量
+	view := c.Snapshot()
+	assert.Equal(t, 2, view.WorkerNumber)
+	assert.True(t, view.Result)
+	assert.Equal(t, 8, view.ChunkSize)
+	assert.Equal(t, "batch", view.Name)
+	assert.Equal(t, 1, view.RetryAttempts)
+	assert.Equal(t, 2, c.WorkerNumber())
+	assert.True(t, c.ResultEnabled())
+}
+
+// TestGroup_NewGroupStrict tests that NewGroupStrict rejects an invalid configuration instead of correcting it
+func TestGroup_NewGroupStrict(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(handleFunc).WithWorkerNumber(-1)
+
+	g, err := k.NewGroupStrict(c)
+	assert.Nil(t, g)
+	assert.True(t, errors.Is(err, k.ErrInvalidWorkerNumber))
+
+	g, err = k.NewGroupStrict(c.WithWorkerNumber(2).WithResult())
+	assert.Nil(t, err)
+	assert.NotNil(t, g)
+	defer g.Stop()
+	assert.Equal(t, []any{1, 2, 3}, g.Map([]any{1, 2, 3}))
+}
+
 // TestGroup_Map_WithLargeInput tests Map with large input
 func TestGroup_Map_WithLargeInput(t *testing.T) {
 	c := k.NewConfig()
@@ -230,6 +356,27 @@ func TestGroup_Map_AfterStop(t *testing.T) {
 	assert.Nil(t, r1)
 }
 
+// TestGroup_Reset_AfterStop tests that a stopped group can be reused after Reset
+func TestGroup_Reset_AfterStop(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(handleFunc).WithWorkerNumber(2).WithResult().WithPersistentWorkers()
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+	assert.Equal(t, []any{1, 2}, g.Map([]any{1, 2}))
+
+	g.Stop()
+	assert.Nil(t, g.Map([]any{3, 4}))
+
+	// 重置后可以再次使用，并且可以再次停止
+	g.Reset()
+	assert.Equal(t, []any{3, 4}, g.Map([]any{3, 4}))
+	assert.Equal(t, int64(4), g.Metrics().Processed)
+
+	g.Stop()
+	assert.Nil(t, g.Map([]any{5, 6}))
+}
+
 // TestGroup_Map_ConcurrentCalls tests concurrent calls to Map
 func TestGroup_Map_ConcurrentCalls(t *testing.T) {
 	c := k.NewConfig()
@@ -261,3 +408,1196 @@ func TestGroup_Map_ConcurrentCalls(t *testing.T) {
 
 	g.Stop()
 }
+
+// TestGroup_MapWithRetry_Basic tests that failed elements succeed on retry within a single call
+func TestGroup_MapWithRetry_Basic(t *testing.T) {
+	var attempts sync.Map
+
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		// 偶数输入第一次处理失败，重试时成功
+		count, _ := attempts.LoadOrStore(msg, new(int32))
+		if n := atomic.AddInt32(count.(*int32), 1); msg.(int)%2 == 0 && n == 1 {
+			return nil, assert.AnError
+		}
+		return msg, nil
+	}).WithWorkerNumber(2)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	r0, errs := g.MapWithRetry([]any{1, 2, 3, 4}, 3)
+	assert.Equal(t, 4, len(r0))
+	assert.Equal(t, 4, len(errs))
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, i+1, r0[i])
+		assert.Nil(t, errs[i])
+	}
+
+	// 偶数输入应该被处理两次，奇数输入只处理一次
+	for i := 1; i <= 4; i++ {
+		count, _ := attempts.Load(i)
+		assert.Equal(t, int32(2-i%2), atomic.LoadInt32(count.(*int32)))
+	}
+	g.Stop()
+}
+
+// TestGroup_MapWithRetry_Exhausted tests that the last error is returned once attempts are exhausted
+func TestGroup_MapWithRetry_Exhausted(t *testing.T) {
+	calls := int32(0)
+
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, assert.AnError
+	}).WithWorkerNumber(2)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	r0, errs := g.MapWithRetry([]any{1, 2}, 3)
+	assert.Equal(t, 2, len(r0))
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, r0[i])
+		assert.Equal(t, assert.AnError, errs[i])
+	}
+	assert.Equal(t, int32(6), atomic.LoadInt32(&calls))
+	g.Stop()
+}
+
+// errorRecorder is a callback recording the errors delivered to OnAfter
+type errorRecorder struct {
+	lock sync.Mutex
+	errs map[any]error
+}
+
+func (r *errorRecorder) OnBefore(msg any) {}
+
+func (r *errorRecorder) OnAfter(msg, result any, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.errs == nil {
+		r.errs = make(map[any]error)
+	}
+	r.errs[msg] = err
+}
+
+// panicRecorder is a callback recording the panics delivered to OnPanic
+type panicRecorder struct {
+	errorRecorder
+	panics sync.Map
+}
+
+func (r *panicRecorder) OnPanic(msg any, recovered any, stack []byte) {
+	r.panics.Store(msg, stack)
+}
+
+func (r *errorRecorder) Get(msg any) (error, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	err, ok := r.errs[msg]
+	return err, ok
+}
+
+// TestGroup_Map_WithTaskTimeout tests that slow tasks are reported as timed out
+func TestGroup_Map_WithTaskTimeout(t *testing.T) {
+	recorder := &errorRecorder{}
+	c := k.NewConfig()
+	c.WithHandleFunc(handleFunc).WithWorkerNumber(2).WithResult().
+		WithTaskTimeout(300 * time.Millisecond).WithCallback(recorder)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	start := time.Now()
+	r0 := g.Map([]any{1, 20})
+	assert.Less(t, time.Since(start), time.Second)
+
+	assert.Equal(t, 2, len(r0))
+	assert.Equal(t, 1, r0[0])
+	assert.Nil(t, r0[1])
+
+	err, ok := recorder.Get(1)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	err, ok = recorder.Get(20)
+	assert.True(t, ok)
+	assert.Equal(t, k.ErrTaskTimeout, err)
+	g.Stop()
+}
+
+// TestGroup_Map_WithPanicHandler tests that a panicking handler is reported as an error
+func TestGroup_Map_WithPanicHandler(t *testing.T) {
+	recorder := &errorRecorder{}
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		if msg.(int) == 2 {
+			panic("boom")
+		}
+		return msg, nil
+	}).WithWorkerNumber(2).WithResult().WithCallback(recorder)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	r0 := g.Map([]any{1, 2, 3})
+	assert.Equal(t, 3, len(r0))
+	assert.Equal(t, 1, r0[0])
+	assert.Nil(t, r0[1])
+	assert.Equal(t, 3, r0[2])
+
+	err, ok := recorder.Get(2)
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, k.ErrHandlerPanic)
+	g.Stop()
+}
+
+// TestGroup_Map_WithPanicCallback tests that OnPanic receives the recovered value and stack
+func TestGroup_Map_WithPanicCallback(t *testing.T) {
+	recorder := &panicRecorder{}
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		if msg.(int) == 2 {
+			panic("boom")
+		}
+		return msg, nil
+	}).WithWorkerNumber(2).WithCallback(recorder)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+	_ = g.Map([]any{1, 2, 3})
+
+	stack, ok := recorder.panics.Load(2)
+	assert.True(t, ok)
+	assert.NotEmpty(t, stack)
+	_, ok = recorder.panics.Load(1)
+	assert.False(t, ok)
+
+	err, ok := recorder.Get(2)
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, k.ErrHandlerPanic)
+	g.Stop()
+}
+
+// countingCallback is a callback counting OnBefore and OnAfter calls
+type countingCallback struct {
+	before int32
+	after  int32
+}
+
+func (c *countingCallback) OnBefore(msg any) { atomic.AddInt32(&c.before, 1) }
+
+func (c *countingCallback) OnAfter(msg, result any, err error) { atomic.AddInt32(&c.after, 1) }
+
+// TestGroup_Map_SingleElement tests that the single element fast path behaves like the multi-element path
+func TestGroup_Map_SingleElement(t *testing.T) {
+	single := &countingCallback{}
+	c0 := k.NewConfig()
+	c0.WithHandleFunc(handleFunc).WithWorkerNumber(2).WithResult().WithCallback(single)
+	g0 := k.NewGroup(c0)
+
+	multi := &countingCallback{}
+	c1 := k.NewConfig()
+	c1.WithHandleFunc(handleFunc).WithWorkerNumber(2).WithResult().WithCallback(multi)
+	g1 := k.NewGroup(c1)
+
+	r0 := g0.Map([]any{1})
+	r1 := g1.Map([]any{1, 1})
+
+	assert.Equal(t, 1, len(r0))
+	assert.Equal(t, r1[0], r0[0])
+	assert.Equal(t, int32(1), atomic.LoadInt32(&single.before))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&single.after))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&multi.before))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&multi.after))
+
+	// 未开启结果收集时返回 nil
+	c2 := k.NewConfig()
+	c2.WithHandleFunc(handleFunc).WithWorkerNumber(2)
+	g2 := k.NewGroup(c2)
+	assert.Nil(t, g2.Map([]any{1}))
+
+	g0.Stop()
+	g1.Stop()
+	g2.Stop()
+}
+
+// BenchmarkGroup_Map_SingleElement benchmarks Map with a single element
+func BenchmarkGroup_Map_SingleElement(b *testing.B) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(8).WithResult()
+	g := k.NewGroup(c)
+	defer g.Stop()
+
+	input := []any{1}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = g.Map(input)
+	}
+}
+
+// BenchmarkGroup_Map_TwoElements benchmarks Map with two elements for comparison with the single element path
+func BenchmarkGroup_Map_TwoElements(b *testing.B) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(8).WithResult()
+	g := k.NewGroup(c)
+	defer g.Stop()
+
+	input := []any{1, 2}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = g.Map(input)
+	}
+}
+
+// TestGroup_MapStream_Ordered tests that streamed results arrive in input order before the batch completes
+func TestGroup_MapStream_Ordered(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(handleFunc).WithWorkerNumber(4)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	start := time.Now()
+	stream := g.MapStream([]any{1, 3, 2, 10})
+
+	// 第一个结果应该在最慢的元素完成之前到达
+	first := <-stream
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, 0, first.Index)
+	assert.Equal(t, 1, first.Value)
+	assert.Nil(t, first.Err)
+
+	expected := []int{3, 2, 10}
+	index := 1
+	for r := range stream {
+		assert.Equal(t, index, r.Index)
+		assert.Equal(t, expected[index-1], r.Value)
+		index++
+	}
+	assert.Equal(t, 4, index)
+	g.Stop()
+}
+
+// TestGroup_MapStream_WithEmptyInput tests that the stream is closed immediately for empty input
+func TestGroup_MapStream_WithEmptyInput(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(handleFunc).WithWorkerNumber(2)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	_, ok := <-g.MapStream(nil)
+	assert.False(t, ok)
+	g.Stop()
+}
+
+// TestGroup_Filter_Basic tests that Filter keeps matching elements in input order
+func TestGroup_Filter_Basic(t *testing.T) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(4)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	input := make([]any, 100)
+	for i := 0; i < 100; i++ {
+		input[i] = i
+	}
+
+	r0 := g.Filter(input, func(msg any) (bool, error) {
+		// 奇数返回错误，应该被排除
+		if msg.(int)%2 == 1 {
+			return true, assert.AnError
+		}
+		return msg.(int)%4 == 0, nil
+	})
+	assert.Equal(t, 25, len(r0))
+	for i, v := range r0 {
+		assert.Equal(t, i*4, v)
+	}
+
+	assert.Nil(t, g.Filter(nil, func(msg any) (bool, error) { return true, nil }))
+	g.Stop()
+}
+
+// TestGroup_ForEach_Basic tests that ForEach visits every element and reports errors
+func TestGroup_ForEach_Basic(t *testing.T) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(4)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	sum := int64(0)
+	err := g.ForEach([]any{1, 2, 3, 4, 5}, func(msg any) error {
+		atomic.AddInt64(&sum, int64(msg.(int)))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(15), atomic.LoadInt64(&sum))
+
+	// 存在失败的元素时返回错误，其他元素仍然被处理
+	visited := int32(0)
+	err = g.ForEach([]any{1, 2, 3, 4, 5}, func(msg any) error {
+		atomic.AddInt32(&visited, 1)
+		if msg.(int) == 3 {
+			return assert.AnError
+		}
+		return nil
+	})
+	assert.Equal(t, assert.AnError, err)
+	assert.Equal(t, int32(5), atomic.LoadInt32(&visited))
+
+	assert.Nil(t, g.ForEach(nil, func(msg any) error { return assert.AnError }))
+	g.Stop()
+}
+
+// TestGroup_MapReduce_Basic tests that MapReduce folds results deterministically in input order
+func TestGroup_MapReduce_Basic(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		return msg.(int) * 2, nil
+	}).WithWorkerNumber(4)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	input := make([]any, 50)
+	for i := 0; i < 50; i++ {
+		input[i] = i
+	}
+
+	// 非交换的折叠操作，结果依赖于输入顺序
+	r0 := g.MapReduce(input, func(acc, item any) any {
+		return append(acc.([]int), item.(int))
+	}, []int{})
+	for i, v := range r0.([]int) {
+		assert.Equal(t, i*2, v)
+	}
+
+	sum := g.MapReduce(input, func(acc, item any) any {
+		return acc.(int) + item.(int)
+	}, 0)
+	assert.Equal(t, 2450, sum)
+
+	// 空输入返回初始值
+	assert.Equal(t, 7, g.MapReduce(nil, func(acc, item any) any { return nil }, 7))
+	g.Stop()
+}
+
+// TestGroup_Reduce tests that Reduce folds successful results as they complete and returns the first error
+func TestGroup_Reduce(t *testing.T) {
+	g := k.NewGroup(k.NewConfig().WithWorkerNumber(4))
+	assert.NotNil(t, g)
+
+	input := make([]any, 50)
+	for i := 0; i < 50; i++ {
+		input[i] = i
+	}
+	double := func(msg any) (any, error) {
+		if msg.(int) == 10 {
+			return nil, errSentinel
+		}
+		return msg.(int) * 2, nil
+	}
+	sum := func(acc, v any) any { return acc.(int) + v.(int) }
+
+	// 失败的元素不参与折叠
+	r0, err := g.Reduce(input, double, sum, 0)
+	assert.Equal(t, 2450-20, r0)
+	assert.Equal(t, errSentinel, err)
+
+	// 空输入返回初始值
+	r1, err := g.Reduce(nil, double, sum, 7)
+	assert.Equal(t, 7, r1)
+	assert.Nil(t, err)
+	g.Stop()
+}
+
+// TestGroup_MapContext_Cancel tests that MapContext aborts remaining tasks when the context is cancelled
+func TestGroup_MapContext_Cancel(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		time.Sleep(100 * time.Millisecond)
+		return msg, nil
+	}).WithWorkerNumber(2).WithResult()
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	input := make([]any, 20)
+	for i := 0; i < 20; i++ {
+		input[i] = i
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+
+	r0 := g.MapContext(ctx, input)
+	assert.Equal(t, len(input), len(r0))
+
+	completed := 0
+	for i, v := range r0 {
+		if v != nil {
+			assert.Equal(t, i, v)
+			completed++
+		}
+	}
+	assert.Greater(t, completed, 0)
+	assert.Less(t, completed, len(input))
+
+	// 已取消的上下文不处理任何任务
+	r1 := g.MapContext(ctx, []any{1, 2, 3})
+	assert.Equal(t, []any{nil, nil, nil}, r1)
+
+	// 未取消的上下文处理全部任务
+	r2 := g.MapContext(context.Background(), []any{1, 2, 3})
+	assert.Equal(t, []any{1, 2, 3}, r2)
+	g.Stop()
+}
+
+// TestGroup_Metrics_Cumulative tests that Metrics accumulates across batches until ResetMetrics is called
+func TestGroup_Metrics_Cumulative(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		time.Sleep(10 * time.Millisecond)
+		if msg.(int)%2 == 0 {
+			return nil, assert.AnError
+		}
+		return msg, nil
+	}).WithWorkerNumber(2)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	g.Map([]any{1, 2, 3, 4})
+	m := g.Metrics()
+	assert.Equal(t, int64(4), m.Processed)
+	assert.Equal(t, int64(2), m.Failed)
+	assert.Greater(t, m.LastBatchDuration, time.Duration(0))
+
+	// 计数在批次之间累计
+	g.Map([]any{5})
+	m = g.Metrics()
+	assert.Equal(t, int64(5), m.Processed)
+	assert.Equal(t, int64(2), m.Failed)
+
+	g.ResetMetrics()
+	assert.Equal(t, k.GroupMetrics{}, g.Metrics())
+	g.Stop()
+}
+
+// orderRecorder is a callback recording the order of OnBefore and OnAfter calls
+type orderRecorder struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (c *orderRecorder) OnBefore(msg any) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.events = append(c.events, fmt.Sprintf("before:%v", msg))
+}
+
+func (c *orderRecorder) OnAfter(msg, result any, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.events = append(c.events, fmt.Sprintf("after:%v", msg))
+}
+
+// TestGroup_Map_SingleWorker tests that a single worker processes elements sequentially in index order
+func TestGroup_Map_SingleWorker(t *testing.T) {
+	recorder := &orderRecorder{}
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		return msg, nil
+	}).WithSingleWorker().WithResult().WithCallback(recorder)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	r0 := g.Map([]any{3, 1, 2})
+	assert.Equal(t, []any{3, 1, 2}, r0)
+	assert.Equal(t, []string{"before:3", "after:3", "before:1", "after:1", "before:2", "after:2"}, recorder.events)
+	assert.Equal(t, 1, c.WorkerNumber())
+	assert.Nil(t, c.Validate())
+	g.Stop()
+
+	// 只设置一个工作者不会开启单工作者模式，仍被修正为默认的工作者数量
+	c1 := k.NewConfig().WithWorkerNumber(1)
+	assert.True(t, errors.Is(c1.Validate(), k.ErrInvalidWorkerNumber))
+	g1 := k.NewGroup(c1)
+	assert.Equal(t, 2, c1.WorkerNumber())
+	g1.Stop()
+}
+
+// TestGroup_MapIter_Basic tests that MapIter pulls inputs lazily and aligns results with the pull order
+func TestGroup_MapIter_Basic(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		return msg.(int) * 2, nil
+	}).WithWorkerNumber(4).WithResult()
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	i := 0
+	r0 := g.MapIter(func() (any, bool) {
+		if i >= 1000 {
+			return nil, false
+		}
+		i++
+		return i - 1, true
+	})
+	assert.Equal(t, 1000, len(r0))
+	for j, v := range r0 {
+		assert.Equal(t, j*2, v)
+	}
+
+	// 空迭代器返回 nil
+	assert.Nil(t, g.MapIter(func() (any, bool) { return nil, false }))
+
+	g.Stop()
+	assert.Nil(t, g.MapIter(func() (any, bool) { return 1, true }))
+}
+
+// TestGroup_Map_PersistentWorkers tests that persistent workers are reused across Map calls and exit on Stop
+func TestGroup_Map_PersistentWorkers(t *testing.T) {
+	var lock sync.Mutex
+	workers := make(map[uint64]struct{})
+
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		// 记录执行处理函数的协程 ID
+		buf := make([]byte, 64)
+		var id uint64
+		fmt.Sscanf(string(buf[:runtime.Stack(buf, false)]), "goroutine %d ", &id)
+		lock.Lock()
+		workers[id] = struct{}{}
+		lock.Unlock()
+		return msg.(int) + 1, nil
+	}).WithWorkerNumber(4).WithResult().WithPersistentWorkers()
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	for i := 0; i < 100; i++ {
+		r0 := g.Map([]any{i, i + 1, i + 2})
+		assert.Equal(t, []any{i + 1, i + 2, i + 3}, r0)
+	}
+
+	// 所有调用都由同一组常驻的工作协程处理
+	assert.LessOrEqual(t, len(workers), 4)
+
+	g.Stop()
+	assert.Nil(t, g.Map([]any{1, 2}))
+}
+
+// errSentinel is a sentinel error used to check that errors are preserved through joining
+var errSentinel = errors.New("sentinel")
+
+// TestGroup_MapErr_Join tests that MapErr joins all handler errors and keeps results aligned
+func TestGroup_MapErr_Join(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		switch msg.(int) {
+		case 2:
+			return nil, assert.AnError
+		case 4:
+			return nil, fmt.Errorf("wrapped: %w", errSentinel)
+		}
+		return msg, nil
+	}).WithWorkerNumber(2)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	r0, err := g.MapErr([]any{1, 2, 3, 4})
+	assert.Equal(t, []any{1, nil, 3, nil}, r0)
+	assert.NotNil(t, err)
+	assert.True(t, errors.Is(err, assert.AnError))
+	assert.True(t, errors.Is(err, errSentinel))
+	assert.Equal(t, assert.AnError.Error()+"\nwrapped: sentinel", err.Error())
+
+	// 全部成功时错误为 nil
+	r1, err := g.MapErr([]any{1, 3})
+	assert.Equal(t, []any{1, 3}, r1)
+	assert.Nil(t, err)
+
+	g.Stop()
+}
+
+// TestGroup_Name tests that the name set by WithName is exposed
+func TestGroup_Name(t *testing.T) {
+	g := k.NewGroup(k.NewConfig().WithName("batch"))
+	assert.Equal(t, "batch", g.Name())
+	g.Stop()
+
+	g = k.NewGroup(nil)
+	assert.Equal(t, "", g.Name())
+	g.Stop()
+}
+
+// TestGroup_NilConfig tests that a group created with a nil config maps elements with the default handler
+func TestGroup_NilConfig(t *testing.T) {
+	g := k.NewGroup(nil)
+	assert.NotNil(t, g)
+
+	// 默认处理函数原样返回输入
+	results, err := g.MapErr([]any{1, 2, 3})
+	assert.Nil(t, err)
+	assert.Equal(t, []any{1, 2, 3}, results)
+
+	g.Stop()
+}
+
+// TestGroup_MapTimeout_Partial tests that MapTimeout returns near the deadline with partial results
+func TestGroup_MapTimeout_Partial(t *testing.T) {
+	c := k.NewConfig()
+	c.WithContextHandleFunc(func(ctx context.Context, msg any) (any, error) {
+		// 奇数输入耗时很长
+		if msg.(int)%2 == 1 {
+			select {
+			case <-time.After(5 * time.Second):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		return msg, nil
+	}).WithWorkerNumber(8).WithResult()
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	input := []any{0, 1, 2, 3, 4, 5, 6, 7}
+	start := time.Now()
+	r0 := g.MapTimeout(input, 200*time.Millisecond)
+	elapsed := time.Since(start)
+
+	assert.GreaterOrEqual(t, elapsed, 200*time.Millisecond)
+	assert.Less(t, elapsed, time.Second)
+	assert.Equal(t, []any{0, nil, 2, nil, 4, nil, 6, nil}, r0)
+	g.Stop()
+}
+
+// TestGroup_StrictHandler tests that a group without a handler fails every element in the strict handler mode
+func TestGroup_StrictHandler(t *testing.T) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithResult().WithStrictHandler()
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	r0, err := g.MapErr([]any{1, 2, 3})
+	assert.Equal(t, []any{nil, nil, nil}, r0)
+	assert.ErrorIs(t, err, k.ErrNoHandler)
+
+	// 默认的宽松模式仍然原样返回输入
+	g2 := k.NewGroup(k.NewConfig().WithResult())
+	assert.Equal(t, []any{1, 2, 3}, g2.Map([]any{1, 2, 3}))
+
+	g.Stop()
+	g2.Stop()
+}
+
+// TestGroup_Durations tests that handler durations are recorded only when timing is enabled
+func TestGroup_Durations(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		time.Sleep(time.Duration(msg.(int)) * time.Millisecond)
+		return msg, nil
+	}).WithWorkerNumber(4).WithTiming()
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	g.Map([]any{10, 10, 10, 10, 10, 10, 10, 50})
+	d := g.Durations()
+	assert.Equal(t, int64(8), d.Count)
+	assert.GreaterOrEqual(t, d.Min, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, d.Max, 50*time.Millisecond)
+	assert.GreaterOrEqual(t, d.P50, d.Min)
+	assert.Less(t, d.P50, 50*time.Millisecond)
+	assert.LessOrEqual(t, d.P99, d.Max)
+	g.Stop()
+
+	// 未开启计时时返回零值
+	g2 := k.NewGroup(k.NewConfig())
+	g2.Map([]any{1, 2})
+	assert.Equal(t, k.DurationStats{}, g2.Durations())
+	g2.Stop()
+}
+
+// TestGroup_NewGroupWithPool tests that groups sharing a pool are bounded by the pool size
+func TestGroup_NewGroupWithPool(t *testing.T) {
+	pool := k.NewSharedPool(2)
+
+	var running, peak atomic.Int64
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+		return msg, nil
+	}).WithWorkerNumber(4).WithResult()
+
+	groups := make([]*k.Group, 5)
+	for i := range groups {
+		groups[i] = k.NewGroupWithPool(c, pool)
+	}
+
+	// 多个工作组并发调用，同时运行的处理函数数量不超过池的大小
+	var wg sync.WaitGroup
+	for _, g := range groups {
+		wg.Add(1)
+		go func(g *k.Group) {
+			defer wg.Done()
+			assert.Equal(t, []any{1, 2, 3, 4, 5, 6}, g.Map([]any{1, 2, 3, 4, 5, 6}))
+		}(g)
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, peak.Load(), int64(2))
+
+	// 停止工作组不会停止池
+	groups[0].Stop()
+	assert.Equal(t, []any{7}, groups[1].Map([]any{7}))
+	assert.Equal(t, []any{8, 9}, groups[1].Map([]any{8, 9}))
+
+	// 停止池后使用该池的工作组返回 nil
+	pool.Stop()
+	assert.Nil(t, groups[1].Map([]any{10, 11}))
+	for _, g := range groups {
+		g.Stop()
+	}
+}
+
+// failureRecorder is a callback recording failed messages in addition to counting OnBefore and OnAfter calls
+type failureRecorder struct {
+	countingCallback
+	lock   sync.Mutex
+	errors map[any]error
+}
+
+func (c *failureRecorder) OnError(msg any, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.errors == nil {
+		c.errors = make(map[any]error)
+	}
+	c.errors[msg] = err
+}
+
+// TestGroup_OnError tests that OnError is called only for elements completing with an error
+func TestGroup_OnError(t *testing.T) {
+	callback := &failureRecorder{}
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		if msg.(int)%2 == 1 {
+			return nil, errSentinel
+		}
+		return msg, nil
+	}).WithWorkerNumber(2).WithCallback(callback)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	g.Map([]any{0, 1, 2, 3, 4})
+	assert.Equal(t, int32(5), atomic.LoadInt32(&callback.after))
+	assert.Equal(t, map[any]error{1: errSentinel, 3: errSentinel}, callback.errors)
+	g.Stop()
+}
+
+// TestGroup_MapInto_ReusesBuffer tests that MapInto writes into the given buffer and reallocates only when it is too small
+func TestGroup_MapInto_ReusesBuffer(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(handleFunc).WithWorkerNumber(2)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	// 容量足够时复用缓冲区，不受 WithResult 影响
+	buf := make([]any, 0, 4)
+	r0 := g.MapInto(buf, []any{1, 2, 3})
+	assert.Equal(t, []any{1, 2, 3}, r0)
+	assert.Equal(t, &buf[:1][0], &r0[0])
+
+	// 较短的输入会截断缓冲区
+	r1 := g.MapInto(r0, []any{4})
+	assert.Equal(t, []any{4}, r1)
+	assert.Equal(t, &buf[:1][0], &r1[0])
+
+	// 容量不足时重新分配
+	r2 := g.MapInto(buf, []any{1, 2, 3, 4, 5})
+	assert.Equal(t, []any{1, 2, 3, 4, 5}, r2)
+	assert.NotEqual(t, &buf[:1][0], &r2[0])
+
+	assert.Nil(t, g.MapInto(buf, nil))
+	g.Stop()
+	assert.Nil(t, g.MapInto(buf, []any{1}))
+}
+
+// TestGroup_WithConcurrencyLimit tests that concurrent handler calls are bounded independently of the worker number
+func TestGroup_WithConcurrencyLimit(t *testing.T) {
+	var running, peak atomic.Int64
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+		running.Add(-1)
+		return msg, nil
+	}).WithWorkerNumber(16).WithConcurrencyLimit(3).WithResult()
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	input := make([]any, 64)
+	for i := range input {
+		input[i] = i
+	}
+	assert.Equal(t, input, g.Map(input))
+	assert.LessOrEqual(t, peak.Load(), int64(3))
+	assert.Equal(t, int64(3), peak.Load())
+	g.Stop()
+}
+
+// TestGroup_MapWithRetry_DeadLetter tests that MapWithRetry dead-letters elements failing every attempt
+func TestGroup_MapWithRetry_DeadLetter(t *testing.T) {
+	var lock sync.Mutex
+	letters := make(map[any]error)
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		if msg.(int)%2 == 1 {
+			return nil, errSentinel
+		}
+		return msg, nil
+	}).WithWorkerNumber(2).WithDeadLetter(func(msg any, lastErr error) {
+		lock.Lock()
+		defer lock.Unlock()
+		letters[msg] = lastErr
+	})
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	_, errs := g.MapWithRetry([]any{0, 1, 2, 3}, 2)
+	assert.Equal(t, []error{nil, errSentinel, nil, errSentinel}, errs)
+	assert.Equal(t, map[any]error{1: errSentinel, 3: errSentinel}, letters)
+	g.Stop()
+}
+
+// TestGroup_MapN tests that MapN returns the first n successful results and cancels the rest
+func TestGroup_MapN(t *testing.T) {
+	c := k.NewConfig()
+	c.WithContextHandleFunc(func(ctx context.Context, msg any) (any, error) {
+		// 负数输入失败，奇数输入耗时很长
+		switch v := msg.(int); {
+		case v < 0:
+			return nil, errSentinel
+		case v%2 == 1:
+			select {
+			case <-time.After(5 * time.Second):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		return msg, nil
+	}).WithWorkerNumber(8)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	start := time.Now()
+	r0 := g.MapN([]any{-1, 1, 0, 3, 2, -2, 4, 5}, 3)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.ElementsMatch(t, []any{0, 2, 4}, r0)
+
+	// 成功的元素不足 n 个时返回全部成功的结果
+	r1 := g.MapN([]any{-1, 0, -2, 2}, 3)
+	assert.ElementsMatch(t, []any{0, 2}, r1)
+
+	assert.Nil(t, g.MapN([]any{0, 2}, 0))
+	g.Stop()
+	assert.Nil(t, g.MapN([]any{0, 2}, 1))
+}
+
+// TestGroup_WithMiddleware tests that middleware wraps the handler in the order it is added
+func TestGroup_WithMiddleware(t *testing.T) {
+	tag := func(name string) k.Middleware {
+		return func(next k.MessageHandleFunc) k.MessageHandleFunc {
+			return func(msg any) (any, error) {
+				result, err := next(msg)
+				return fmt.Sprintf("%s(%v)", name, result), err
+			}
+		}
+	}
+
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithResult().WithMiddleware(tag("outer")).WithMiddleware(tag("inner"))
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	r0 := g.Map([]any{1, 2})
+	assert.Equal(t, []any{"outer(inner(1))", "outer(inner(2))"}, r0)
+	g.Stop()
+}
+
+// spanKey is the context key of the span started by the test tracer
+type spanKey struct{}
+
+// TestGroup_WithTracer tests that the tracer context reaches the handler and every span is ended with its outcome
+func TestGroup_WithTracer(t *testing.T) {
+	var lock sync.Mutex
+	ended := make(map[any]error)
+	c := k.NewConfig()
+	c.WithContextHandleFunc(func(ctx context.Context, msg any) (any, error) {
+		if msg.(int) < 0 {
+			return nil, errSentinel
+		}
+		return ctx.Value(spanKey{}), nil
+	}).WithTracer(func(ctx context.Context, msg any) (context.Context, func(err error)) {
+		return context.WithValue(ctx, spanKey{}, fmt.Sprintf("span-%v", msg)), func(err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			ended[msg] = err
+		}
+	}).WithWorkerNumber(2).WithResult()
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	r0 := g.Map([]any{1, -1, 2})
+	assert.Equal(t, []any{"span-1", nil, "span-2"}, r0)
+	assert.Equal(t, map[any]error{1: nil, -1: errSentinel, 2: nil}, ended)
+	g.Stop()
+}
+
+// TestGroup_WithTracer_NilEnd tests that a tracer without a span to end may return a nil end function
+func TestGroup_WithTracer_NilEnd(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(handleFunc).WithTracer(func(ctx context.Context, msg any) (context.Context, func(err error)) {
+		return ctx, nil
+	}).WithWorkerNumber(2)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	// 空的结束函数不会导致 panic
+	results, err := g.MapErr([]any{1, 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(results))
+	g.Stop()
+}
+
+// TestMapMap tests that results and errors stay associated with the keys of the input map
+func TestMapMap(t *testing.T) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(4)
+
+	in := map[string]int{"a": 1, "b": 2, "c": -3, "d": 4}
+	results, errs := k.MapMap(c, func(v int) (string, error) {
+		if v < 0 {
+			return "", errSentinel
+		}
+		// 较小的值耗时更长，使完成顺序与输入不同
+		time.Sleep(time.Duration(10-v) * time.Millisecond)
+		return strconv.Itoa(v * 10), nil
+	}, in)
+
+	assert.Equal(t, map[string]string{"a": "10", "b": "20", "d": "40"}, results)
+	assert.Equal(t, map[string]error{"c": errSentinel}, errs)
+
+	results, errs = k.MapMap(c, func(v int) (string, error) { return "", nil }, map[string]int{})
+	assert.Empty(t, results)
+	assert.Empty(t, errs)
+}
+
+// TestGroup_WithFailFast tests that the remaining tasks are cancelled on the first error and the group stays usable
+func TestGroup_WithFailFast(t *testing.T) {
+	var handled atomic.Int32
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		handled.Add(1)
+		if msg.(int) == 2 {
+			return nil, errSentinel
+		}
+		return msg, nil
+	}).WithSingleWorker().WithResult().WithFailFast()
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	// 单个工作者按顺序处理，出错之后的任务不会执行
+	r0, err := g.MapErr([]any{0, 1, 2, 3, 4})
+	assert.Equal(t, errSentinel, err)
+	assert.Equal(t, []any{0, 1, nil, nil, nil}, r0)
+	assert.Equal(t, int32(3), handled.Load())
+
+	r1 := g.Map([]any{2, 3, 4})
+	assert.Equal(t, []any{nil, nil, nil}, r1)
+
+	// 工作组没有被停止
+	r2 := g.Map([]any{3, 4})
+	assert.Equal(t, []any{3, 4}, r2)
+	g.Stop()
+}
+
+// TestGroup_MapKeyed tests that results are keyed by the caller-derived key and later inputs win on duplicate keys
+func TestGroup_MapKeyed(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		return msg.(string) + "!", nil
+	}).WithWorkerNumber(4)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	input := []any{"10:a", "30:b", "20:c", "30:d"}
+	r0 := g.MapKeyed(input, func(msg any) int64 {
+		id, _ := strconv.Atoi(strings.SplitN(msg.(string), ":", 2)[0])
+		return int64(id)
+	})
+	assert.Equal(t, map[int64]any{10: "10:a!", 20: "20:c!", 30: "30:d!"}, r0)
+
+	g.Stop()
+	assert.Nil(t, g.MapKeyed(input, func(msg any) int64 { return 0 }))
+}
+
+// TestGroup_MapIndexed tests that the input index is passed to the function and failed elements map to nil
+func TestGroup_MapIndexed(t *testing.T) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(4).WithChunkSize(2)
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	r0 := g.MapIndexed([]any{"a", "b", "c", "d", "e"}, func(i int, msg any) (any, error) {
+		if i == 3 {
+			return nil, errors.New("failed")
+		}
+		return strconv.Itoa(i) + msg.(string), nil
+	})
+	assert.Equal(t, []any{"0a", "1b", "2c", nil, "4e"}, r0)
+
+	g.Stop()
+	assert.Nil(t, g.MapIndexed([]any{"a"}, func(i int, msg any) (any, error) { return msg, nil }))
+}
+
+// TestGroup_WithFanOutHandler tests that the result of each input is the slice returned by the fan-out handler
+func TestGroup_WithFanOutHandler(t *testing.T) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithResult().WithFanOutHandler(func(msg any) ([]any, error) {
+		var items []any
+		for _, field := range strings.Fields(msg.(string)) {
+			items = append(items, field)
+		}
+		return items, nil
+	})
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+	defer g.Stop()
+
+	assert.Equal(t, []any{[]any{"a", "b"}, []any{"c"}}, g.Map([]any{"a b", "c"}))
+}
+
+// TestGroup_Start tests that the deprecated Start alias behaves like Map
+func TestGroup_Start(t *testing.T) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithResult()
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	assert.Equal(t, g.Map([]any{1, 2, 3}), g.Start([]any{1, 2, 3}))
+	g.Stop()
+}
+
+// TestGroup_MapContextSkipped tests that the inputs never started before ctx is done are returned in input order
+func TestGroup_MapContextSkipped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		// 处理到 2 时取消，之后的输入不会再执行
+		if msg.(int) == 2 {
+			cancel()
+		}
+		return msg, nil
+	}).WithSingleWorker().WithResult()
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	r0, skipped := g.MapContextSkipped(ctx, []any{0, 1, 2, 3, 4})
+	assert.Equal(t, []any{0, 1, 2, nil, nil}, r0)
+	assert.Equal(t, []any{3, 4}, skipped)
+
+	// 全部执行时没有被跳过的输入
+	r1, skipped := g.MapContextSkipped(context.Background(), []any{5, 6})
+	assert.Equal(t, []any{5, 6}, r1)
+	assert.Empty(t, skipped)
+	g.Stop()
+}
+
+// TestGroup_WithNonNilResult tests that empty inputs yield an empty slice while the group is running
+func TestGroup_WithNonNilResult(t *testing.T) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithResult().WithNonNilResult()
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	assert.Equal(t, []any{}, g.Map(nil))
+	assert.Equal(t, []any{}, g.Map([]any{}))
+	r0, err := g.MapErr([]any{})
+	assert.Equal(t, []any{}, r0)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{1}, g.Map([]any{1}))
+
+	// 默认仍然返回 nil
+	g2 := k.NewGroup(k.NewConfig().WithResult())
+	assert.Nil(t, g2.Map([]any{}))
+	g2.Stop()
+
+	// 停止后返回 nil
+	g.Stop()
+	assert.Nil(t, g.Map([]any{}))
+}
+
+// TestCallbackFunc tests that a callback built from functions calls only the supplied hooks
+func TestCallbackFunc(t *testing.T) {
+	var after atomic.Int32
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithCallback(k.CallbackFunc(nil, func(msg, result any, err error) {
+		after.Add(1)
+	}))
+
+	g := k.NewGroup(c)
+	assert.NotNil(t, g)
+
+	g.Map([]any{1, 2, 3})
+	assert.Equal(t, int32(3), after.Load())
+	g.Stop()
+
+	// 两个函数都为 nil 时不做任何事情
+	callback := k.CallbackFunc(nil, nil)
+	callback.OnBefore(1)
+	callback.OnAfter(1, nil, nil)
+}
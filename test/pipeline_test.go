@@ -1,7 +1,12 @@
 package test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -128,7 +133,9 @@ func TestPipeline_SubmitAfter_Basic(t *testing.T) {
 	err = pl.SubmitAfter(2, time.Second)
 	assert.Nil(t, err)
 
-	time.Sleep(2 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.Nil(t, pl.WaitIdle(ctx))
 
 	pl.Stop()
 }
@@ -148,7 +155,9 @@ func TestPipeline_SubmitAfter_WithCallback(t *testing.T) {
 	err = pl.SubmitAfter(2, time.Second)
 	assert.Nil(t, err)
 
-	time.Sleep(2 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.Nil(t, pl.WaitIdle(ctx))
 
 	pl.Stop()
 }
@@ -168,7 +177,9 @@ func TestPipeline_SubmitAfter_WithManyWorkers(t *testing.T) {
 	err = pl.SubmitAfter(2, time.Second)
 	assert.Nil(t, err)
 
-	time.Sleep(2 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.Nil(t, pl.WaitIdle(ctx))
 
 	pl.Stop()
 }
@@ -196,7 +207,9 @@ func TestPipeline_SubmitAfter_WithCustomHandler(t *testing.T) {
 	}, 3, time.Second)
 	assert.Nil(t, err)
 
-	time.Sleep(2 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.Nil(t, pl.WaitIdle(ctx))
 
 	pl.Stop()
 }
@@ -224,7 +237,9 @@ func TestPipeline_SubmitAfter_WithManyWorkersAndHandler(t *testing.T) {
 	}, 3, time.Second)
 	assert.Nil(t, err)
 
-	time.Sleep(2 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.Nil(t, pl.WaitIdle(ctx))
 
 	pl.Stop()
 }
@@ -426,3 +441,2512 @@ func TestPipeline_Stop_WhileProcessing(t *testing.T) {
 	// 立即停止，测试是否能正常处理
 	pl.Stop()
 }
+
+// TestPipeline_PoolStats_NoLeak tests that every pooled element is returned after the pipeline drains
+func TestPipeline_PoolStats_NoLeak(t *testing.T) {
+	c := k.NewConfig()
+	taskCount := 500
+
+	processed := int32(0)
+	c.WithHandleFunc(func(msg any) (any, error) {
+		atomic.AddInt32(&processed, 1)
+		return msg, nil
+	}).WithWorkerNumber(4)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	for i := 0; i < taskCount; i++ {
+		err := pl.Submit(i)
+		assert.Nil(t, err)
+	}
+
+	// 等待所有元素归还到对象池
+	for i := 0; i < 100; i++ {
+		if pl.PoolStats().Outstanding == 0 && atomic.LoadInt32(&processed) == int32(taskCount) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	stats := pl.PoolStats()
+	assert.Equal(t, int64(taskCount), stats.Gets)
+	assert.Equal(t, stats.Gets, stats.Puts)
+	assert.Equal(t, int64(0), stats.Outstanding)
+
+	// 对象池复用元素，新分配的数量不会超过获取的次数
+	assert.Greater(t, stats.Allocs, int64(0))
+	assert.LessOrEqual(t, stats.Allocs, stats.Gets)
+
+	pl.Stop()
+}
+
+// TestPipeline_Submit_WithWorkerSpawnRate tests that a tuned spawn rate lets workers spin up quickly
+func TestPipeline_Submit_WithWorkerSpawnRate(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		time.Sleep(200 * time.Millisecond)
+		return msg, nil
+	}).WithWorkerNumber(64).WithWorkerSpawnRate(1000, 64)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	for i := 0; i < 64; i++ {
+		err := pl.Submit(i)
+		assert.Nil(t, err)
+	}
+
+	// 默认的突发上限为 8，调整后应该远超该值
+	assert.Greater(t, pl.GetWorkerNumber(), int64(9))
+
+	pl.Stop()
+}
+
+// TestPipeline_Submit_WithTaskWeight tests that heavy tasks use up the spawn budget faster
+func TestPipeline_Submit_WithTaskWeight(t *testing.T) {
+	release := make(chan struct{})
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		<-release
+		return msg, nil
+	}).WithWorkerNumber(64).WithWorkerSpawnRate(0.001, 8).WithTaskWeight(func(msg any) int {
+		return 4
+	})
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	for i := 0; i < 16; i++ {
+		assert.Nil(t, pl.Submit(i))
+	}
+
+	// 突发上限 8 只够两个权重为 4 的任务创建工作协程，加上初始的工作协程共 3 个
+	assert.Equal(t, int64(3), pl.GetWorkerNumber())
+
+	close(release)
+	pl.Stop()
+}
+
+// TestPipeline_Submit_WithInvalidWorkerSpawnRate tests that invalid spawn rate values fall back to defaults
+func TestPipeline_Submit_WithInvalidWorkerSpawnRate(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		time.Sleep(200 * time.Millisecond)
+		return msg, nil
+	}).WithWorkerNumber(64).WithWorkerSpawnRate(0, -1)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	for i := 0; i < 64; i++ {
+		err := pl.Submit(i)
+		assert.Nil(t, err)
+	}
+
+	// 回退到默认值后，工作协程数量受默认突发上限约束
+	assert.LessOrEqual(t, pl.GetWorkerNumber(), int64(10))
+
+	pl.Stop()
+}
+
+// TestPipeline_Submit_WithTaskTimeout tests that a hanging handler is reported as timed out
+func TestPipeline_Submit_WithTaskTimeout(t *testing.T) {
+	recorder := &errorRecorder{}
+	c := k.NewConfig()
+	c.WithHandleFunc(handleFunc).WithWorkerNumber(2).
+		WithTaskTimeout(300 * time.Millisecond).WithCallback(recorder)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	err := pl.Submit(20)
+	assert.Nil(t, err)
+
+	// 等待超时的任务完成回调
+	time.Sleep(time.Second)
+
+	err, ok := recorder.Get(20)
+	assert.True(t, ok)
+	assert.Equal(t, k.ErrTaskTimeout, err)
+
+	pl.Stop()
+}
+
+// TestPipeline_Submit_WithRetry tests that a failing task is retried until it succeeds
+func TestPipeline_Submit_WithRetry(t *testing.T) {
+	recorder := &errorRecorder{}
+	attempts := int32(0)
+
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		// 前两次尝试失败，第三次成功
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			return nil, assert.AnError
+		}
+		return msg, nil
+	}).WithWorkerNumber(2).WithRetry(3, 50*time.Millisecond).WithCallback(recorder)
+	queue := wkq.NewDelayingQueue(nil)
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	err := pl.Submit(1)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		if _, ok := recorder.Get(1); ok {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	err, ok := recorder.Get(1)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+
+	pl.Stop()
+}
+
+// TestPipeline_Submit_WithRetryExhausted tests that the final error is delivered once attempts are exhausted
+func TestPipeline_Submit_WithRetryExhausted(t *testing.T) {
+	recorder := &errorRecorder{}
+	attempts := int32(0)
+
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		atomic.AddInt32(&attempts, 1)
+		return nil, assert.AnError
+	}).WithWorkerNumber(2).WithRetry(3, 0).WithCallback(recorder)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	err := pl.Submit(1)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		if _, ok := recorder.Get(1); ok {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	err, ok := recorder.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, assert.AnError, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+
+	pl.Stop()
+}
+
+// TestPipeline_ResultCollector_Ordered tests that collected results are returned in submission order
+func TestPipeline_ResultCollector_Ordered(t *testing.T) {
+	collector := k.NewResultCollector()
+	c := k.NewConfig()
+	c.WithHandleFunc(handleFunc).WithWorkerNumber(4).WithWorkerSpawnRate(100, 4).WithResultCollector(collector)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	// 处理时长不同的任务，完成顺序与提交顺序不同
+	inputs := []int{5, 1, 3, 0, 4, 2}
+	for _, v := range inputs {
+		err := pl.Submit(v)
+		assert.Nil(t, err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if len(collector.OrderedResults()) == len(inputs) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	results := collector.OrderedResults()
+	assert.Equal(t, len(inputs), len(results))
+	for i, v := range inputs {
+		assert.Equal(t, v, results[i].Input)
+		assert.Equal(t, v, results[i].Output)
+		assert.Nil(t, results[i].Err)
+	}
+
+	pl.Stop()
+}
+
+// TestPipeline_Submit_WithPanicHandler tests that a panicking handler does not stop the pipeline
+func TestPipeline_Submit_WithPanicHandler(t *testing.T) {
+	recorder := &errorRecorder{}
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithCallback(recorder)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	err := pl.SubmitWithFunc(func(msg any) (any, error) {
+		panic("boom")
+	}, 1)
+	assert.Nil(t, err)
+
+	// 后续任务应该继续被处理
+	for i := 2; i <= 5; i++ {
+		err = pl.Submit(i)
+		assert.Nil(t, err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if _, ok := recorder.Get(5); ok {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	err, ok := recorder.Get(1)
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, k.ErrHandlerPanic)
+	for i := 2; i <= 5; i++ {
+		err, ok = recorder.Get(i)
+		assert.True(t, ok)
+		assert.Nil(t, err)
+	}
+
+	pl.Stop()
+}
+
+// TestPipeline_Submit_WithPanicHandler_ReleasesElements tests that elements of panicking tasks are returned to the pool
+func TestPipeline_Submit_WithPanicHandler_ReleasesElements(t *testing.T) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithHandleFunc(func(msg any) (any, error) {
+		if msg.(int)%2 == 0 {
+			panic("boom")
+		}
+		return msg, nil
+	})
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, pl.Submit(i))
+	}
+	assert.Nil(t, pl.WaitIdle(context.Background()))
+
+	// 发生 panic 的任务同样归还元素，工作协程继续运行
+	assert.Equal(t, int64(0), pl.PoolStats().Outstanding)
+	assert.Equal(t, int64(100), pl.Stats().Processed)
+	assert.Equal(t, int64(50), pl.Stats().Failed)
+	assert.Greater(t, pl.GetWorkerNumber(), int64(0))
+
+	pl.Stop()
+}
+
+// TestPipeline_Submit_WithPanicCallback tests that OnPanic is called for a panicking handler
+func TestPipeline_Submit_WithPanicCallback(t *testing.T) {
+	recorder := &panicRecorder{}
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithCallback(recorder)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	err := pl.SubmitWithFunc(func(msg any) (any, error) {
+		panic("boom")
+	}, 1)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		if _, ok := recorder.Get(1); ok {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	stack, ok := recorder.panics.Load(1)
+	assert.True(t, ok)
+	assert.NotEmpty(t, stack)
+
+	pl.Stop()
+}
+
+// chunkRecorder is a callback recording the chunks delivered to OnChunk
+type chunkRecorder struct {
+	lock   sync.Mutex
+	chunks []any
+	done   chan int
+}
+
+func (r *chunkRecorder) OnBefore(msg any) {}
+
+func (r *chunkRecorder) OnChunk(msg any, chunk any) {
+	r.lock.Lock()
+	r.chunks = append(r.chunks, chunk)
+	r.lock.Unlock()
+}
+
+func (r *chunkRecorder) OnAfter(msg, result any, err error) {
+	r.lock.Lock()
+	count := len(r.chunks)
+	r.lock.Unlock()
+	r.done <- count
+}
+
+// TestPipeline_Submit_WithChunkCallback tests that chunks are streamed in order before the task completes
+func TestPipeline_Submit_WithChunkCallback(t *testing.T) {
+	recorder := &chunkRecorder{done: make(chan int, 1)}
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		chunks := make(chan any)
+		go func() {
+			defer close(chunks)
+			for i := 0; i < msg.(int); i++ {
+				chunks <- i
+			}
+		}()
+		return (<-chan any)(chunks), nil
+	}).WithWorkerNumber(2).WithCallback(recorder)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	err := pl.Submit(100)
+	assert.Nil(t, err)
+
+	select {
+	case count := <-recorder.done:
+		// 任务完成前应该已经收到全部部分结果
+		assert.Equal(t, 100, count)
+	case <-time.After(5 * time.Second):
+		t.Fatal("task did not complete")
+	}
+
+	recorder.lock.Lock()
+	for i, chunk := range recorder.chunks {
+		assert.Equal(t, i, chunk)
+	}
+	recorder.lock.Unlock()
+
+	pl.Stop()
+}
+
+// TestPipeline_Stats tests the running state reported by Stats
+func TestPipeline_Stats(t *testing.T) {
+	c := k.NewConfig()
+	taskCount := 100
+	c.WithHandleFunc(func(msg any) (any, error) {
+		return msg, nil
+	}).WithWorkerNumber(4)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	for i := 0; i < taskCount; i++ {
+		err := pl.Submit(i)
+		assert.Nil(t, err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if pl.Stats().Processed == int64(taskCount) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	stats := pl.Stats()
+	assert.Equal(t, int64(taskCount), stats.Processed)
+	assert.Equal(t, int64(0), stats.Pending)
+	assert.GreaterOrEqual(t, stats.Workers, int64(1))
+
+	// 结构体应该可以序列化为 JSON
+	data, err := json.Marshal(stats)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"processed":100`)
+	assert.Contains(t, string(data), `"pending":0`)
+
+	pl.Stop()
+}
+
+// ackRecordingQueue is a queue recording whether the handler had returned when Done was called
+type ackRecordingQueue struct {
+	*k.FakeDelayingQueue
+	returned   *int32
+	ackedAfter int32
+	acked      int32
+}
+
+func (q *ackRecordingQueue) Done(value interface{}) {
+	atomic.StoreInt32(&q.ackedAfter, atomic.LoadInt32(q.returned))
+	atomic.AddInt32(&q.acked, 1)
+	q.FakeDelayingQueue.Done(value)
+}
+
+// TestPipeline_Submit_WithAckMode tests that Done is called after the handler returns in AckAfter mode
+func TestPipeline_Submit_WithAckMode(t *testing.T) {
+	for _, mode := range []k.AckMode{k.AckBefore, k.AckAfter} {
+		returned := int32(0)
+		queue := &ackRecordingQueue{FakeDelayingQueue: k.NewFakeDelayingQueue(wkq.NewQueue(nil)), returned: &returned}
+
+		c := k.NewConfig()
+		c.WithHandleFunc(func(msg any) (any, error) {
+			time.Sleep(100 * time.Millisecond)
+			atomic.StoreInt32(&returned, 1)
+			return msg, nil
+		}).WithWorkerNumber(2).WithAckMode(mode)
+
+		pl := k.NewPipeline(queue, c)
+		assert.NotNil(t, pl)
+
+		err := pl.Submit(1)
+		assert.Nil(t, err)
+
+		for i := 0; i < 100; i++ {
+			if pl.Stats().Processed == 1 {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&queue.acked))
+		if mode == k.AckAfter {
+			assert.Equal(t, int32(1), atomic.LoadInt32(&queue.ackedAfter))
+		} else {
+			assert.Equal(t, int32(0), atomic.LoadInt32(&queue.ackedAfter))
+		}
+
+		pl.Stop()
+	}
+}
+
+// TestPipeline_WithGetMode tests that an idle worker polls the queue at the poll interval in the GetPolling mode
+func TestPipeline_WithGetMode(t *testing.T) {
+	c := k.NewConfig()
+	c.WithSingleWorker().WithGetMode(k.GetPolling).WithGetPollInterval(5 * time.Millisecond)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+	defer pl.Stop()
+
+	// 唯一的工作协程在空队列上等待后，新任务在下一次轮询时被处理，而不是等到下一次扫描
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, pl.Submit(1))
+	assert.Eventually(t, func() bool { return pl.Stats().Processed == 1 }, time.Second, 5*time.Millisecond)
+}
+
+// TestPipeline_StopAndDrain_Basic tests that queued tasks all run before the pipeline stops
+func TestPipeline_StopAndDrain_Basic(t *testing.T) {
+	counter := &countingCallback{}
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		time.Sleep(50 * time.Millisecond)
+		return msg, nil
+	}).WithWorkerNumber(2).WithCallback(counter)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	for i := 0; i < 10; i++ {
+		err := pl.Submit(i)
+		assert.Nil(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := pl.StopAndDrain(ctx)
+	assert.Nil(t, err)
+
+	assert.Equal(t, int64(10), pl.Stats().Processed)
+	assert.Equal(t, int32(10), atomic.LoadInt32(&counter.before))
+	assert.Equal(t, int32(10), atomic.LoadInt32(&counter.after))
+
+	// 排空后不再接收新任务
+	err = pl.Submit(1)
+	assert.Equal(t, k.ErrorQueueClosed, err)
+}
+
+// TestPipeline_StopAndDrain_Timeout tests that draining reports pending tasks when the context expires
+func TestPipeline_StopAndDrain_Timeout(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		time.Sleep(200 * time.Millisecond)
+		return msg, nil
+	}).WithWorkerNumber(2).WithWorkerSpawnRate(1, 1)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	for i := 0; i < 20; i++ {
+		err := pl.Submit(i)
+		assert.Nil(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	err := pl.StopAndDrain(ctx)
+	assert.Equal(t, k.ErrDrainTimeout, err)
+	assert.Less(t, pl.Stats().Processed, int64(20))
+}
+
+// pairingRecorder is a callback recording errors and counting the OnBefore and OnAfter calls of every message
+type pairingRecorder struct {
+	errorRecorder
+	before sync.Map
+	after  sync.Map
+}
+
+func (r *pairingRecorder) OnBefore(msg any) {
+	n, _ := r.before.LoadOrStore(msg, new(atomic.Int32))
+	n.(*atomic.Int32).Add(1)
+}
+
+func (r *pairingRecorder) OnAfter(msg, result any, err error) {
+	n, _ := r.after.LoadOrStore(msg, new(atomic.Int32))
+	n.(*atomic.Int32).Add(1)
+	r.errorRecorder.OnAfter(msg, result, err)
+}
+
+func (r *pairingRecorder) Counts(msg any) (before, after int32) {
+	if n, ok := r.before.Load(msg); ok {
+		before = n.(*atomic.Int32).Load()
+	}
+	if n, ok := r.after.Load(msg); ok {
+		after = n.(*atomic.Int32).Load()
+	}
+	return before, after
+}
+
+// TestPipeline_SubmitWithPriority_Preemption tests that a high-priority task preempts and re-queues a low-priority one
+func TestPipeline_SubmitWithPriority_Preemption(t *testing.T) {
+	var calls sync.Map
+	started := make(chan struct{}, 2)
+	recorder := &pairingRecorder{}
+
+	c := k.NewConfig()
+	c.WithContextHandleFunc(func(ctx context.Context, msg any) (any, error) {
+		count, _ := calls.LoadOrStore(msg, new(int32))
+		n := atomic.AddInt32(count.(*int32), 1)
+		if msg == "high" || n > 1 {
+			return msg, nil
+		}
+
+		// 低优先级任务首次执行时阻塞，直到被抢占或超时
+		started <- struct{}{}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(2 * time.Second):
+			return msg, nil
+		}
+	}).WithWorkerNumber(2).WithWorkerSpawnRate(100, 2).WithPreemption(true).WithCallback(recorder)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	// 使用低优先级任务占满所有工作协程
+	assert.Nil(t, pl.SubmitWithPriority("low-1", 0))
+	assert.Nil(t, pl.SubmitWithPriority("low-2", 0))
+	<-started
+	<-started
+
+	assert.Nil(t, pl.SubmitWithPriority("high", 10))
+
+	for i := 0; i < 100; i++ {
+		if _, ok := recorder.Get("high"); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 高优先级任务应该在低优先级任务超时之前完成
+	err, ok := recorder.Get("high")
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	// 等待被抢占的任务重新执行完成
+	for i := 0; i < 100; i++ {
+		if pl.Stats().Processed >= 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 其中一个低优先级任务被抢占并重新入队执行，重新入队不会再次调用 OnBefore
+	preempted := 0
+	for _, msg := range []string{"low-1", "low-2"} {
+		count, _ := calls.Load(msg)
+		before, after := recorder.Counts(msg)
+		assert.Equal(t, int32(1), before)
+		if atomic.LoadInt32(count.(*int32)) == 2 {
+			preempted++
+			err, ok := recorder.Get(msg)
+			assert.True(t, ok)
+			assert.Nil(t, err)
+			assert.Equal(t, int32(1), after)
+		}
+	}
+	assert.Equal(t, 1, preempted)
+
+	pl.Stop()
+}
+
+// TestPipeline_NewPipelineWithError tests the errors returned by NewPipelineWithError
+func TestPipeline_NewPipelineWithError(t *testing.T) {
+	pl, err := k.NewPipelineWithError(nil, k.NewConfig())
+	assert.Nil(t, pl)
+	assert.Equal(t, k.ErrNilQueue, err)
+
+	// 无效的工作者数量返回错误而不是被静默修正
+	c := k.NewConfig().WithWorkerNumber(-1)
+	pl, err = k.NewPipelineWithError(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), c)
+	assert.Nil(t, pl)
+	assert.Equal(t, k.ErrInvalidWorkerNumber, err)
+
+	pl, err = k.NewPipelineWithError(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, pl)
+	pl.Stop()
+
+	// NewPipeline 保持兼容行为
+	assert.Nil(t, k.NewPipeline(nil, k.NewConfig()))
+	pl = k.NewPipeline(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), k.NewConfig().WithWorkerNumber(-1))
+	assert.NotNil(t, pl)
+	pl.Stop()
+}
+
+// TestPipeline_SubmitBatch tests batch submission
+func TestPipeline_SubmitBatch(t *testing.T) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(4)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	msgs := make([]any, 1000)
+	for i := range msgs {
+		msgs[i] = i
+	}
+
+	submitted, err := pl.SubmitBatch(msgs)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, submitted)
+
+	for i := 0; i < 100; i++ {
+		if pl.Stats().Processed == 1000 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, int64(1000), pl.Stats().Processed)
+
+	pl.Stop()
+
+	// 管道关闭后立即停止
+	submitted, err = pl.SubmitBatch(msgs)
+	assert.Equal(t, 0, submitted)
+	assert.Equal(t, k.ErrorQueueClosed, err)
+}
+
+// TestPipeline_SubmitBatchAfter tests delayed batch submission
+func TestPipeline_SubmitBatchAfter(t *testing.T) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(4)
+	queue := wkq.NewDelayingQueue(nil)
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	submitted, err := pl.SubmitBatchAfter([]any{1, 2, 3}, 200*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, submitted)
+	assert.Equal(t, int64(3), pl.Stats().Pending)
+
+	pl.Stop()
+}
+
+// TestPipeline_SubmitAll tests that SubmitAll stops at the first message failing to enqueue
+func TestPipeline_SubmitAll(t *testing.T) {
+	release := make(chan struct{})
+	c := k.NewConfig()
+	c.WithWorkerNumber(4).WithHandleFunc(func(msg any) (any, error) {
+		<-release
+		return msg, nil
+	}).WithDedup(func(msg any) string { return fmt.Sprint(msg) })
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	// 第三个消息重复，之后的消息不再提交
+	submitted, err := pl.SubmitAll([]any{1, 2, 1, 3})
+	assert.Equal(t, 2, submitted)
+	assert.Equal(t, k.ErrDuplicate, err)
+	assert.Equal(t, int64(2), pl.Stats().Pending)
+
+	// SubmitBatch 则会跳过失败的消息继续提交
+	submitted, err = pl.SubmitBatch([]any{1, 3})
+	assert.Equal(t, 1, submitted)
+	assert.Equal(t, k.ErrDuplicate, err)
+
+	close(release)
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+	submitted, err = pl.SubmitAll([]any{4})
+	assert.Equal(t, 0, submitted)
+	assert.Equal(t, k.ErrorQueueClosed, err)
+}
+
+// recordingLogger is a logger recording every formatted line by level
+type recordingLogger struct {
+	lock   sync.Mutex
+	debugs []string
+	warns  []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...any) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.debugs = append(l.debugs, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...any) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.warns = append(l.warns, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) counts() (int, int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return len(l.debugs), len(l.warns)
+}
+
+// TestPipeline_WithLogger tests that worker spawns and closed submissions are logged
+func TestPipeline_WithLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	c := k.NewConfig()
+	c.WithWorkerNumber(4).WithLogger(logger)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, pl.Submit(i))
+	}
+	pl.Stop()
+
+	debugs, warns := logger.counts()
+	assert.Greater(t, debugs, 0)
+	assert.Equal(t, 0, warns)
+
+	assert.Equal(t, k.ErrorQueueClosed, pl.Submit(1))
+	_, warns = logger.counts()
+	assert.Equal(t, 1, warns)
+}
+
+// TestPipeline_MaxPending tests that Submit is rejected once the pending limit is reached and SubmitBlocking waits
+func TestPipeline_MaxPending(t *testing.T) {
+	release := make(chan struct{})
+	var handled atomic.Int32
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithMaxPending(3).WithHandleFunc(func(msg any) (any, error) {
+		<-release
+		handled.Add(1)
+		return msg, nil
+	})
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, pl.Submit(i))
+	}
+	assert.Equal(t, k.ErrQueueFull, pl.Submit(3))
+	assert.Equal(t, int64(3), pl.Stats().Pending)
+
+	// 释放处理函数后阻塞提交成功
+	done := make(chan error, 1)
+	go func() { done <- pl.SubmitBlocking(4) }()
+	select {
+	case <-done:
+		t.Fatal("SubmitBlocking returned while the pipeline was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	assert.Nil(t, <-done)
+
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+	assert.Equal(t, int32(4), handled.Load())
+	assert.Equal(t, k.ErrorQueueClosed, pl.SubmitBlocking(5))
+}
+
+// TestPipeline_SubmitContext tests that SubmitContext gives up once ctx is done while waiting and binds ctx to the task
+func TestPipeline_SubmitContext(t *testing.T) {
+	release := make(chan struct{})
+	recorder := &errorRecorder{}
+	c := k.NewConfig()
+	c.WithSingleWorker().WithMaxPending(1).WithCallback(recorder).WithContextHandleFunc(func(ctx context.Context, msg any) (any, error) {
+		if msg == 2 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		<-release
+		return msg, nil
+	})
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	// 管道已满时提交在 ctx 超时后放弃
+	assert.Nil(t, pl.Submit(0))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, pl.SubmitContext(ctx, 1))
+
+	close(release)
+
+	// 入队后的任务携带提交时的 ctx
+	taskCtx, cancelTask := context.WithCancel(context.Background())
+	assert.Nil(t, pl.SubmitContext(taskCtx, 2))
+	cancelTask()
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+	err, ok := recorder.Get(2)
+	assert.True(t, ok)
+	assert.Equal(t, context.Canceled, err)
+	_, ok = recorder.Get(1)
+	assert.False(t, ok)
+}
+
+// submitRecorder is a callback recording accepted messages in addition to counting OnBefore and OnAfter calls
+type submitRecorder struct {
+	countingCallback
+	submitted sync.Map
+}
+
+func (c *submitRecorder) OnSubmit(msg any) { c.submitted.Store(msg, true) }
+
+// TestPipeline_OnSubmit tests that OnSubmit is called for accepted tasks only
+func TestPipeline_OnSubmit(t *testing.T) {
+	recorder := &submitRecorder{}
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithMaxPending(2).WithCallback(recorder).WithHandleFunc(func(msg any) (any, error) {
+		time.Sleep(50 * time.Millisecond)
+		return msg, nil
+	})
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	assert.Nil(t, pl.Submit(1))
+	assert.Nil(t, pl.Submit(2))
+	assert.Equal(t, k.ErrQueueFull, pl.Submit(3))
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+	_, ok := recorder.submitted.Load(1)
+	assert.True(t, ok)
+	_, ok = recorder.submitted.Load(2)
+	assert.True(t, ok)
+	_, ok = recorder.submitted.Load(3)
+	assert.False(t, ok)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&recorder.after))
+}
+
+// TestPipeline_SetMaxWorkers tests raising and lowering the worker ceiling at runtime
+func TestPipeline_SetMaxWorkers(t *testing.T) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithHandleFunc(func(msg any) (any, error) {
+		time.Sleep(200 * time.Millisecond)
+		return msg, nil
+	})
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+	assert.Equal(t, int64(2), pl.GetMaxWorkers())
+
+	// 提高上限后可以创建更多的工作协程
+	pl.SetMaxWorkers(6)
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, pl.Submit(i))
+	}
+	assert.Greater(t, pl.GetWorkerNumber(), int64(2))
+	assert.LessOrEqual(t, pl.GetWorkerNumber(), int64(6))
+
+	// 降低上限后多余的空闲工作协程在扫描时退出
+	pl.SetMaxWorkers(1)
+	for i := 0; i < 100; i++ {
+		if pl.GetWorkerNumber() <= 1 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, pl.GetWorkerNumber(), int64(1))
+
+	// 超出范围的值被限制
+	pl.SetMaxWorkers(0)
+	assert.Equal(t, int64(1), pl.GetMaxWorkers())
+	pl.SetMaxWorkers(math.MaxInt32)
+	assert.Less(t, pl.GetMaxWorkers(), int64(math.MaxInt32))
+
+	pl.Stop()
+}
+
+// TestSetGlobalWorkerLimit tests that pipelines share the global worker limit and each of them keeps making progress
+func TestSetGlobalWorkerLimit(t *testing.T) {
+	k.SetGlobalWorkerLimit(4)
+	defer k.SetGlobalWorkerLimit(0)
+
+	var running, peak int32
+	handle := func(msg any) (any, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if n <= old || atomic.CompareAndSwapInt32(&peak, old, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return msg, nil
+	}
+
+	pipelines := make([]*k.Pipeline, 2)
+	for i := range pipelines {
+		c := k.NewConfig()
+		c.WithWorkerNumber(10).WithWorkerSpawnRate(1000, 100).WithGetMode(k.GetPolling).WithHandleFunc(handle)
+		pipelines[i] = k.NewPipeline(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), c)
+		assert.NotNil(t, pipelines[i])
+	}
+
+	// 每个管道最多使用其公平份额，即限制的一半
+	for i := 0; i < 12; i++ {
+		for _, pl := range pipelines {
+			assert.Nil(t, pl.Submit(i))
+		}
+	}
+	for _, pl := range pipelines {
+		assert.LessOrEqual(t, pl.GetWorkerNumber(), int64(2))
+	}
+
+	for _, pl := range pipelines {
+		assert.Nil(t, pl.StopAndDrain(context.Background()))
+		assert.Equal(t, int64(12), pl.Stats().Processed)
+	}
+	assert.LessOrEqual(t, atomic.LoadInt32(&peak), int32(4))
+}
+
+// TestPipeline_PriorityQueue tests that a PriorityQueue handles higher priorities first and equal priorities in FIFO order
+func TestPipeline_PriorityQueue(t *testing.T) {
+	var lock sync.Mutex
+	order := make([]any, 0)
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithHandleFunc(func(msg any) (any, error) {
+		if msg == "block" {
+			close(started)
+			<-release
+			return msg, nil
+		}
+		lock.Lock()
+		order = append(order, msg)
+		lock.Unlock()
+		return msg, nil
+	})
+	queue := k.NewPriorityQueue()
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+	pl.SetMaxWorkers(1)
+
+	// 占用唯一的工作协程，使后续任务在队列中排序
+	assert.Nil(t, pl.Submit("block"))
+	<-started
+
+	assert.Nil(t, pl.SubmitWithPriority("low", 1))
+	assert.Nil(t, pl.SubmitWithPriority("high-1", 10))
+	assert.Nil(t, pl.Submit("none"))
+	assert.Nil(t, pl.SubmitWithPriority("high-2", 10))
+	assert.Nil(t, pl.SubmitWithPriority("mid", 5))
+	assert.Equal(t, 5, queue.Len())
+
+	close(release)
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+	assert.Equal(t, []any{"high-1", "high-2", "mid", "low", "none"}, order)
+	assert.True(t, queue.IsClosed())
+	assert.Equal(t, k.ErrorQueueClosed, queue.Put(1))
+}
+
+// TestPipeline_PriorityQueue_Polling tests that an idle worker picks up new tasks from a PriorityQueue without waiting for the next scan
+func TestPipeline_PriorityQueue_Polling(t *testing.T) {
+	handled := make(chan any, 1)
+	c := k.NewConfig()
+	c.WithSingleWorker().WithGetMode(k.GetPolling).WithGetPollInterval(5 * time.Millisecond).WithHandleFunc(func(msg any) (any, error) {
+		handled <- msg
+		return msg, nil
+	})
+	queue := k.NewPriorityQueue()
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	// 启动唯一的工作协程并等待其空闲
+	assert.Nil(t, pl.Submit(1))
+	assert.Equal(t, 1, <-handled)
+	time.Sleep(20 * time.Millisecond)
+
+	// 空闲的工作协程在轮询间隔内取出新任务，而不是等待下一次状态扫描
+	start := time.Now()
+	assert.Nil(t, pl.SubmitWithPriority(2, 1))
+	assert.Equal(t, 2, <-handled)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// 停止后丢弃尚未到期的延迟任务，不再计入待完成数量
+	assert.Nil(t, pl.SubmitAfter(3, time.Minute))
+	assert.Equal(t, int64(1), pl.Stats().Pending)
+	pl.Stop()
+	assert.Equal(t, int64(0), pl.Stats().Pending)
+}
+
+// sinkRecorder is a sink recording the size of every batch and the inputs it received
+type sinkRecorder struct {
+	lock   sync.Mutex
+	sizes  []int
+	inputs []any
+}
+
+func (r *sinkRecorder) Sink(batch []k.TaskResult) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.sizes = append(r.sizes, len(batch))
+	for _, result := range batch {
+		r.inputs = append(r.inputs, result.Input)
+	}
+	return nil
+}
+
+func (r *sinkRecorder) Inputs() []any {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]any(nil), r.inputs...)
+}
+
+// TestPipeline_WithSink tests that results are flushed in full batches, on the flush interval and on stop
+func TestPipeline_WithSink(t *testing.T) {
+	// 批次已满时输出，停止时输出最后未满的批次
+	full := &sinkRecorder{}
+	c0 := k.NewConfig()
+	c0.WithWorkerNumber(2).WithSink(full.Sink, 3, 0)
+	pl0 := k.NewPipeline(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), c0)
+	for i := 0; i < 7; i++ {
+		assert.Nil(t, pl0.Submit(i))
+	}
+	assert.Nil(t, pl0.StopAndDrain(context.Background()))
+	assert.Equal(t, []int{3, 3, 1}, full.sizes)
+	assert.ElementsMatch(t, []any{0, 1, 2, 3, 4, 5, 6}, full.Inputs())
+
+	// 未满的批次按间隔输出
+	timed := &sinkRecorder{}
+	c1 := k.NewConfig()
+	c1.WithWorkerNumber(2).WithSink(timed.Sink, 10, 20*time.Millisecond)
+	pl1 := k.NewPipeline(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), c1)
+	defer pl1.Stop()
+	assert.Nil(t, pl1.Submit(1))
+	assert.Nil(t, pl1.Submit(2))
+	assert.Eventually(t, func() bool { return len(timed.Inputs()) == 2 }, time.Second, 10*time.Millisecond)
+}
+
+// TestPipeline_WithResultChannel tests result delivery in dropping and blocking modes
+func TestPipeline_WithResultChannel(t *testing.T) {
+	// 通道已满时丢弃结果
+	dropped := make(chan k.TaskResult, 1)
+	c0 := k.NewConfig()
+	c0.WithWorkerNumber(2).WithResultChannel(dropped)
+	pl0 := k.NewPipeline(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), c0)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, pl0.Submit(i))
+	}
+	assert.Nil(t, pl0.StopAndDrain(context.Background()))
+	assert.Equal(t, int64(5), pl0.Stats().Processed)
+	assert.Equal(t, 1, len(dropped))
+
+	// 阻塞模式下不丢失结果
+	blocking := make(chan k.TaskResult)
+	c1 := k.NewConfig()
+	c1.WithWorkerNumber(2).WithResultChannel(blocking).WithResultChannelBlocking().WithHandleFunc(func(msg any) (any, error) {
+		if msg.(int)%2 == 1 {
+			return nil, assert.AnError
+		}
+		return msg.(int) * 10, nil
+	})
+	pl1 := k.NewPipeline(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), c1)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, pl1.Submit(i))
+	}
+
+	received := make(map[any]k.TaskResult)
+	for i := 0; i < 5; i++ {
+		r := <-blocking
+		received[r.Input] = r
+	}
+	pl1.Stop()
+
+	for i := 0; i < 5; i++ {
+		if i%2 == 1 {
+			assert.Equal(t, assert.AnError, received[i].Err)
+		} else {
+			assert.Equal(t, i*10, received[i].Output)
+		}
+	}
+}
+
+// TestPipeline_StopWithTimeout tests that StopWithTimeout gives up on a handler running longer than the timeout
+func TestPipeline_StopWithTimeout(t *testing.T) {
+	started := make(chan struct{})
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithHandleFunc(func(msg any) (any, error) {
+		close(started)
+		time.Sleep(500 * time.Millisecond)
+		return msg, nil
+	})
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+	assert.Nil(t, pl.Submit(1))
+	<-started
+
+	start := time.Now()
+	assert.Equal(t, k.ErrStopTimeout, pl.StopWithTimeout(50*time.Millisecond))
+	assert.Less(t, time.Since(start), 400*time.Millisecond)
+	assert.Equal(t, k.ErrorQueueClosed, pl.Submit(2))
+
+	// 卡住的工作协程结束后再次停止立即成功
+	time.Sleep(600 * time.Millisecond)
+	assert.Nil(t, pl.StopWithTimeout(50*time.Millisecond))
+	assert.True(t, queue.IsClosed())
+}
+
+// TestPipeline_WaitIdle tests that WaitIdle returns once all submitted tasks have completed
+func TestPipeline_WaitIdle(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(handleFunc).WithWorkerNumber(2)
+	queue := wkq.NewDelayingQueue(nil)
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	// 空闲的管道立即返回
+	assert.Nil(t, pl.WaitIdle(context.Background()))
+
+	assert.Nil(t, pl.Submit(1))
+	assert.Nil(t, pl.SubmitAfter(2, 200*time.Millisecond))
+
+	// 超时返回上下文错误
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, pl.WaitIdle(ctx))
+
+	assert.Nil(t, pl.WaitIdle(context.Background()))
+	assert.Equal(t, int64(0), pl.Stats().Pending)
+	assert.Equal(t, int64(2), pl.Stats().Processed)
+
+	// 等待后管道仍然接收新任务
+	assert.Nil(t, pl.Submit(3))
+
+	// 停止管道会唤醒正在等待被放弃的延迟任务的调用者
+	assert.Nil(t, pl.SubmitAfter(4, time.Minute))
+	waited := make(chan struct{})
+	go func() {
+		defer close(waited)
+		_ = pl.WaitIdle(context.Background())
+	}()
+	time.Sleep(20 * time.Millisecond)
+	pl.Stop()
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("WaitIdle did not return after Stop")
+	}
+
+	// 停止后立即返回 ErrorQueueClosed
+	assert.Equal(t, k.ErrorQueueClosed, pl.WaitIdle(context.Background()))
+}
+
+// TestPipeline_WaitForScheduled tests that WaitForScheduled returns once all delayed tasks have completed
+func TestPipeline_WaitForScheduled(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(handleFunc).WithWorkerNumber(2).WithGetMode(k.GetPolling)
+	queue := wkq.NewDelayingQueue(nil)
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	// 没有延迟任务时立即返回
+	assert.Nil(t, pl.WaitForScheduled(context.Background()))
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, pl.SubmitAfter(i, 100*time.Millisecond))
+	}
+
+	// 超时返回上下文错误
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, pl.WaitForScheduled(ctx))
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel2()
+	assert.Nil(t, pl.WaitForScheduled(ctx2))
+	assert.Equal(t, int64(3), pl.Stats().Processed)
+
+	// 停止管道会唤醒正在等待被放弃的延迟任务的调用者
+	assert.Nil(t, pl.SubmitAfter(3, time.Minute))
+	waited := make(chan struct{})
+	go func() {
+		defer close(waited)
+		_ = pl.WaitForScheduled(context.Background())
+	}()
+	time.Sleep(20 * time.Millisecond)
+	pl.Stop()
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("WaitForScheduled did not return after Stop")
+	}
+
+	// 停止后立即返回 ErrorQueueClosed
+	assert.Equal(t, k.ErrorQueueClosed, pl.WaitForScheduled(context.Background()))
+}
+
+// TestPipeline_WaitForScheduled_FastWorker tests that delayed tasks handled before the put returns are still counted and released
+func TestPipeline_WaitForScheduled_FastWorker(t *testing.T) {
+	c := k.NewConfig()
+	c.WithSingleWorker().WithGetMode(k.GetPolling).WithGetPollInterval(time.Millisecond)
+	queue := &slowPutQueue{k.NewFakeDelayingQueue(wkq.NewQueue(nil))}
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	// 假队列立即放入延迟元素，工作协程可能在 PutWithDelay 返回之前完成任务
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, pl.SubmitAfter(i, time.Millisecond))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.Nil(t, pl.WaitForScheduled(ctx))
+	assert.Nil(t, pl.WaitIdle(ctx))
+	assert.Equal(t, int64(10), pl.Stats().Processed)
+
+	pl.Stop()
+}
+
+// recordingPool is an element pool recording how many elements were created and whether returned elements were reset
+type recordingPool struct {
+	lock     sync.Mutex
+	free     []*k.ElementExt
+	created  int
+	returned int
+	dirty    int
+}
+
+func (p *recordingPool) Get() *k.ElementExt {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if n := len(p.free); n > 0 {
+		element := p.free[n-1]
+		p.free = p.free[:n-1]
+		return element
+	}
+	p.created++
+	return &k.ElementExt{}
+}
+
+func (p *recordingPool) Put(element *k.ElementExt) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if element.GetData() != nil {
+		p.dirty++
+	}
+	p.returned++
+	p.free = append(p.free, element)
+}
+
+// TestPipeline_WithElementPool tests that a custom element pool is used and receives reset elements
+func TestPipeline_WithElementPool(t *testing.T) {
+	pool := &recordingPool{}
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithElementPool(pool)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, pl.Submit(make([]byte, 1024*1024)))
+	}
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+	assert.Greater(t, pool.created, 0)
+	assert.Equal(t, 10, pool.returned)
+	assert.Equal(t, 0, pool.dirty)
+	assert.Equal(t, int64(0), pl.PoolStats().Outstanding)
+}
+
+// TestPipeline_SubmitWithDeadline tests that a task picked up after its deadline is skipped with ErrDeadlineExceeded
+func TestPipeline_SubmitWithDeadline(t *testing.T) {
+	recorder := &errorRecorder{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var handled sync.Map
+
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithCallback(recorder).WithRetry(3, 0).WithHandleFunc(func(msg any) (any, error) {
+		if msg == "block" {
+			close(started)
+			<-release
+		}
+		handled.Store(msg, true)
+		return msg, nil
+	})
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+	pl.SetMaxWorkers(1)
+
+	// 占用唯一的工作协程，使带截止时间的任务在队列中过期
+	assert.Nil(t, pl.Submit("block"))
+	<-started
+	assert.Nil(t, pl.SubmitWithDeadline("stale", time.Now().Add(20*time.Millisecond)))
+	assert.Nil(t, pl.SubmitWithDeadline("fresh", time.Now().Add(time.Hour)))
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+	err, ok := recorder.Get("stale")
+	assert.True(t, ok)
+	assert.Equal(t, k.ErrDeadlineExceeded, err)
+	_, ok = handled.Load("stale")
+	assert.False(t, ok)
+
+	err, ok = recorder.Get("fresh")
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), pl.Stats().Failed)
+}
+
+// TestPipeline_IsRunning tests the read-only health check before and after stopping
+func TestPipeline_IsRunning(t *testing.T) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(2)
+
+	pl := k.NewPipeline(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), c)
+	assert.NotNil(t, pl)
+	assert.True(t, pl.IsRunning())
+	assert.Equal(t, int64(0), pl.Stats().Processed)
+	pl.Stop()
+	assert.False(t, pl.IsRunning())
+
+	// 队列被外部关闭时同样视为停止
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+	pl = k.NewPipeline(queue, c)
+	assert.True(t, pl.IsRunning())
+	queue.Shutdown()
+	assert.False(t, pl.IsRunning())
+	pl.Stop()
+
+	pl = k.NewPipeline(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), c)
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+	assert.False(t, pl.IsRunning())
+}
+
+// TestPipeline_WithName tests that the name is exposed and prefixes the log lines
+func TestPipeline_WithName(t *testing.T) {
+	logger := &recordingLogger{}
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithLogger(logger).WithName("orders")
+
+	pl := k.NewPipeline(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), c)
+	assert.NotNil(t, pl)
+	assert.Equal(t, "orders", pl.Name())
+	pl.Stop()
+
+	assert.Equal(t, k.ErrorQueueClosed, pl.Submit(1))
+	assert.Equal(t, 1, len(logger.warns))
+	assert.True(t, strings.HasPrefix(logger.warns[0], "karta[orders]: "))
+
+	// 未设置名称时使用默认前缀
+	pl = k.NewPipeline(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), k.NewConfig().WithLogger(logger))
+	assert.Equal(t, "", pl.Name())
+	pl.Stop()
+	assert.Equal(t, k.ErrorQueueClosed, pl.Submit(1))
+	assert.True(t, strings.HasPrefix(logger.warns[1], "karta: "))
+}
+
+// TestPipeline_SetHandleFunc tests that tasks submitted after SetHandleFunc use the new default handler
+func TestPipeline_SetHandleFunc(t *testing.T) {
+	recorder := &resultRecorder{results: make(map[any]any)}
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithCallback(recorder).WithHandleFunc(func(msg any) (any, error) {
+		return "old", nil
+	})
+
+	pl := k.NewPipeline(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), c)
+	assert.NotNil(t, pl)
+
+	assert.Nil(t, pl.Submit(1))
+	assert.Nil(t, pl.WaitIdle(context.Background()))
+
+	pl.SetHandleFunc(func(msg any) (any, error) {
+		return "new", nil
+	})
+	assert.Nil(t, pl.Submit(2))
+	assert.Nil(t, pl.SubmitWithFunc(func(msg any) (any, error) { return "own", nil }, 3))
+	assert.Nil(t, pl.WaitIdle(context.Background()))
+
+	// nil 恢复为默认处理函数
+	pl.SetHandleFunc(nil)
+	assert.Nil(t, pl.Submit(4))
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	assert.Equal(t, map[any]any{1: "old", 2: "new", 3: "own", 4: 4}, recorder.results)
+}
+
+// resultRecorder is a callback recording the result of every message
+type resultRecorder struct {
+	lock    sync.Mutex
+	results map[any]any
+}
+
+func (r *resultRecorder) OnBefore(msg any) {}
+
+func (r *resultRecorder) OnAfter(msg, result any, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.results[msg] = result
+}
+
+// TestPipeline_WithDedup tests dropping and coalescing of messages whose key is already queued or being handled
+func TestPipeline_WithDedup(t *testing.T) {
+	for _, mode := range []k.DedupMode{k.DedupDrop, k.DedupCoalesce} {
+		started := make(chan struct{}, 1)
+		release := make(chan struct{})
+		var handled atomic.Int32
+
+		c := k.NewConfig()
+		c.WithWorkerNumber(2).WithDedupMode(mode).WithDedup(func(msg any) string {
+			return msg.(string)[:1]
+		}).WithHandleFunc(func(msg any) (any, error) {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			<-release
+			handled.Add(1)
+			return msg, nil
+		})
+
+		pl := k.NewPipeline(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), c)
+		assert.NotNil(t, pl)
+
+		first, err := pl.SubmitFuture("a1")
+		assert.Nil(t, err)
+		<-started
+
+		// 相同键的任务正在处理中
+		future, err := pl.SubmitFuture("a2")
+		if mode == k.DedupDrop {
+			assert.Equal(t, k.ErrDuplicate, err)
+			assert.Nil(t, future)
+		} else {
+			assert.Nil(t, err)
+		}
+
+		// 不同键的任务不受影响
+		assert.Nil(t, pl.Submit("b1"))
+		close(release)
+		assert.Nil(t, pl.WaitIdle(context.Background()))
+		assert.Equal(t, int32(2), handled.Load())
+
+		// 合并的提交者与已有任务的提交者收到相同的结果
+		result, err := first.Get(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, "a1", result)
+		if mode == k.DedupCoalesce {
+			result, err = future.Get(context.Background())
+			assert.Nil(t, err)
+			assert.Equal(t, "a1", result)
+		}
+
+		// 完成后键被释放
+		assert.Nil(t, pl.Submit("a3"))
+		assert.Nil(t, pl.StopAndDrain(context.Background()))
+		assert.Equal(t, int32(3), handled.Load())
+	}
+}
+
+// TestPipeline_SubmitWithResultChan tests per-submission result delivery and that an abandoned channel does not block Stop
+func TestPipeline_SubmitWithResultChan(t *testing.T) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithHandleFunc(func(msg any) (any, error) {
+		return msg.(int) * 2, nil
+	})
+
+	pl := k.NewPipeline(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), c)
+	assert.NotNil(t, pl)
+
+	ch0 := make(chan k.TaskResult, 1)
+	ch1 := make(chan k.TaskResult, 1)
+	assert.Nil(t, pl.SubmitWithResultChan(nil, 1, ch0))
+	assert.Nil(t, pl.SubmitWithResultChan(func(msg any) (any, error) { return nil, assert.AnError }, 2, ch1))
+
+	assert.Equal(t, k.TaskResult{Input: 1, Output: 2}, <-ch0)
+	assert.Equal(t, k.TaskResult{Input: 2, Err: assert.AnError}, <-ch1)
+
+	// 无人接收的无缓冲通道不会阻塞 Stop
+	abandoned := make(chan k.TaskResult)
+	assert.Nil(t, pl.SubmitWithResultChan(nil, 3, abandoned))
+	assert.Nil(t, pl.StopWithTimeout(5*time.Second))
+	assert.Equal(t, 0, len(ch0)+len(ch1))
+}
+
+// TestPipeline_PendingCount tests that PendingCount reports tasks not yet picked up by a worker
+func TestPipeline_PendingCount(t *testing.T) {
+	release := make(chan struct{})
+	c := k.NewConfig()
+	c.WithSingleWorker().WithHandleFunc(func(msg any) (any, error) {
+		<-release
+		return msg, nil
+	})
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+	assert.Equal(t, int64(0), pl.PendingCount())
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, pl.Submit(i))
+	}
+
+	// 唯一的工作协程取出第一个任务后阻塞，其余任务留在队列中
+	assert.Eventually(t, func() bool { return pl.Stats().InFlight == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int64(4), pl.PendingCount())
+	assert.Equal(t, int64(5), pl.Stats().Pending)
+
+	close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.Nil(t, pl.WaitIdle(ctx))
+	assert.Equal(t, int64(0), pl.PendingCount())
+
+	pl.Stop()
+}
+
+// slowPutQueue is a queue pausing after each put, so workers can dequeue an element before the put returns
+type slowPutQueue struct {
+	*k.FakeDelayingQueue
+}
+
+func (q *slowPutQueue) Put(value any) error {
+	err := q.FakeDelayingQueue.Put(value)
+	time.Sleep(2 * time.Millisecond)
+	return err
+}
+
+func (q *slowPutQueue) PutWithDelay(value any, delay int64) error {
+	err := q.FakeDelayingQueue.PutWithDelay(value, delay)
+	time.Sleep(2 * time.Millisecond)
+	return err
+}
+
+// TestPipeline_PendingCount_NeverNegative tests that tasks are counted as queued before workers can dequeue them
+func TestPipeline_PendingCount_NeverNegative(t *testing.T) {
+	c := k.NewConfig()
+	c.WithSingleWorker().WithGetMode(k.GetPolling).WithGetPollInterval(time.Millisecond)
+	queue := &slowPutQueue{k.NewFakeDelayingQueue(wkq.NewQueue(nil))}
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	// 工作协程在 Put 返回之前取出任务时，计数已经包含该任务，因此积压数量不会为负
+	var negative atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				if pl.PendingCount() < 0 {
+					negative.Store(true)
+				}
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, pl.Submit(i))
+	}
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+	close(done)
+
+	assert.False(t, negative.Load())
+	assert.Equal(t, int64(0), pl.PendingCount())
+	assert.Equal(t, int64(0), pl.Stats().Pending)
+}
+
+// TestPipeline_NilConfig tests that a pipeline created with a nil config runs tasks with the default handler
+func TestPipeline_NilConfig(t *testing.T) {
+	pl := k.NewPipeline(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), nil)
+	assert.NotNil(t, pl)
+
+	// 默认处理函数原样返回输入
+	future, err := pl.SubmitFuture(1)
+	assert.Nil(t, err)
+	result, err := future.Get(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result)
+	pl.Stop()
+
+	pl, err = k.NewPipelineWithError(k.NewFakeDelayingQueue(wkq.NewQueue(nil)), nil)
+	assert.Nil(t, err)
+	assert.Nil(t, pl.Submit(2))
+	assert.Nil(t, pl.WaitIdle(context.Background()))
+	assert.Equal(t, int64(1), pl.Stats().Processed)
+	assert.Equal(t, int64(0), pl.Stats().Failed)
+
+	pl.Stop()
+}
+
+// TestPipeline_StrictHandler tests that submissions without a handler are rejected in the strict handler mode
+func TestPipeline_StrictHandler(t *testing.T) {
+	c := k.NewConfig()
+	c.WithStrictHandler()
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	assert.Equal(t, k.ErrNoHandler, pl.Submit(1))
+	assert.Equal(t, k.ErrNoHandler, pl.SubmitWithFunc(nil, 1))
+	assert.Equal(t, int64(0), pl.Stats().Pending)
+
+	// 携带处理函数的提交不受影响
+	handled := make(chan any, 1)
+	assert.Nil(t, pl.SubmitWithFunc(func(msg any) (any, error) {
+		handled <- msg
+		return msg, nil
+	}, 2))
+	assert.Equal(t, 2, <-handled)
+
+	// 设置默认处理函数后可以正常提交，设置为 nil 会再次移除它
+	pl.SetHandleFunc(func(msg any) (any, error) {
+		handled <- msg
+		return msg, nil
+	})
+	assert.Nil(t, pl.Submit(3))
+	assert.Equal(t, 3, <-handled)
+	pl.SetHandleFunc(nil)
+	assert.Equal(t, k.ErrNoHandler, pl.Submit(4))
+
+	pl.Stop()
+}
+
+// TestPipeline_SubmitWait tests that SubmitWait blocks until a worker becomes available
+func TestPipeline_SubmitWait(t *testing.T) {
+	release := make(chan struct{})
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithHandleFunc(func(msg any) (any, error) {
+		<-release
+		return msg, nil
+	})
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	// 占满两个工作协程
+	assert.Nil(t, pl.SubmitWait(context.Background(), 0))
+	assert.Nil(t, pl.SubmitWait(context.Background(), 1))
+	assert.Eventually(t, func() bool { return pl.Stats().InFlight == 2 }, time.Second, 5*time.Millisecond)
+
+	// 所有工作协程都在忙碌时等待直到 ctx 结束
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, pl.SubmitWait(ctx, 2))
+
+	// 释放一个工作协程后等待中的提交返回
+	done := make(chan error, 1)
+	go func() { done <- pl.SubmitWait(context.Background(), 3) }()
+	select {
+	case <-done:
+		t.Fatal("SubmitWait returned while every worker was busy")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release <- struct{}{}
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("SubmitWait did not return after a worker was freed")
+	}
+
+	close(release)
+	pl.Stop()
+}
+
+// TestPipeline_Durations tests that handler durations are recorded when timing is enabled
+func TestPipeline_Durations(t *testing.T) {
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		time.Sleep(5 * time.Millisecond)
+		return msg, nil
+	}).WithTiming()
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, pl.Submit(i))
+	}
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+	d := pl.Durations()
+	assert.Equal(t, int64(10), d.Count)
+	assert.GreaterOrEqual(t, d.Min, 5*time.Millisecond)
+	assert.GreaterOrEqual(t, d.P99, d.P50)
+	assert.GreaterOrEqual(t, d.Max, d.P99)
+}
+
+// TestPipeline_OnError tests that OnError is called once a task finally fails
+func TestPipeline_OnError(t *testing.T) {
+	callback := &failureRecorder{}
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		if msg.(int) == 1 {
+			return nil, errSentinel
+		}
+		return msg, nil
+	}).WithCallback(callback).WithRetry(2, time.Millisecond)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, pl.Submit(i))
+	}
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+	// 重试耗尽后只通知一次
+	assert.Equal(t, int32(3), atomic.LoadInt32(&callback.after))
+	assert.Equal(t, map[any]error{1: errSentinel}, callback.errors)
+}
+
+// workerRecorder is a callback recording worker start and stop events in addition to counting OnBefore and OnAfter calls
+type workerRecorder struct {
+	countingCallback
+	started atomic.Int64
+	stopped atomic.Int64
+	drained atomic.Bool
+}
+
+func (c *workerRecorder) OnWorkerStart(running int64) { c.started.Add(1) }
+
+func (c *workerRecorder) OnWorkerStop(running int64) {
+	c.stopped.Add(1)
+	if running == 0 {
+		c.drained.Store(true)
+	}
+}
+
+// TestPipeline_WorkerCallback tests that worker lifecycle events are reported
+func TestPipeline_WorkerCallback(t *testing.T) {
+	release := make(chan struct{})
+	callback := &workerRecorder{}
+	c := k.NewConfig()
+	c.WithWorkerNumber(4).WithHandleFunc(func(msg any) (any, error) {
+		<-release
+		return msg, nil
+	}).WithCallback(callback)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, pl.Submit(i))
+	}
+	assert.Eventually(t, func() bool { return callback.started.Load() == 4 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int64(4), pl.GetWorkerNumber())
+
+	close(release)
+	pl.Stop()
+
+	// 所有工作协程退出，最后一个退出时运行数量为 0
+	assert.Equal(t, int64(4), callback.stopped.Load())
+	assert.True(t, callback.drained.Load())
+}
+
+// TestPipeline_WithClock_IdleReaping tests that idle workers are reaped by advancing a fake clock
+func TestPipeline_WithClock_IdleReaping(t *testing.T) {
+	release := make(chan struct{})
+	clock := k.NewFakeClock(time.Now())
+	c := k.NewConfig()
+	c.WithWorkerNumber(4).WithHandleFunc(func(msg any) (any, error) {
+		<-release
+		return msg, nil
+	}).WithClock(clock)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, pl.Submit(i))
+	}
+	assert.Eventually(t, func() bool { return pl.Stats().InFlight == 4 }, time.Second, time.Millisecond)
+	close(release)
+	assert.Eventually(t, func() bool { return pl.Stats().Pending == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, int64(4), pl.GetWorkerNumber())
+
+	// 推进假时钟超过空闲超时，多余的工作协程退出，无需真实等待
+	assert.Eventually(t, func() bool {
+		clock.Advance(time.Second)
+		return pl.GetWorkerNumber() == 1
+	}, 2*time.Second, time.Millisecond)
+
+	pl.Stop()
+}
+
+// TestPipeline_WithClock_Deadline tests that task deadlines follow the fake clock
+func TestPipeline_WithClock_Deadline(t *testing.T) {
+	now := time.Now()
+	clock := k.NewFakeClock(now)
+	recorder := &failureRecorder{}
+	c := k.NewConfig()
+	c.WithCallback(recorder).WithClock(clock)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	// 按假时钟计算，第一个任务尚未过期，第二个已经过期
+	assert.Nil(t, pl.SubmitWithDeadline(1, now.Add(time.Minute)))
+	assert.Nil(t, pl.SubmitWithDeadline(2, now.Add(-time.Minute)))
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&recorder.after))
+	assert.Equal(t, map[any]error{2: k.ErrDeadlineExceeded}, recorder.errors)
+}
+
+// TestPipeline_WithClock_Durations tests that handler durations are measured with the fake clock
+func TestPipeline_WithClock_Durations(t *testing.T) {
+	clock := k.NewFakeClock(time.Now())
+	recorder := &metaRecorder{metas: make(map[any]k.TaskMeta)}
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		// 处理函数执行期间推进假时钟
+		clock.Advance(50 * time.Millisecond)
+		return msg, nil
+	}).WithSingleWorker().WithTiming().WithCallback(recorder).WithClock(clock).WithNoIdleReaping()
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	assert.Nil(t, pl.Submit(1))
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+	assert.Equal(t, int64(1), pl.Durations().Count)
+	assert.Equal(t, 50*time.Millisecond, pl.Durations().Max)
+	assert.Equal(t, 50*time.Millisecond, recorder.metas[1].Duration)
+}
+
+// errRejected is returned by rejectingQueue for delayed puts
+var errRejected = errors.New("put rejected")
+
+// rejectingQueue is a queue rejecting every delayed put
+type rejectingQueue struct {
+	*k.FakeDelayingQueue
+}
+
+func (q *rejectingQueue) PutWithDelay(value any, delay int64) error { return errRejected }
+
+// dropRecorder is a callback recording dropped messages in addition to counting OnBefore and OnAfter calls
+type dropRecorder struct {
+	countingCallback
+	lock    sync.Mutex
+	dropped map[any]error
+}
+
+func (c *dropRecorder) OnDrop(msg any, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dropped[msg] = err
+}
+
+// TestPipeline_OnDrop tests that OnDrop is called when the queue rejects a message
+func TestPipeline_OnDrop(t *testing.T) {
+	callback := &dropRecorder{dropped: make(map[any]error)}
+	c := k.NewConfig()
+	c.WithCallback(callback)
+	queue := &rejectingQueue{k.NewFakeDelayingQueue(wkq.NewQueue(nil))}
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	assert.Nil(t, pl.Submit(1))
+	assert.Equal(t, errRejected, pl.SubmitAfter(2, time.Second))
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+	// 只有被拒绝的消息会触发 OnDrop，并且不会被处理
+	assert.Equal(t, map[any]error{2: errRejected}, callback.dropped)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&callback.after))
+	stats := pl.PoolStats()
+	assert.Equal(t, int64(2), stats.Gets)
+	assert.Equal(t, int64(0), stats.Outstanding)
+}
+
+// deadLetters records the messages routed to the dead-letter function
+type deadLetters struct {
+	lock sync.Mutex
+	msgs map[any]error
+}
+
+func (d *deadLetters) add(msg any, lastErr error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.msgs[msg] = lastErr
+}
+
+// TestPipeline_WithDeadLetter tests that messages failing all their attempts are dead-lettered
+func TestPipeline_WithDeadLetter(t *testing.T) {
+	var attempts sync.Map
+	letters := &deadLetters{msgs: make(map[any]error)}
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		n, _ := attempts.LoadOrStore(msg, new(atomic.Int32))
+		count := n.(*atomic.Int32).Add(1)
+		// 消息 1 总是失败，消息 2 在第二次尝试时成功
+		if msg.(int) == 1 || (msg.(int) == 2 && count < 2) {
+			return nil, errSentinel
+		}
+		return msg, nil
+	}).WithRetry(3, time.Millisecond).WithDeadLetter(letters.add)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, pl.Submit(i))
+	}
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+	assert.Equal(t, map[any]error{1: errSentinel}, letters.msgs)
+	n, _ := attempts.Load(1)
+	assert.Equal(t, int32(3), n.(*atomic.Int32).Load())
+}
+
+// TestPipeline_WithNoIdleReaping tests that idle workers keep running until Stop
+func TestPipeline_WithNoIdleReaping(t *testing.T) {
+	release := make(chan struct{})
+	clock := k.NewFakeClock(time.Now())
+	c := k.NewConfig()
+	c.WithWorkerNumber(4).WithHandleFunc(func(msg any) (any, error) {
+		<-release
+		return msg, nil
+	}).WithClock(clock).WithNoIdleReaping()
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, pl.Submit(i))
+	}
+	assert.Eventually(t, func() bool { return pl.Stats().InFlight == 4 }, time.Second, time.Millisecond)
+	close(release)
+	assert.Eventually(t, func() bool { return pl.Stats().Pending == 0 }, time.Second, time.Millisecond)
+
+	// 推进假时钟远超空闲超时，工作协程仍然保持运行
+	for i := 0; i < 30; i++ {
+		clock.Advance(time.Second)
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, int64(4), pl.GetWorkerNumber())
+
+	// 降低上限后仍会回收多余的工作协程
+	pl.SetMaxWorkers(2)
+	assert.Eventually(t, func() bool {
+		clock.Advance(time.Second)
+		return pl.GetWorkerNumber() == 2
+	}, 2*time.Second, time.Millisecond)
+
+	pl.Stop()
+}
+
+// TestPipeline_WithOrderedCallbacks tests that callbacks follow the submission order regardless of completion order
+func TestPipeline_WithOrderedCallbacks(t *testing.T) {
+	recorder := &orderRecorder{}
+	c := k.NewConfig()
+	c.WithWorkerNumber(8).WithWorkerSpawnRate(1000, 64).WithHandleFunc(func(msg any) (any, error) {
+		// 越早提交的任务耗时越长
+		time.Sleep(time.Duration(10-msg.(int)) * 2 * time.Millisecond)
+		return msg, nil
+	}).WithCallback(recorder).WithOrderedCallbacks().WithDedup(func(msg any) string { return fmt.Sprint(msg) })
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	expected := make([]string, 0, 20)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, pl.Submit(i))
+		expected = append(expected, fmt.Sprintf("before:%d", i), fmt.Sprintf("after:%d", i))
+		// 被拒绝的重复消息不会阻塞之后的回调
+		if i == 3 {
+			assert.Equal(t, k.ErrDuplicate, pl.Submit(i))
+		}
+	}
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+	assert.Equal(t, expected, recorder.events)
+}
+
+// TestPipeline_ErrRetryAfter tests that a handler can re-queue its task and that the re-queues are capped
+func TestPipeline_ErrRetryAfter(t *testing.T) {
+	var calls sync.Map
+	callback := &pairingRecorder{}
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		n, _ := calls.LoadOrStore(msg, new(atomic.Int32))
+		count := n.(*atomic.Int32).Add(1)
+		// 消息 1 在第三次调用时成功，消息 2 总是请求重试
+		if msg.(int) == 1 && count < 3 {
+			return nil, k.ErrRetryAfter(time.Millisecond)
+		}
+		if msg.(int) == 2 {
+			return nil, k.ErrRetryAfter(0)
+		}
+		return msg, nil
+	}).WithCallback(callback)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	assert.Nil(t, pl.Submit(1))
+	assert.Nil(t, pl.Submit(2))
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+	// 重新入队不会调用 OnAfter，只有最终结果会传递
+	err, ok := callback.Get(1)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	n, _ := calls.Load(1)
+	assert.Equal(t, int32(3), n.(*atomic.Int32).Load())
+
+	// 超过重新入队上限后按普通失败处理
+	err, _ = callback.Get(2)
+	var retryAfter *k.RetryAfterError
+	assert.ErrorAs(t, err, &retryAfter)
+	n, _ = calls.Load(2)
+	assert.Equal(t, int32(65), n.(*atomic.Int32).Load())
+
+	// 每个任务只调用一次 OnBefore 和 OnAfter
+	for _, msg := range []int{1, 2} {
+		before, after := callback.Counts(msg)
+		assert.Equal(t, int32(1), before)
+		assert.Equal(t, int32(1), after)
+	}
+}
+
+// TestPipeline_StopAndCollect tests that the tasks left in the queue are returned instead of handled
+func TestPipeline_StopAndCollect(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var handled sync.Map
+	c := k.NewConfig()
+	c.WithSingleWorker().WithHandleFunc(func(msg any) (any, error) {
+		started <- struct{}{}
+		<-release
+		handled.Store(msg, true)
+		return msg, nil
+	})
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	assert.Nil(t, pl.Submit(0))
+	<-started
+	future, err := pl.SubmitFuture(1)
+	assert.Nil(t, err)
+	for i := 2; i < 5; i++ {
+		assert.Nil(t, pl.Submit(i))
+	}
+
+	// 正在处理的任务完成后才会返回
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	assert.Equal(t, []any{1, 2, 3, 4}, pl.StopAndCollect())
+
+	_, ok := handled.Load(0)
+	assert.True(t, ok)
+	_, ok = handled.Load(1)
+	assert.False(t, ok)
+
+	// 等待被收集任务结果的提交者会收到 ErrorQueueClosed
+	_, err = future.Get(context.Background())
+	assert.Equal(t, k.ErrorQueueClosed, err)
+	assert.Equal(t, int64(0), pl.PoolStats().Outstanding)
+	assert.Equal(t, int64(0), pl.Stats().Pending)
+
+	// 管道已经停止
+	assert.Nil(t, pl.StopAndCollect())
+}
+
+// TestPipeline_WithMiddleware tests that middleware wraps both the default and the per-task handlers
+func TestPipeline_WithMiddleware(t *testing.T) {
+	var wrapped atomic.Int32
+	callback := &resultRecorder{results: make(map[any]any)}
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		return msg.(int) * 2, nil
+	}).WithMiddleware(func(next k.MessageHandleFunc) k.MessageHandleFunc {
+		return func(msg any) (any, error) {
+			wrapped.Add(1)
+			return next(msg)
+		}
+	}).WithCallback(callback)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	assert.Nil(t, pl.Submit(1))
+	assert.Nil(t, pl.SubmitWithFunc(func(msg any) (any, error) {
+		return msg.(int) * 3, nil
+	}, 2))
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+	assert.Equal(t, int32(2), wrapped.Load())
+	assert.Equal(t, map[any]any{1: 2, 2: 6}, callback.results)
+}
+
+// TestPipeline_Consume tests that every message of a channel is submitted with backpressure until the channel is closed
+func TestPipeline_Consume(t *testing.T) {
+	var processed atomic.Int32
+	c := k.NewConfig()
+	c.WithHandleFunc(func(msg any) (any, error) {
+		time.Sleep(time.Millisecond)
+		processed.Add(1)
+		return msg, nil
+	}).WithWorkerNumber(4).WithMaxPending(2)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+		for i := 0; i < 6; i++ {
+			ch <- i
+		}
+	}()
+
+	// 管道容量有限时等待而不是丢弃消息
+	assert.Nil(t, pl.Consume(context.Background(), ch))
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+	assert.Equal(t, int32(6), processed.Load())
+
+	// 管道停止后提前结束
+	assert.Equal(t, k.ErrorQueueClosed, pl.Consume(context.Background(), make(chan any, 1)))
+}
+
+// TestPipeline_Consume_Cancel tests that Consume returns the ctx error once ctx is done
+func TestPipeline_Consume_Cancel(t *testing.T) {
+	c := k.NewConfig()
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+	defer pl.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, pl.Consume(ctx, make(chan any)), context.DeadlineExceeded)
+}
+
+// delayRecordingQueue is a queue recording the delay of every delayed put
+type delayRecordingQueue struct {
+	*k.FakeDelayingQueue
+	lock   sync.Mutex
+	delays []int64
+}
+
+func (q *delayRecordingQueue) PutWithDelay(value any, delay int64) error {
+	q.lock.Lock()
+	q.delays = append(q.delays, delay)
+	q.lock.Unlock()
+	return q.FakeDelayingQueue.PutWithDelay(value, delay)
+}
+
+// TestPipeline_SubmitAfterJitter tests that the delay is picked in [base, base+jitter) from the injected source
+func TestPipeline_SubmitAfterJitter(t *testing.T) {
+	c := k.NewConfig()
+	c.WithJitterSource(func(n int64) int64 { return n / 2 })
+	queue := &delayRecordingQueue{FakeDelayingQueue: k.NewFakeDelayingQueue(wkq.NewQueue(nil))}
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+	defer pl.Stop()
+
+	assert.Nil(t, pl.SubmitAfterJitter(1, 100*time.Millisecond, 50*time.Millisecond))
+	assert.Nil(t, pl.SubmitAfterJitter(2, 100*time.Millisecond, 0))
+	assert.Equal(t, []int64{125, 100}, queue.delays)
+
+	// 默认的随机源选取的延迟在 [base, base+jitter) 范围内
+	c = k.NewConfig()
+	queue = &delayRecordingQueue{FakeDelayingQueue: k.NewFakeDelayingQueue(wkq.NewQueue(nil))}
+	pl2 := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl2)
+	defer pl2.Stop()
+
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, pl2.SubmitAfterJitter(i, 100*time.Millisecond, 50*time.Millisecond))
+	}
+	for _, delay := range queue.delays {
+		assert.GreaterOrEqual(t, delay, int64(100))
+		assert.Less(t, delay, int64(150))
+	}
+}
+
+// idRecorder is a callback recording the IDs delivered to OnAfterID in addition to counting OnBefore and OnAfter calls
+type idRecorder struct {
+	countingCallback
+	lock sync.Mutex
+	ids  map[string]any
+}
+
+func (r *idRecorder) OnAfterID(id string, msg, result any, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.ids[id] = result
+}
+
+// TestPipeline_SubmitWithID tests that the ID given at submission is delivered to OnAfterID after OnAfter
+func TestPipeline_SubmitWithID(t *testing.T) {
+	for _, ordered := range []bool{false, true} {
+		recorder := &idRecorder{ids: make(map[string]any)}
+		c := k.NewConfig()
+		c.WithHandleFunc(func(msg any) (any, error) {
+			return msg.(int) * 10, nil
+		}).WithCallback(recorder)
+		if ordered {
+			c.WithOrderedCallbacks()
+		}
+		queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+		pl := k.NewPipeline(queue, c)
+		assert.NotNil(t, pl)
+
+		assert.Nil(t, pl.SubmitWithID("req-1", 1))
+		assert.Nil(t, pl.SubmitWithID("req-2", 2))
+		assert.Nil(t, pl.Submit(3))
+		assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+		// 没有 ID 的任务只调用 OnAfter
+		assert.Equal(t, map[string]any{"req-1": 10, "req-2": 20}, recorder.ids)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&recorder.after))
+	}
+}
+
+// metaRecorder is a callback recording the TaskMeta delivered to OnAfterMeta in addition to counting OnBefore and OnAfter calls
+type metaRecorder struct {
+	countingCallback
+	lock  sync.Mutex
+	metas map[any]k.TaskMeta
+}
+
+func (r *metaRecorder) OnAfterMeta(msg, result any, err error, meta k.TaskMeta) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.metas[msg] = meta
+}
+
+// TestPipeline_OnAfterMeta tests that OnAfterMeta reports whether each task ran a custom handler
+func TestPipeline_OnAfterMeta(t *testing.T) {
+	for _, ordered := range []bool{false, true} {
+		recorder := &metaRecorder{metas: make(map[any]k.TaskMeta)}
+		c := k.NewConfig()
+		c.WithHandleFunc(func(msg any) (any, error) {
+			return msg, nil
+		}).WithCallback(recorder)
+		if ordered {
+			c.WithOrderedCallbacks()
+		}
+		queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+		pl := k.NewPipeline(queue, c)
+		assert.NotNil(t, pl)
+
+		assert.Nil(t, pl.Submit(1))
+		assert.Nil(t, pl.SubmitWithFunc(func(msg any) (any, error) {
+			time.Sleep(20 * time.Millisecond)
+			return msg, nil
+		}, 2))
+		assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+		assert.Equal(t, int32(2), atomic.LoadInt32(&recorder.after))
+		assert.Len(t, recorder.metas, 2)
+		assert.False(t, recorder.metas[1].Custom)
+		assert.True(t, recorder.metas[2].Custom)
+		assert.Equal(t, 1, recorder.metas[1].Attempts)
+		assert.GreaterOrEqual(t, recorder.metas[2].Duration, 20*time.Millisecond)
+	}
+}
+
+// TestPipeline_SubmitWithMeta tests that the metadata reaches the context-aware handler and OnAfterMeta
+func TestPipeline_SubmitWithMeta(t *testing.T) {
+	recorder := &metaRecorder{metas: make(map[any]k.TaskMeta)}
+	var seen sync.Map
+	c := k.NewConfig()
+	c.WithContextHandleFunc(func(ctx context.Context, msg any) (any, error) {
+		tenant := k.MetaFromContext(ctx)["tenant"]
+		seen.Store(msg, tenant)
+		return tenant, nil
+	}).WithCallback(recorder)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	headers := map[string]any{"tenant": "acme"}
+	future, err := pl.SubmitFuture(0)
+	assert.Nil(t, err)
+	assert.Nil(t, pl.SubmitWithMeta(1, headers))
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+
+	// 没有元数据的任务在处理函数中读到 nil
+	result, err := future.Get(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, result)
+	tenant, _ := seen.Load(1)
+	assert.Equal(t, "acme", tenant)
+	assert.Equal(t, headers, recorder.metas[1].Headers)
+	assert.Nil(t, recorder.metas[0].Headers)
+}
+
+// TestPipeline_WithFanOutHandler tests that every output is re-submitted until the handler returns no outputs
+func TestPipeline_WithFanOutHandler(t *testing.T) {
+	var leaves atomic.Int32
+	c := k.NewConfig()
+	c.WithWorkerNumber(4).WithFanOutHandler(func(msg any) ([]any, error) {
+		n := msg.(int)
+		if n == 1 {
+			leaves.Add(1)
+			return nil, nil
+		}
+		return []any{n / 2, n - n/2}, nil
+	})
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	// 排空时同样等待扇出产生的任务
+	assert.Nil(t, pl.Submit(8))
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+	assert.Equal(t, int32(8), leaves.Load())
+	assert.Equal(t, int64(15), pl.Stats().Processed)
+}
+
+// TestPipeline_WithFanOutHandler_SetHandleFunc tests that slice results are not re-submitted once SetHandleFunc replaces the fan-out handler
+func TestPipeline_WithFanOutHandler_SetHandleFunc(t *testing.T) {
+	c := k.NewConfig()
+	c.WithWorkerNumber(2).WithFanOutHandler(func(msg any) ([]any, error) {
+		return nil, nil
+	})
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+
+	// 替换后的普通处理函数返回切片，不应被当作扇出输出重新提交
+	pl.SetHandleFunc(func(msg any) (any, error) {
+		return []any{1, 2, 3}, nil
+	})
+	assert.Nil(t, pl.Submit(0))
+	assert.Nil(t, pl.StopAndDrain(context.Background()))
+	assert.Equal(t, int64(1), pl.Stats().Processed)
+}
+
+// TestPipeline_WithProcessingRate tests that task processing is throttled regardless of the worker number
+func TestPipeline_WithProcessingRate(t *testing.T) {
+	var processed atomic.Int32
+	callback := &errorRecorder{}
+	c := k.NewConfig()
+	c.WithWorkerNumber(4).WithHandleFunc(func(msg any) (any, error) {
+		processed.Add(1)
+		return msg, nil
+	}).WithProcessingRate(20, 1).WithCallback(callback)
+	queue := k.NewFakeDelayingQueue(wkq.NewQueue(nil))
+
+	pl := k.NewPipeline(queue, c)
+	assert.NotNil(t, pl)
+	defer pl.Stop()
+
+	// 每秒 20 个任务，第一个任务之后每 50ms 处理一个
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, pl.Submit(i))
+	}
+	assert.Nil(t, pl.WaitIdle(context.Background()))
+	assert.GreaterOrEqual(t, time.Since(start), 240*time.Millisecond)
+	assert.Equal(t, int32(6), processed.Load())
+
+	// 等待令牌期间任务被取消则以上下文的错误失败，处理函数不会执行
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := pl.SubmitFutureContext(ctx, 10)
+	assert.Nil(t, err)
+	_, err = pl.SubmitFutureContext(ctx, 11)
+	assert.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	assert.Nil(t, pl.WaitIdle(context.Background()))
+
+	err0, _ := callback.Get(10)
+	err1, _ := callback.Get(11)
+	assert.True(t, errors.Is(err0, context.Canceled) || errors.Is(err1, context.Canceled))
+	assert.Less(t, processed.Load(), int32(8))
+}